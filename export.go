@@ -0,0 +1,130 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ts2/ts2-sim-server/gtfs"
+	"github.com/ts2/ts2-sim-server/simulation"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// exportCommand exports the timetable of the simulation file given in args.
+//
+// If the file is a snapshot, the actual times of the trains at their stops so
+// far are exported together with the scheduled ones.
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	csvFile := flags.String("csv", "", "The filename in which to save the timetable as CSV.")
+	stopTimesFile := flags.String("stoptimes", "", "The filename in which to save the timetable as a GTFS stop_times.txt file.")
+	observed := flags.Bool("observed", false, "Write the actual times of the trains in the GTFS stop_times.txt file instead of the scheduled ones.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage of ts2-sim-server export:
+  ts2-sim-server export [options...] file
+
+ARGUMENTS:
+  file
+		The JSON simulation file or snapshot to export
+
+OPTIONS:
+`)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("please specify a simulation file")
+	}
+	if *csvFile == "" && *stopTimesFile == "" {
+		flags.Usage()
+		return fmt.Errorf("please specify a file to export to")
+	}
+
+	logger = log.New()
+	logger.SetHandler(log.LvlFilterHandler(log.LvlWarn, log.StreamHandler(os.Stderr, log.TerminalFormat())))
+	simulation.InitializeLogger(logger)
+
+	simFile := flags.Arg(0)
+	data, err := ioutil.ReadFile(simFile)
+	if err != nil {
+		return fmt.Errorf("unable to read file %s: %s", simFile, err)
+	}
+	var sim simulation.Simulation
+	if err = json.Unmarshal(data, &sim); err != nil {
+		return fmt.Errorf("unable to load %s: %s", simFile, err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-sim.EventChan:
+			case <-done:
+				return
+			}
+		}
+	}()
+	// Initializing restores the observed stops of snapshots
+	if err = sim.Initialize(); err != nil {
+		return fmt.Errorf("invalid simulation %s: %s", simFile, err)
+	}
+	return writeTimetables(&sim, *csvFile, *stopTimesFile, *observed)
+}
+
+// writeTimetables writes the timetable of the given simulation as CSV to
+// csvFile and as a GTFS stop_times.txt file to stopTimesFile, unless they are
+// empty.
+//
+// If observed is true, the stop_times.txt file holds the actual times of the
+// trains instead of the scheduled ones.
+func writeTimetables(sim *simulation.Simulation, csvFile, stopTimesFile string, observed bool) error {
+	if csvFile != "" {
+		f, err := os.Create(csvFile)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %s", csvFile, err)
+		}
+		defer f.Close()
+		if err = sim.WriteTimetableCSV(f); err != nil {
+			return err
+		}
+		logger.Info("Timetable written", "file", csvFile)
+	}
+	if stopTimesFile != "" {
+		f, err := os.Create(stopTimesFile)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %s", stopTimesFile, err)
+		}
+		defer f.Close()
+		if observed {
+			err = gtfs.WriteObservedStopTimes(f, sim.ObservedStops())
+		} else {
+			err = gtfs.WriteStopTimes(f, sim.Services)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("Stop times written", "file", stopTimesFile)
+	}
+	return nil
+}
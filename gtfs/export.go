@@ -0,0 +1,113 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package gtfs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// stopTimesHeader is the header of the stop_times.txt files written by this
+// package.
+var stopTimesHeader = []string{
+	"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "pickup_type", "drop_off_type",
+}
+
+// WriteStopTimes writes the scheduled times of the given services as a GTFS
+// stop_times.txt file to w.
+//
+// Trip IDs are the service codes, stop IDs the place codes and stop sequences
+// the numbers of the service lines, starting at 1. Lines which are not
+// mandatory stops have neither pickup nor drop off. Since GTFS requires both
+// times, a missing arrival or departure time is set to the other one.
+func WriteStopTimes(w io.Writer, services map[string]*simulation.Service) error {
+	codes := make([]string, 0, len(services))
+	for code := range services {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(stopTimesHeader); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		for i, line := range services[code].Lines {
+			arrival, departure := stopTimes(line.ScheduledArrivalTime.Time, line.ScheduledDepartureTime.Time)
+			pickupDropOff := ""
+			if !line.MustStop {
+				pickupDropOff = noPickupDropOff
+			}
+			err := cw.Write([]string{code, arrival, departure, line.PlaceCode, strconv.Itoa(i + 1), pickupDropOff, pickupDropOff})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return flush(cw)
+}
+
+// WriteObservedStopTimes writes the actual times of the given observed stops
+// as a GTFS stop_times.txt file to w.
+//
+// Trip IDs, stop IDs and stop sequences are the same as those written by
+// WriteStopTimes, so that planned and actual times can be compared.
+func WriteObservedStopTimes(w io.Writer, stops []*simulation.ObservedStop) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(stopTimesHeader); err != nil {
+		return err
+	}
+	for _, st := range stops {
+		arrival, departure := stopTimes(st.ArrivalTime.Time, st.DepartureTime.Time)
+		err := cw.Write([]string{st.ServiceCode, arrival, departure, st.PlaceCode, strconv.Itoa(st.LineIndex + 1), "", ""})
+		if err != nil {
+			return err
+		}
+	}
+	return flush(cw)
+}
+
+// stopTimes returns the given arrival and departure times formatted for GTFS,
+// each one replacing the other if it is zero.
+func stopTimes(arrival, departure time.Time) (string, string) {
+	if arrival.IsZero() {
+		arrival = departure
+	}
+	if departure.IsZero() {
+		departure = arrival
+	}
+	if arrival.IsZero() {
+		return "", ""
+	}
+	return simulation.FormatTime(arrival), simulation.FormatTime(departure)
+}
+
+// flush flushes the given CSV writer and returns its error, if any.
+func flush(cw *csv.Writer) error {
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("unable to write stop times: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package gtfs
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestExport(t *testing.T) {
+	Convey("Testing GTFS export", t, func() {
+		Convey("Scheduled times should be exported", func() {
+			tt, err := Import(testFeed(testFiles), testMapping())
+			So(err, ShouldBeNil)
+			var buf strings.Builder
+			err = WriteStopTimes(&buf, tt.Services)
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldEqual, "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type\n"+
+				"T1,06:00:30,06:00:30,LFT,1,1,1\n"+
+				"T1,06:01:30,06:02:00,STN,2,,\n"+
+				"T2,24:00:30,24:00:30,STN,1,,\n"+
+				"T2,24:05:00,24:05:00,LFT,2,,\n")
+		})
+		Convey("Observed times should be exported", func() {
+			st1 := &simulation.ObservedStop{ServiceCode: "T1", PlaceCode: "STN", LineIndex: 1}
+			st1.ArrivalTime.Time = simulation.ParseTime("06:02:10").Time
+			st1.DepartureTime.Time = simulation.ParseTime("06:02:40").Time
+			st2 := &simulation.ObservedStop{ServiceCode: "T2", PlaceCode: "LFT", LineIndex: 1}
+			st2.ArrivalTime.Time = simulation.ParseTime("24:06:00").Time
+			var buf strings.Builder
+			err := WriteObservedStopTimes(&buf, []*simulation.ObservedStop{st1, st2})
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldEqual, "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type\n"+
+				"T1,06:02:10,06:02:40,STN,2,,\n"+
+				"T2,24:06:00,24:06:00,LFT,2,,\n")
+		})
+	})
+}
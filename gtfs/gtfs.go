@@ -0,0 +1,415 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+// Package gtfs imports the timetables of GTFS feeds into TS2 simulations.
+//
+// Trips of the feed become Services whose lines are the stops of the trip at
+// the places of the simulation, with a Train running each Service. The feed
+// is related to the simulation by a Mapping.
+package gtfs
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// pickup and drop off type of stop times at which the train does not stop
+const noPickupDropOff = "1"
+
+// A StopMapping maps a GTFS stop to a place of the simulation.
+type StopMapping struct {
+	// PlaceCode is the code of the place of the simulation
+	PlaceCode string `json:"place"`
+	// Tracks maps the GTFS platform codes of the stop to the track codes of
+	// the place. Platform codes not in Tracks are used as track codes.
+	Tracks map[string]string `json:"tracks"`
+}
+
+// An Entry defines how trains whose first stop in the simulation is at a
+// given place appear in the simulation.
+type Entry struct {
+	// TrainHead is the position at which trains appear
+	TrainHead simulation.Position `json:"trainHead"`
+	// AppearBefore is the time in seconds between the appearance of the
+	// train and its departure from the place.
+	AppearBefore int `json:"appearBefore"`
+	// InitialSpeed is the speed of the trains when they appear
+	InitialSpeed float64 `json:"initialSpeed"`
+	// InitialDelay is the delay of the trains when they appear
+	InitialDelay simulation.DelayGenerator `json:"initialDelay"`
+}
+
+// A Mapping relates a GTFS feed to a simulation.
+type Mapping struct {
+	// Stops maps GTFS stop IDs to places. Stops which are not mapped, nor
+	// their parent station, are outside of the simulation.
+	Stops map[string]StopMapping `json:"stops"`
+	// Entries maps the place codes at which trains enter the simulation to
+	// the way they appear.
+	Entries map[string]Entry `json:"entries"`
+	// TrainType is the train type code of the trains
+	TrainType string `json:"trainType"`
+	// RouteTrainTypes maps GTFS route IDs to the train type code of their
+	// trains, instead of TrainType.
+	RouteTrainTypes map[string]string `json:"routeTrainTypes"`
+	// ServiceIDs restricts the import to the trips of these GTFS service IDs
+	// if it is not empty, e.g. to import a single day.
+	ServiceIDs []string `json:"serviceIds"`
+}
+
+// LoadMapping reads the Mapping of the given JSON file.
+func LoadMapping(fileName string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mapping file %s: %s", fileName, err)
+	}
+	var m Mapping
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unable to decode mapping file %s: %s", fileName, err)
+	}
+	return &m, nil
+}
+
+// A Timetable holds the services and trains imported from a GTFS feed.
+type Timetable struct {
+	Services map[string]*simulation.Service `json:"services"`
+	Trains   []*simulation.Train            `json:"trains"`
+}
+
+// stop is a row of stops.txt
+type stop struct {
+	parentStation string
+	platformCode  string
+}
+
+// trip is a row of trips.txt
+type trip struct {
+	id       string
+	routeID  string
+	headsign string
+}
+
+// stopTime is a row of stop_times.txt
+type stopTime struct {
+	tripID        string
+	arrivalTime   string
+	departureTime string
+	stopID        string
+	sequence      int
+	pickupType    string
+	dropOffType   string
+}
+
+// ImportFile imports the timetable of the GTFS zip file with the given name.
+func ImportFile(fileName string, m *Mapping) (*Timetable, error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open GTFS feed %s: %s", fileName, err)
+	}
+	defer zr.Close()
+	return Import(&zr.Reader, m)
+}
+
+// Import imports the timetable of the given GTFS feed.
+//
+// Each trip with at least one stop in the simulation gives a service, whose
+// code is the trip ID, and a train running this service. Trips are read from
+// trips.txt, their stops from stop_times.txt and the platforms of the stops
+// from stops.txt.
+func Import(feed *zip.Reader, m *Mapping) (*Timetable, error) {
+	stops, err := readStops(feed)
+	if err != nil {
+		return nil, err
+	}
+	trips, err := readTrips(feed, m)
+	if err != nil {
+		return nil, err
+	}
+	stopTimes, err := readStopTimes(feed, trips)
+	if err != nil {
+		return nil, err
+	}
+	tt := Timetable{
+		Services: make(map[string]*simulation.Service),
+		Trains:   []*simulation.Train{},
+	}
+	for _, tr := range trips {
+		sts := stopTimes[tr.id]
+		sort.Slice(sts, func(i, j int) bool {
+			return sts[i].sequence < sts[j].sequence
+		})
+		svc, err := m.service(tr, sts, stops)
+		if err != nil {
+			return nil, fmt.Errorf("trip %s: %s", tr.id, err)
+		}
+		if svc == nil {
+			continue
+		}
+		train, err := m.train(tr, svc)
+		if err != nil {
+			return nil, fmt.Errorf("trip %s: %s", tr.id, err)
+		}
+		tt.Services[tr.id] = svc
+		tt.Trains = append(tt.Trains, train)
+	}
+	sort.SliceStable(tt.Trains, func(i, j int) bool {
+		ti, tj := tt.Trains[i], tt.Trains[j]
+		if !ti.AppearTime.Equal(tj.AppearTime.Time) {
+			return ti.AppearTime.Time.Before(tj.AppearTime.Time)
+		}
+		return ti.ServiceCode < tj.ServiceCode
+	})
+	return &tt, nil
+}
+
+// service returns the service of the given trip with the given stop times,
+// or nil if none of them is in the simulation.
+func (m *Mapping) service(tr *trip, sts []*stopTime, stops map[string]*stop) (*simulation.Service, error) {
+	var svc simulation.Service
+	for i, st := range sts {
+		sm, ok := m.stopMapping(st.stopID, stops)
+		if !ok {
+			continue
+		}
+		line := simulation.ServiceLine{
+			MustStop:  st.pickupType != noPickupDropOff || st.dropOffType != noPickupDropOff,
+			PlaceCode: sm.PlaceCode,
+		}
+		if s, ok := stops[st.stopID]; ok && s.platformCode != "" {
+			line.TrackCode = s.platformCode
+			if tc, ok := sm.Tracks[s.platformCode]; ok {
+				line.TrackCode = tc
+			}
+		}
+		var err error
+		if i > 0 {
+			// Trains start from their first stop without arriving there
+			if line.ScheduledArrivalTime, err = parseTime(st.arrivalTime); err != nil {
+				return nil, err
+			}
+		}
+		if i < len(sts)-1 {
+			if line.ScheduledDepartureTime, err = parseTime(st.departureTime); err != nil {
+				return nil, err
+			}
+		}
+		svc.Lines = append(svc.Lines, &line)
+	}
+	if len(svc.Lines) == 0 {
+		return nil, nil
+	}
+	svc.Description = tr.headsign
+	if svc.Description == "" {
+		svc.Description = fmt.Sprintf("%s->%s", svc.Lines[0].PlaceCode, svc.Lines[len(svc.Lines)-1].PlaceCode)
+	}
+	svc.PlannedTrainTypeCode = m.trainType(tr)
+	if svc.PlannedTrainTypeCode == "" {
+		return nil, fmt.Errorf("no train type for route %s", tr.routeID)
+	}
+	return &svc, nil
+}
+
+// train returns the train running the given service of the given trip.
+func (m *Mapping) train(tr *trip, svc *simulation.Service) (*simulation.Train, error) {
+	first := svc.Lines[0]
+	entry, ok := m.Entries[first.PlaceCode]
+	if !ok {
+		return nil, fmt.Errorf("no entry for place %s", first.PlaceCode)
+	}
+	appearTime := first.ScheduledDepartureTime.Time
+	if appearTime.IsZero() {
+		appearTime = first.ScheduledArrivalTime.Time
+	}
+	return &simulation.Train{
+		AppearTime:    simulation.Time{Time: appearTime.Add(-time.Duration(entry.AppearBefore) * time.Second)},
+		InitialDelay:  entry.InitialDelay,
+		InitialSpeed:  entry.InitialSpeed,
+		ServiceCode:   tr.id,
+		Speed:         entry.InitialSpeed,
+		Status:        simulation.Inactive,
+		TrainTypeCode: svc.PlannedTrainTypeCode,
+		TrainHead:     entry.TrainHead,
+	}, nil
+}
+
+// stopMapping returns the StopMapping of the GTFS stop with the given ID, or
+// of its parent station.
+func (m *Mapping) stopMapping(stopID string, stops map[string]*stop) (StopMapping, bool) {
+	if sm, ok := m.Stops[stopID]; ok {
+		return sm, true
+	}
+	if s, ok := stops[stopID]; ok && s.parentStation != "" {
+		sm, ok := m.Stops[s.parentStation]
+		return sm, ok
+	}
+	return StopMapping{}, false
+}
+
+// trainType returns the train type code of the trains of the given trip.
+func (m *Mapping) trainType(tr *trip) string {
+	if tt, ok := m.RouteTrainTypes[tr.routeID]; ok {
+		return tt
+	}
+	return m.TrainType
+}
+
+// parseTime returns the simulation time of the given GTFS time.
+//
+// GTFS times may be over 24:00:00 for trips running after midnight, in which
+// case 24 hours are added per full day, so that the time of the next day is
+// returned.
+func parseTime(value string) (simulation.Time, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return simulation.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	var hms [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return simulation.Time{}, fmt.Errorf("invalid time: %s", value)
+		}
+		hms[i] = v
+	}
+	t := simulation.ParseTime(fmt.Sprintf("%02d:%02d:%02d", hms[0]%24, hms[1], hms[2]))
+	if t.IsZero() {
+		return simulation.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	return t.Add(time.Duration(hms[0]/24) * 24 * time.Hour), nil
+}
+
+// readStops returns the stops of stops.txt by ID.
+func readStops(feed *zip.Reader) (map[string]*stop, error) {
+	stops := make(map[string]*stop)
+	err := readCSV(feed, "stops.txt", func(row map[string]string) error {
+		stops[row["stop_id"]] = &stop{
+			parentStation: row["parent_station"],
+			platformCode:  row["platform_code"],
+		}
+		return nil
+	})
+	return stops, err
+}
+
+// readTrips returns the trips of trips.txt of the service IDs of m, in the
+// order of the file.
+func readTrips(feed *zip.Reader, m *Mapping) ([]*trip, error) {
+	serviceIDs := make(map[string]bool)
+	for _, id := range m.ServiceIDs {
+		serviceIDs[id] = true
+	}
+	var trips []*trip
+	err := readCSV(feed, "trips.txt", func(row map[string]string) error {
+		if len(serviceIDs) > 0 && !serviceIDs[row["service_id"]] {
+			return nil
+		}
+		trips = append(trips, &trip{
+			id:       row["trip_id"],
+			routeID:  row["route_id"],
+			headsign: row["trip_headsign"],
+		})
+		return nil
+	})
+	return trips, err
+}
+
+// readStopTimes returns the stop times of stop_times.txt of the given trips,
+// by trip ID.
+func readStopTimes(feed *zip.Reader, trips []*trip) (map[string][]*stopTime, error) {
+	stopTimes := make(map[string][]*stopTime)
+	for _, tr := range trips {
+		stopTimes[tr.id] = nil
+	}
+	err := readCSV(feed, "stop_times.txt", func(row map[string]string) error {
+		if _, ok := stopTimes[row["trip_id"]]; !ok {
+			return nil
+		}
+		seq, err := strconv.Atoi(row["stop_sequence"])
+		if err != nil {
+			return fmt.Errorf("invalid stop sequence: %s", row["stop_sequence"])
+		}
+		stopTimes[row["trip_id"]] = append(stopTimes[row["trip_id"]], &stopTime{
+			tripID:        row["trip_id"],
+			arrivalTime:   row["arrival_time"],
+			departureTime: row["departure_time"],
+			stopID:        row["stop_id"],
+			sequence:      seq,
+			pickupType:    row["pickup_type"],
+			dropOffType:   row["drop_off_type"],
+		})
+		return nil
+	})
+	return stopTimes, err
+}
+
+// readCSV calls fn for each row of the CSV file with the given name in the
+// feed, with the values of the row by column name.
+func readCSV(feed *zip.Reader, name string, fn func(map[string]string) error) error {
+	var file *zip.File
+	for _, f := range feed.File {
+		if f.Name == name {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		return fmt.Errorf("missing file in GTFS feed: %s", name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", name, err)
+	}
+	defer rc.Close()
+	r := csv.NewReader(rc)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %s", name, err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %s", name, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(record) {
+				row[strings.TrimSpace(col)] = strings.TrimSpace(record[i])
+			}
+		}
+		if err = fn(row); err != nil {
+			return fmt.Errorf("%s line %d: %s", name, line, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// testFeed returns a GTFS feed with the given files.
+func testFeed(files map[string]string) *zip.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		So(err, ShouldBeNil)
+		_, err = w.Write([]byte(content))
+		So(err, ShouldBeNil)
+	}
+	So(zw.Close(), ShouldBeNil)
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	So(err, ShouldBeNil)
+	return zr
+}
+
+var testFiles = map[string]string{
+	"stops.txt": "\ufeffstop_id,stop_name,parent_station,platform_code\n" +
+		"FAR,Far away,,\n" +
+		"LEFT,Left,,\n" +
+		"STATION,Station,,\n" +
+		"STATION:1,Station,STATION,A\n" +
+		"STATION:2,Station,STATION,B\n",
+	"trips.txt": "route_id,service_id,trip_id,trip_headsign\n" +
+		"R1,WEEKDAY,T1,To the station\n" +
+		"R2,WEEKDAY,T2,\n" +
+		"R1,SUNDAY,T3,To the station\n" +
+		"R1,WEEKDAY,T4,Far away\n",
+	"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type\n" +
+		"T1,06:01:30,06:02:00,STATION:2,2,,\n" +
+		"T1,06:00:30,06:00:30,LEFT,1,1,1\n" +
+		"T1,06:10:00,06:10:00,FAR,3,,\n" +
+		"T2,23:59:00,24:00:30,STATION:1,1,,\n" +
+		"T2,24:05:00,24:05:00,LEFT,2,,\n" +
+		"T3,07:00:30,07:00:30,LEFT,1,,\n" +
+		"T3,07:01:30,07:02:00,STATION,2,,\n" +
+		"T4,08:00:00,08:00:00,FAR,1,,\n",
+}
+
+func testMapping() *Mapping {
+	return &Mapping{
+		Stops: map[string]StopMapping{
+			"LEFT":    {PlaceCode: "LFT"},
+			"STATION": {PlaceCode: "STN", Tracks: map[string]string{"B": "2"}},
+		},
+		Entries: map[string]Entry{
+			"LFT": {
+				TrainHead:    simulation.Position{TrackItemID: "2", PreviousItemID: "1", PositionOnTI: 3},
+				AppearBefore: 30,
+				InitialSpeed: 5,
+			},
+			"STN": {
+				TrainHead: simulation.Position{TrackItemID: "13", PreviousItemID: "12", PositionOnTI: 10},
+			},
+		},
+		TrainType:       "UT",
+		RouteTrainTypes: map[string]string{"R2": "UT2"},
+		ServiceIDs:      []string{"WEEKDAY"},
+	}
+}
+
+func TestImport(t *testing.T) {
+	Convey("Testing GTFS import", t, func() {
+		Convey("Trips in the simulation should be imported", func() {
+			tt, err := Import(testFeed(testFiles), testMapping())
+			So(err, ShouldBeNil)
+			So(tt.Services, ShouldHaveLength, 2)
+			So(tt.Services, ShouldNotContainKey, "T3")
+			So(tt.Services, ShouldNotContainKey, "T4")
+
+			s1 := tt.Services["T1"]
+			So(s1.Description, ShouldEqual, "To the station")
+			So(s1.PlannedTrainTypeCode, ShouldEqual, "UT")
+			So(s1.Lines, ShouldHaveLength, 2)
+			So(s1.Lines[0].PlaceCode, ShouldEqual, "LFT")
+			So(s1.Lines[0].MustStop, ShouldBeFalse)
+			So(s1.Lines[0].TrackCode, ShouldEqual, "")
+			So(s1.Lines[0].ScheduledArrivalTime.IsZero(), ShouldBeTrue)
+			So(s1.Lines[0].ScheduledDepartureTime.Format("15:04:05"), ShouldEqual, "06:00:30")
+			So(s1.Lines[1].PlaceCode, ShouldEqual, "STN")
+			So(s1.Lines[1].MustStop, ShouldBeTrue)
+			So(s1.Lines[1].TrackCode, ShouldEqual, "2")
+			So(s1.Lines[1].ScheduledArrivalTime.Format("15:04:05"), ShouldEqual, "06:01:30")
+			So(s1.Lines[1].ScheduledDepartureTime.Format("15:04:05"), ShouldEqual, "06:02:00")
+
+			s2 := tt.Services["T2"]
+			So(s2.Description, ShouldEqual, "STN->LFT")
+			So(s2.PlannedTrainTypeCode, ShouldEqual, "UT2")
+			So(s2.Lines[0].TrackCode, ShouldEqual, "A")
+			midnight := simulation.ParseTime("00:00:00").Time
+			So(s2.Lines[0].ScheduledDepartureTime.Time.Sub(midnight), ShouldEqual, 24*time.Hour+30*time.Second)
+			So(s2.Lines[1].ScheduledArrivalTime.Time.Sub(midnight), ShouldEqual, 24*time.Hour+5*time.Minute)
+			So(s2.Lines[1].ScheduledDepartureTime.IsZero(), ShouldBeTrue)
+
+			So(tt.Trains, ShouldHaveLength, 2)
+			So(tt.Trains[0].ServiceCode, ShouldEqual, "T1")
+			So(tt.Trains[0].AppearTime.Format("15:04:05"), ShouldEqual, "06:00:00")
+			So(tt.Trains[0].TrainTypeCode, ShouldEqual, "UT")
+			So(tt.Trains[0].InitialSpeed, ShouldEqual, 5)
+			So(tt.Trains[0].Status, ShouldEqual, simulation.Inactive)
+			So(tt.Trains[0].TrainHead, ShouldResemble, simulation.Position{TrackItemID: "2", PreviousItemID: "1", PositionOnTI: 3})
+			So(tt.Trains[1].ServiceCode, ShouldEqual, "T2")
+			So(tt.Trains[1].AppearTime.Time.Sub(midnight), ShouldEqual, 24*time.Hour+30*time.Second)
+			So(tt.Trains[1].TrainTypeCode, ShouldEqual, "UT2")
+			So(tt.Trains[1].TrainHead.TrackItemID, ShouldEqual, "13")
+		})
+		Convey("All service IDs should be imported if none is given", func() {
+			m := testMapping()
+			m.ServiceIDs = nil
+			tt, err := Import(testFeed(testFiles), m)
+			So(err, ShouldBeNil)
+			So(tt.Services, ShouldContainKey, "T3")
+			So(tt.Services["T3"].Lines[1].TrackCode, ShouldEqual, "")
+		})
+		Convey("Trips entering at places without entry should fail", func() {
+			m := testMapping()
+			delete(m.Entries, "STN")
+			_, err := Import(testFeed(testFiles), m)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "trip T2: no entry for place STN")
+		})
+		Convey("Trips without train type should fail", func() {
+			m := testMapping()
+			m.TrainType = ""
+			_, err := Import(testFeed(testFiles), m)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "trip T1: no train type for route R1")
+		})
+		Convey("Invalid feeds should fail", func() {
+			files := map[string]string{
+				"stops.txt": testFiles["stops.txt"],
+				"trips.txt": testFiles["trips.txt"],
+			}
+			_, err := Import(testFeed(files), testMapping())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "missing file in GTFS feed: stop_times.txt")
+
+			files["stop_times.txt"] = "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+				"T1,06:00:30,06:00,LEFT,1\n" +
+				"T1,06:01:30,06:02:00,STATION,2\n"
+			_, err = Import(testFeed(files), testMapping())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "trip T1: invalid time: 06:00")
+
+			files["stop_times.txt"] = "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+				"T1,06:00:30,06:00:30,LEFT,first\n"
+			_, err = Import(testFeed(files), testMapping())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "stop_times.txt line 2: invalid stop sequence: first")
+		})
+	})
+}
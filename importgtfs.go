@@ -0,0 +1,123 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ts2/ts2-sim-server/gtfs"
+	"github.com/ts2/ts2-sim-server/simulation"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// importGTFSCommand imports the timetable of the GTFS feed given in args.
+//
+// If a simulation file is given, its services and trains are replaced by the
+// imported ones and the whole simulation is written. Otherwise, only the
+// imported services and trains are written.
+func importGTFSCommand(args []string) error {
+	flags := flag.NewFlagSet("import-gtfs", flag.ExitOnError)
+	mappingFile := flags.String("mapping", "", "The JSON file mapping the GTFS stops to the places of the simulation.")
+	simFile := flags.String("sim", "", "The JSON simulation file into which to import the timetable. Its services and trains are replaced.")
+	outputFile := flags.String("output", "", "The filename in which to save the result. If not specified, it is written to stdout.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage of ts2-sim-server import-gtfs:
+  ts2-sim-server import-gtfs [options...] feed
+
+ARGUMENTS:
+  feed
+		The GTFS zip file to import
+
+OPTIONS:
+`)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("please specify a GTFS feed")
+	}
+	if *mappingFile == "" {
+		flags.Usage()
+		return fmt.Errorf("please specify a mapping file")
+	}
+
+	logger = log.New()
+	logger.SetHandler(log.LvlFilterHandler(log.LvlWarn, log.StreamHandler(os.Stderr, log.TerminalFormat())))
+	simulation.InitializeLogger(logger)
+
+	mapping, err := gtfs.LoadMapping(*mappingFile)
+	if err != nil {
+		return err
+	}
+	tt, err := gtfs.ImportFile(flags.Arg(0), mapping)
+	if err != nil {
+		return err
+	}
+
+	var res []byte
+	if *simFile == "" {
+		res, err = json.MarshalIndent(tt, "", "    ")
+	} else {
+		res, err = importTimetable(*simFile, tt)
+	}
+	if err != nil {
+		return err
+	}
+	if *outputFile == "" {
+		fmt.Println(string(res))
+		return nil
+	}
+	if err = ioutil.WriteFile(*outputFile, res, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", *outputFile, err)
+	}
+	return nil
+}
+
+// importTimetable returns the simulation of the given file with its services
+// and trains replaced by those of tt.
+func importTimetable(simFile string, tt *gtfs.Timetable) ([]byte, error) {
+	data, err := ioutil.ReadFile(simFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %s", simFile, err)
+	}
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %s", simFile, err)
+	}
+	if raw["services"], err = json.Marshal(tt.Services); err != nil {
+		return nil, err
+	}
+	if raw["trains"], err = json.Marshal(tt.Trains); err != nil {
+		return nil, err
+	}
+	res, err := json.MarshalIndent(raw, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	var sim simulation.Simulation
+	if err = json.Unmarshal(res, &sim); err != nil {
+		return nil, fmt.Errorf("imported simulation is invalid: %s", err)
+	}
+	return res, nil
+}
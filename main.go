@@ -19,13 +19,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	_ "github.com/ts2/ts2-sim-server/plugins/ars"
 	_ "github.com/ts2/ts2-sim-server/plugins/lines"
 	_ "github.com/ts2/ts2-sim-server/plugins/points"
 	_ "github.com/ts2/ts2-sim-server/plugins/routes"
@@ -36,19 +42,84 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
+// shutdownTimeout is the time given to the server to shutdown gracefully
+const shutdownTimeout = 5 * time.Second
+
 var logger log.Logger
 
+// instanceFlags holds the simulation files of the named instances given with
+// the -instance option, by name.
+type instanceFlags map[string]string
+
+// String returns the instances as a comma separated list of name=file.
+func (i instanceFlags) String() string {
+	var res []string
+	for name, file := range i {
+		res = append(res, fmt.Sprintf("%s=%s", name, file))
+	}
+	return strings.Join(res, ",")
+}
+
+// Set adds the instance given as name=file.
+func (i instanceFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected name=file, got %s", value)
+	}
+	i[parts[0]] = parts[1]
+	return nil
+}
+
 func main() {
+	// Headless run mode
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		if err := runCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// GTFS timetable import
+	if len(os.Args) > 1 && os.Args[1] == "import-gtfs" {
+		if err := importGTFSCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Timetable export
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Command line arguments
 	port := flag.String("port", server.DefaultPort, "The port on which the server will listen")
 	addr := flag.String("addr", server.DefaultAddr, "The address on which the server will listen. Set to 0.0.0.0 to listen on all addresses.")
 	logFile := flag.String("logfile", "", "The filename in which to save the logs. If not specified, the logs are sent to stderr.")
 	logLevel := flag.String("loglevel", "info", "The minimum level of log to be written. Possible values are 'crit', 'error', 'warn', 'info' and 'debug'.")
 	version := flag.Bool("version", false, "Display version and exit.")
+	seed := flag.Int64("seed", 0, "The seed of the random delays of the simulation. If not 0, it overrides the seed option of the simulation file.")
+	resume := flag.String("resume", "", "A snapshot file saved with simulation/save from which to resume a simulation. If specified, the file argument must be omitted.")
+	autosave := flag.String("autosave", "", "A file in which to save the simulation when the server is stopped. It can be resumed with the -resume option.")
+	simDir := flag.String("simdir", "", "A directory of simulation files that supervisors can load while the server is running.")
+	snapshotDir := flag.String("snapshotdir", "", "A directory in which supervisors can save the simulation with simulation/save.")
+	instances := make(instanceFlags)
+	flag.Var(instances, "instance", "A simulation instance to serve besides the main simulation, given as name=file. Its clients connect to /ws/name. Can be repeated.")
+	auth := flag.String("auth", "", "A JSON file with the tokens and roles of the clients. If not specified, the client token of the simulation gives full control.")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage of ts2-sim-server:
   ts2-sim-server [options...] file
+  ts2-sim-server [options...] -resume snapshot
+  ts2-sim-server run [options...] file
+  ts2-sim-server import-gtfs [options...] feed
+  ts2-sim-server export [options...] file
 
 ARGUMENTS:
   file
@@ -66,9 +137,9 @@ OPTIONS:
 		os.Exit(0)
 	}
 
-	// Handle ctrl+c to kill on terminal
+	// Handle ctrl+c to kill on terminal and SIGTERM sent by docker
 	killChan := make(chan os.Signal, 1)
-	signal.Notify(killChan, os.Interrupt)
+	signal.Notify(killChan, os.Interrupt, syscall.SIGTERM)
 
 	// Setup logging system
 	logger = log.New()
@@ -91,13 +162,32 @@ OPTIONS:
 	simulation.InitializeLogger(logger)
 	server.InitializeLogger(logger)
 
+	server.SimulationsDir = *simDir
+	server.SnapshotsDir = *snapshotDir
+	server.Seed = *seed
+
+	// Load the credentials
+	if *auth != "" {
+		if err := server.LoadCredentials(*auth); err != nil {
+			logger.Crit("Unable to load credentials", "file", *auth, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// Load the simulation
-	if len(flag.Args()) == 0 {
+	simFile := flag.Arg(0)
+	switch {
+	case *resume != "" && len(flag.Args()) > 0:
+		fmt.Fprintf(os.Stderr, "Error: Please specify either a simulation file or a snapshot to resume\n\n")
+		flag.Usage()
+		os.Exit(1)
+	case *resume != "":
+		simFile = *resume
+	case len(flag.Args()) == 0:
 		fmt.Fprintf(os.Stderr, "Error: Please specify a simulation file\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
-	simFile := flag.Arg(0)
 	logger.Info("Loading simulation", "file", simFile)
 
 	data, err := ioutil.ReadFile(simFile)
@@ -111,6 +201,14 @@ OPTIONS:
 		logger.Error("Load Error", "file", simFile, "error", err)
 		return
 	}
+	if *resume != "" && !sim.HasSnapshot() {
+		logger.Error("Load Error", "file", simFile, "error", "file is not a simulation snapshot")
+		return
+	}
+
+	if *seed != 0 {
+		sim.Options.Seed = *seed
+	}
 
 	go server.Run(&sim, *addr, *port)
 
@@ -120,10 +218,79 @@ OPTIONS:
 	}
 	logger.Info("Simulation loaded", "sim", sim.Options.Title)
 
+	for name, file := range instances {
+		if err = loadInstance(name, file, *seed); err != nil {
+			logger.Error("Unable to load simulation instance", "instance", name, "file", file, "error", err)
+			return
+		}
+	}
+
 	select {
 	case <-killChan:
-		// TODO gracefully shutdown things maybe
 		logger.Info("Server killed, exiting...")
-		os.Exit(0)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err = server.Shutdown(ctx); err != nil {
+			logger.Error("Unable to shutdown server gracefully", "error", err)
+		}
+		if *autosave != "" {
+			failed := false
+			for name, s := range server.Simulations() {
+				fileName := autosaveFile(*autosave, name)
+				if err = saveSimulation(s, fileName); err != nil {
+					logger.Error("Unable to save simulation", "instance", name, "file", fileName, "error", err)
+					failed = true
+					continue
+				}
+				logger.Info("Simulation saved", "instance", name, "file", fileName)
+			}
+			if failed {
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// loadInstance loads the simulation of the given file and serves it as the
+// simulation instance with the given name.
+func loadInstance(name, fileName string, seed int64) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var sim simulation.Simulation
+	if err = json.Unmarshal(data, &sim); err != nil {
+		return err
+	}
+	if seed != 0 {
+		sim.Options.Seed = seed
+	}
+	if err = server.AddSimulation(name, &sim); err != nil {
+		return err
+	}
+	if err = sim.Initialize(); err != nil {
+		return fmt.Errorf("invalid simulation: %s", err)
+	}
+	logger.Info("Simulation instance loaded", "instance", name, "sim", sim.Options.Title)
+	return nil
+}
+
+// autosaveFile returns the file in which the simulation of the given instance
+// is saved when the server is stopped. The main simulation is saved to
+// fileName, and instances to fileName with -name inserted before its extension.
+func autosaveFile(fileName, instance string) string {
+	if instance == "" {
+		return fileName
+	}
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(fileName, ext), instance, ext)
+}
+
+// saveSimulation writes a snapshot of the given simulation to fileName.
+func saveSimulation(sim *simulation.Simulation, fileName string) error {
+	data, err := sim.Snapshot()
+	if err != nil {
+		return err
 	}
+	return ioutil.WriteFile(fileName, data, 0644)
 }
@@ -0,0 +1,347 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package ars
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// StandardManager is an ARS manager that sets the routes from the next signal
+// of each train towards the next place of its service, on the planned track
+// if possible.
+//
+// Automatic route setting is disabled on all signals by default. Routes
+// activated by humans are never replaced.
+type StandardManager struct {
+	sync.RWMutex
+	enabled map[string]bool
+	// arsRoutes are the IDs of the active routes set by this manager.
+	arsRoutes map[string]bool
+	// manualRoutes are the IDs of the active routes set by humans.
+	manualRoutes map[string]bool
+	// activeRoutes are the IDs of the routes that were active at the last
+	// call of SetRoutes, or nil before the first call.
+	activeRoutes map[string]bool
+	// targets are the routes computed for each train at the last call of
+	// SetRoutes.
+	targets map[*simulation.Train]target
+}
+
+// A target is the route computed for a train, which remains valid as long as
+// the next signal, the service and the next place of the train are the same.
+type target struct {
+	signalID    string
+	serviceCode string
+	placeIndex  int
+	route       *simulation.Route
+}
+
+// Name returns a description of this manager that is used for the UI.
+func (sm *StandardManager) Name() string {
+	return "Standard Manager"
+}
+
+// IsEnabled returns true if routes are set automatically from the given signal.
+func (sm *StandardManager) IsEnabled(si *simulation.SignalItem) bool {
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.enabled[si.ID()]
+}
+
+// SetEnabled enables or disables automatic route setting from the given signal.
+func (sm *StandardManager) SetEnabled(si *simulation.SignalItem, enabled bool) {
+	sm.Lock()
+	defer sm.Unlock()
+	if !enabled {
+		delete(sm.enabled, si.ID())
+		return
+	}
+	sm.enabled[si.ID()] = true
+}
+
+// SetRoutes activates a route from the next signal of each running train
+// towards the next place of its service, if automatic route setting is
+// enabled on this signal.
+//
+// A route already set from this signal is replaced if it is not persistent,
+// has not been set by a human and no train is on it. Routes that cannot be
+// activated, for instance because a routes manager vetoed it, will be tried
+// again at the next call.
+func (sm *StandardManager) SetRoutes(sim *simulation.Simulation) {
+	sm.updateManualRoutes(sim)
+	// The targets of the last call are never modified once stored, so that
+	// they can be read without holding the lock.
+	sm.RLock()
+	previous := sm.targets
+	sm.RUnlock()
+	targets := make(map[*simulation.Train]target)
+	defer func() {
+		sm.Lock()
+		defer sm.Unlock()
+		sm.targets = targets
+	}()
+	for _, t := range sim.Trains {
+		if !t.IsActive() || t.Service() == nil || t.NextPlaceIndex == simulation.NoMorePlace {
+			continue
+		}
+		nsp := t.NextSignalPosition()
+		if nsp.IsNull() {
+			continue
+		}
+		si := nsp.TrackItem().(*simulation.SignalItem)
+		if !sm.IsEnabled(si) {
+			continue
+		}
+		tgt, ok := previous[t]
+		if !ok || tgt.signalID != si.ID() || tgt.serviceCode != t.ServiceCode || tgt.placeIndex != t.NextPlaceIndex {
+			tgt = target{
+				signalID:    si.ID(),
+				serviceCode: t.ServiceCode,
+				placeIndex:  t.NextPlaceIndex,
+				route:       routeFor(sim, si, t.Service().Lines[t.NextPlaceIndex]),
+			}
+		}
+		targets[t] = tgt
+		r := tgt.route
+		active := activeRouteFrom(sim, si)
+		if r == nil || active != nil && (active.Equals(r) || active.State() == simulation.Persistent || sm.isManual(active) || routeOccupied(active)) {
+			continue
+		}
+		if active != nil {
+			if err := active.Deactivate(); err != nil {
+				simulation.Logger.Debug("ARS unable to unset route", "route", active.ID(), "train", t.ServiceCode, "error", err)
+				continue
+			}
+		}
+		if err := r.Activate(false); err != nil {
+			simulation.Logger.Debug("ARS unable to set route", "route", r.ID(), "train", t.ServiceCode, "error", err)
+			continue
+		}
+		sm.Lock()
+		sm.arsRoutes[r.ID()] = true
+		sm.Unlock()
+	}
+}
+
+// updateManualRoutes records the routes that have been activated since the
+// last call of SetRoutes by someone else than this manager. At the first
+// call, the routes that are active because of their initial state are not
+// considered as set by humans.
+func (sm *StandardManager) updateManualRoutes(sim *simulation.Simulation) {
+	sm.Lock()
+	defer sm.Unlock()
+	first := sm.activeRoutes == nil
+	active := make(map[string]bool)
+	for id, r := range sim.Routes {
+		if !r.IsActive() {
+			delete(sm.arsRoutes, id)
+			delete(sm.manualRoutes, id)
+			continue
+		}
+		active[id] = true
+		if sm.activeRoutes[id] || sm.arsRoutes[id] {
+			continue
+		}
+		if first && (r.InitialState == simulation.Activated || r.InitialState == simulation.Persistent) {
+			continue
+		}
+		sm.manualRoutes[id] = true
+	}
+	sm.activeRoutes = active
+}
+
+// isManual returns true if the given route has been set by a human.
+func (sm *StandardManager) isManual(r *simulation.Route) bool {
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.manualRoutes[r.ID()]
+}
+
+// savedState is the state of a StandardManager in the snapshots of the simulation.
+type savedState struct {
+	ARSRoutes    []string `json:"arsRoutes"`
+	ManualRoutes []string `json:"manualRoutes"`
+}
+
+// SaveState returns the routes set by this manager and by humans in the
+// given simulation.
+func (sm *StandardManager) SaveState(*simulation.Simulation) (json.RawMessage, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+	st := savedState{
+		ARSRoutes:    sortedIDs(sm.arsRoutes),
+		ManualRoutes: sortedIDs(sm.manualRoutes),
+	}
+	return json.Marshal(st)
+}
+
+// RestoreState restores the routes set by this manager and by humans in the
+// given simulation.
+func (sm *StandardManager) RestoreState(sim *simulation.Simulation, data json.RawMessage) error {
+	var st savedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	sm.arsRoutes = make(map[string]bool)
+	sm.manualRoutes = make(map[string]bool)
+	sm.activeRoutes = make(map[string]bool)
+	sm.targets = make(map[*simulation.Train]target)
+	if err := restoreIDs(sim, st.ARSRoutes, sm.arsRoutes); err != nil {
+		return err
+	}
+	if err := restoreIDs(sim, st.ManualRoutes, sm.manualRoutes); err != nil {
+		return err
+	}
+	for id, r := range sim.Routes {
+		if r.IsActive() {
+			sm.activeRoutes[id] = true
+		}
+	}
+	return nil
+}
+
+var _ simulation.StatefulManager = new(StandardManager)
+
+var _ simulation.ARSManager = new(StandardManager)
+
+// activeRouteFrom returns the active route starting at the given signal, if any.
+func activeRouteFrom(sim *simulation.Simulation, si *simulation.SignalItem) *simulation.Route {
+	for _, r := range sim.RoutesFrom(si) {
+		if r.IsActive() {
+			return r
+		}
+	}
+	return nil
+}
+
+// routeFor returns the first route to set from the given signal to reach the
+// place of the given service line, on its planned track if possible.
+func routeFor(sim *simulation.Simulation, si *simulation.SignalItem, line *simulation.ServiceLine) *simulation.Route {
+	r := routeTowards(sim, si, line.PlaceCode, line.TrackCode)
+	if r == nil {
+		// Planned track cannot be reached, try any track of the place
+		r = routeTowards(sim, si, line.PlaceCode, "")
+	}
+	return r
+}
+
+// routeTowards returns the first route of the shortest chain of routes from the
+// given signal to the given place, or nil if the place cannot be reached.
+//
+// If trackCode is not empty, the chain must reach the place on this track.
+func routeTowards(sim *simulation.Simulation, si *simulation.SignalItem, placeCode, trackCode string) *simulation.Route {
+	type step struct {
+		signal *simulation.SignalItem
+		first  *simulation.Route
+	}
+	visited := map[string]bool{si.ID(): true}
+	queue := []step{{signal: si}}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, r := range sim.RoutesFrom(cur.signal) {
+			first := cur.first
+			if first == nil {
+				first = r
+			}
+			if routeReaches(r, placeCode, trackCode) {
+				return first
+			}
+			if !visited[r.EndSignalId] {
+				visited[r.EndSignalId] = true
+				queue = append(queue, step{signal: r.EndSignal(), first: first})
+			}
+		}
+	}
+	return nil
+}
+
+// routeOccupied returns true if a train is present on the given route.
+func routeOccupied(r *simulation.Route) bool {
+	for _, pos := range r.Positions {
+		if pos.TrackItem().TrainPresent() {
+			return true
+		}
+	}
+	return false
+}
+
+// routeReaches returns true if the given route goes through the given place,
+// on the given track if trackCode is not empty.
+func routeReaches(r *simulation.Route, placeCode, trackCode string) bool {
+	for _, pos := range r.Positions {
+		ti := pos.TrackItem()
+		if ti.Place() == nil || ti.Place().PlaceCode != placeCode {
+			continue
+		}
+		if trackCode == "" || ti.TrackCode() == trackCode {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedIDs returns the IDs of the given set, sorted.
+func sortedIDs(set map[string]bool) []string {
+	res := make([]string, 0, len(set))
+	for id := range set {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// restoreIDs adds the given route IDs to the given set.
+func restoreIDs(sim *simulation.Simulation, ids []string, set map[string]bool) error {
+	for _, id := range ids {
+		if _, ok := sim.Routes[id]; !ok {
+			return fmt.Errorf("unknown route: %s", id)
+		}
+		set[id] = true
+	}
+	return nil
+}
+
+// NewInstance returns a new StandardManager, so that each simulation has its own state.
+func (sm *StandardManager) NewInstance() interface{} {
+	return newStandardManager()
+}
+
+var _ simulation.ManagerFactory = new(StandardManager)
+
+// newStandardManager returns a pointer to a new StandardManager.
+func newStandardManager() *StandardManager {
+	return &StandardManager{
+		enabled:      make(map[string]bool),
+		arsRoutes:    make(map[string]bool),
+		manualRoutes: make(map[string]bool),
+		targets:      make(map[*simulation.Train]target),
+	}
+}
+
+func init() {
+	simulation.RegisterARSManager(newStandardManager())
+}
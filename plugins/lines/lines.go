@@ -18,23 +18,187 @@
 
 package lines
 
-import "github.com/ts2/ts2-sim-server/simulation"
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
-// StandardManager is a lines manager that never fails.
-type StandardManager struct{}
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// StandardManager is a lines manager that simulates track circuit failures
+// according to the options of the simulation.
+//
+// Each track circuit fails randomly at an average of trackCircuitFailureRate
+// times per hour, and is repaired after trackCircuitRepairTime. Failures set
+// manually are only repaired manually.
+//
+// With the default options, track circuits never fail by themselves.
+type StandardManager struct {
+	sync.RWMutex
+	circuits map[*simulation.LineItem]*circuitState
+}
+
+// circuitState is the state of the track circuit of a line item.
+//
+// A random failure is in progress if repairedAt is not zero.
+type circuitState struct {
+	failsAt    time.Time
+	repairedAt time.Time
+	manual     bool
+}
 
 // IsFailed returns whether the track circuit of the given line item is failed or not
-func (sm StandardManager) IsFailed(p *simulation.LineItem) bool {
-	return false
+func (sm *StandardManager) IsFailed(li *simulation.LineItem) bool {
+	sm.RLock()
+	defer sm.RUnlock()
+	cs, ok := sm.circuits[li]
+	if !ok {
+		return false
+	}
+	return cs.manual || !cs.repairedAt.IsZero()
+}
+
+// Update makes the track circuit of the given line item fail or be repaired
+// according to the simulation time.
+func (sm *StandardManager) Update(li *simulation.LineItem) {
+	sm.Lock()
+	defer sm.Unlock()
+	cs := sm.circuit(li)
+	sim := li.Simulation()
+	now := sim.Options.CurrentTime.Time
+	if cs.failsAt.IsZero() {
+		cs.failsAt = nextFailure(sim, now)
+		return
+	}
+	if now.Before(cs.failsAt) {
+		return
+	}
+	if cs.repairedAt.IsZero() {
+		cs.repairedAt = cs.failsAt.Add(sim.Options.TrackCircuitRepairTime.YieldFrom(sim.Rand()))
+	}
+	if !now.Before(cs.repairedAt) {
+		cs.failsAt = nextFailure(sim, cs.repairedAt)
+		cs.repairedAt = time.Time{}
+	}
+}
+
+// SetFailed sets or repairs a track circuit failure on the given line item
+func (sm *StandardManager) SetFailed(li *simulation.LineItem, failed bool) {
+	sm.Lock()
+	defer sm.Unlock()
+	cs := sm.circuit(li)
+	cs.manual = failed
+	if !failed && !cs.repairedAt.IsZero() {
+		// Repair the current random failure now
+		cs.failsAt = time.Time{}
+		cs.repairedAt = time.Time{}
+	}
 }
 
 // Name returns a description of this manager that is used for the UI.
-func (sm StandardManager) Name() string {
+func (sm *StandardManager) Name() string {
 	return "Standard Manager"
 }
 
-var _ simulation.LineItemManager = StandardManager{}
+// circuit returns the circuitState of the given line item, creating it if needed.
+func (sm *StandardManager) circuit(li *simulation.LineItem) *circuitState {
+	cs, ok := sm.circuits[li]
+	if !ok {
+		cs = new(circuitState)
+		sm.circuits[li] = cs
+	}
+	return cs
+}
+
+// savedCircuitState is a circuitState as saved in simulation snapshots.
+type savedCircuitState struct {
+	FailsAt    time.Time `json:"failsAt"`
+	RepairedAt time.Time `json:"repairedAt"`
+	Manual     bool      `json:"manual"`
+}
+
+// SaveState returns the state of the track circuits of the given simulation.
+func (sm *StandardManager) SaveState(*simulation.Simulation) (json.RawMessage, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+	states := make(map[string]savedCircuitState)
+	for item, st := range sm.circuits {
+		states[item.ID()] = savedCircuitState{
+			FailsAt:    st.failsAt,
+			RepairedAt: st.repairedAt,
+			Manual:     st.manual,
+		}
+	}
+	return json.Marshal(states)
+}
+
+// RestoreState restores the state of the track circuits of the given simulation.
+func (sm *StandardManager) RestoreState(sim *simulation.Simulation, data json.RawMessage) error {
+	var states map[string]savedCircuitState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	sm.circuits = make(map[*simulation.LineItem]*circuitState)
+	for id, st := range states {
+		item, ok := lineItem(sim.TrackItems[id])
+		if !ok {
+			return fmt.Errorf("unknown line item: %s", id)
+		}
+		sm.circuits[item] = &circuitState{
+			failsAt:    st.FailsAt,
+			repairedAt: st.RepairedAt,
+			manual:     st.Manual,
+		}
+	}
+	return nil
+}
+
+// lineItem returns the LineItem of the given track item, which is the item
+// itself or the LineItem embedded in platforms and invisible links.
+func lineItem(ti simulation.TrackItem) (*simulation.LineItem, bool) {
+	switch item := ti.(type) {
+	case *simulation.LineItem:
+		return item, true
+	case *simulation.PlatformItem:
+		return &item.LineItem, true
+	case *simulation.InvisibleLinkItem:
+		return &item.LineItem, true
+	}
+	return nil, false
+}
+
+// nextFailure returns the time of the next random failure of a track circuit
+// after the given time, or a zero time if track circuits do not fail.
+func nextFailure(sim *simulation.Simulation, after time.Time) time.Time {
+	rate := sim.Options.TrackCircuitFailureRate
+	if rate <= 0 {
+		return time.Time{}
+	}
+	return after.Add(time.Duration(sim.Rand().ExpFloat64() / rate * float64(time.Hour)))
+}
+
+var _ simulation.LineItemManager = new(StandardManager)
+var _ simulation.LineItemFailureManager = new(StandardManager)
+var _ simulation.StatefulManager = new(StandardManager)
+
+// NewInstance returns a new StandardManager, so that each simulation has its own state.
+func (sm *StandardManager) NewInstance() interface{} {
+	return newStandardManager()
+}
+
+var _ simulation.ManagerFactory = new(StandardManager)
+
+// newStandardManager returns a pointer to a new StandardManager.
+func newStandardManager() *StandardManager {
+	return &StandardManager{
+		circuits: make(map[*simulation.LineItem]*circuitState),
+	}
+}
 
 func init() {
-	simulation.RegisterLineItemManager(StandardManager{})
+	simulation.RegisterLineItemManager(newStandardManager())
 }
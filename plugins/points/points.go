@@ -19,8 +19,12 @@
 package points
 
 import (
-	"github.com/ts2/ts2-sim-server/simulation"
+	"encoding/json"
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
 )
 
 // StandardManager is a points manager that performs points change
@@ -60,6 +64,13 @@ func (sm *StandardManager) Name() string {
 
 var _ simulation.PointsItemManager = new(StandardManager)
 
+// NewInstance returns a new StandardManager, so that each simulation has its own state.
+func (sm *StandardManager) NewInstance() interface{} {
+	return newStandardManager()
+}
+
+var _ simulation.ManagerFactory = new(StandardManager)
+
 // newStandardManager returns a pointer to a new StandardManager.
 func newStandardManager() *StandardManager {
 	return &StandardManager{
@@ -67,6 +78,180 @@ func newStandardManager() *StandardManager {
 	}
 }
 
+// PhysicalManager is a points manager that simulates the movement of points
+// and their failures according to the options of the simulation.
+//
+// Points take pointsTransitTime to move, during which they report
+// DirectionUnknown. Each movement fails with a probability of
+// pointsFailureChance, in which case the points report DirectionFailed
+// until they are repaired after pointsRepairTime. Repaired points then take
+// pointsTransitTime again to complete their movement.
+//
+// With the default options, that is without transit time nor failures, the
+// points are handled by a StandardManager.
+type PhysicalManager struct {
+	sync.RWMutex
+	standard *StandardManager
+	points   map[*simulation.PointsItem]*pointsState
+}
+
+// pointsState is the physical state of a points item.
+//
+// Paired points share the same pointsState.
+type pointsState struct {
+	direction  simulation.PointDirection
+	failedAt   time.Time
+	repairedAt time.Time
+	movedAt    time.Time
+}
+
+// directionAt returns the direction reported by the points at the given time.
+//
+// Zero times are not compared since simulation times are in year 0.
+func (ps *pointsState) directionAt(t time.Time) simulation.PointDirection {
+	switch {
+	case !ps.failedAt.IsZero() && !t.Before(ps.failedAt) && t.Before(ps.repairedAt):
+		return simulation.DirectionFailed
+	case !ps.movedAt.IsZero() && t.Before(ps.movedAt):
+		return simulation.DirectionUnknown
+	}
+	return ps.direction
+}
+
+// Direction returns the direction of the points
+func (pm *PhysicalManager) Direction(p *simulation.PointsItem) simulation.PointDirection {
+	pm.RLock()
+	ps, ok := pm.points[p]
+	pm.RUnlock()
+	if !ok {
+		return pm.standard.Direction(p)
+	}
+	return ps.directionAt(p.Simulation().Options.CurrentTime.Time)
+}
+
+// SetDirection tries to set the given PointsItem to the given direction
+//
+// You should not assume that the direction has been set, since this can be
+// delayed or failed. Call Direction to check.
+func (pm *PhysicalManager) SetDirection(p *simulation.PointsItem, dir simulation.PointDirection) {
+	if dir == simulation.DirectionCurrent {
+		return
+	}
+	sim := p.Simulation()
+	pm.Lock()
+	defer pm.Unlock()
+	ps, ok := pm.points[p]
+	if !ok {
+		if sim.Options.PointsTransitTime.IsNull() && sim.Options.PointsFailureChance <= 0 {
+			pm.standard.SetDirection(p, dir)
+			return
+		}
+		ps = &pointsState{direction: pm.standard.Direction(p)}
+		pm.points[p] = ps
+		if p.PairedItem() != nil {
+			pm.points[p.PairedItem()] = ps
+		}
+	}
+	if ps.direction == dir {
+		return
+	}
+	ps.direction = dir
+	pm.standard.SetDirection(p, dir)
+	now := sim.Options.CurrentTime.Time
+	if ps.directionAt(now) == simulation.DirectionFailed {
+		// Failed points will move when repaired
+		return
+	}
+	ps.movedAt = now.Add(sim.Options.PointsTransitTime.YieldFrom(sim.Rand()))
+	ps.failedAt = time.Time{}
+	ps.repairedAt = time.Time{}
+	if sim.Options.PointsFailureChance > 0 && sim.Rand().Float64() < sim.Options.PointsFailureChance {
+		ps.failedAt = ps.movedAt
+		ps.repairedAt = ps.failedAt.Add(sim.Options.PointsRepairTime.YieldFrom(sim.Rand()))
+		ps.movedAt = ps.repairedAt.Add(sim.Options.PointsTransitTime.YieldFrom(sim.Rand()))
+	}
+}
+
+// savedPointsState is a pointsState as saved in simulation snapshots.
+type savedPointsState struct {
+	Direction  simulation.PointDirection `json:"direction"`
+	FailedAt   time.Time                 `json:"failedAt"`
+	RepairedAt time.Time                 `json:"repairedAt"`
+	MovedAt    time.Time                 `json:"movedAt"`
+}
+
+// SaveState returns the state of the points of the given simulation.
+func (pm *PhysicalManager) SaveState(*simulation.Simulation) (json.RawMessage, error) {
+	pm.RLock()
+	defer pm.RUnlock()
+	states := make(map[string]savedPointsState)
+	for p, ps := range pm.points {
+		states[p.ID()] = savedPointsState{
+			Direction:  ps.direction,
+			FailedAt:   ps.failedAt,
+			RepairedAt: ps.repairedAt,
+			MovedAt:    ps.movedAt,
+		}
+	}
+	return json.Marshal(states)
+}
+
+// RestoreState restores the state of the points of the given simulation.
+func (pm *PhysicalManager) RestoreState(sim *simulation.Simulation, data json.RawMessage) error {
+	var states map[string]savedPointsState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	pm.Lock()
+	defer pm.Unlock()
+	pm.points = make(map[*simulation.PointsItem]*pointsState)
+	for id, st := range states {
+		p, ok := sim.TrackItems[id].(*simulation.PointsItem)
+		if !ok {
+			return fmt.Errorf("unknown points item: %s", id)
+		}
+		if _, ok := pm.points[p]; ok {
+			// Already restored with its paired item
+			continue
+		}
+		ps := &pointsState{
+			direction:  st.Direction,
+			failedAt:   st.FailedAt,
+			repairedAt: st.RepairedAt,
+			movedAt:    st.MovedAt,
+		}
+		pm.points[p] = ps
+		if p.PairedItem() != nil {
+			pm.points[p.PairedItem()] = ps
+		}
+		pm.standard.SetDirection(p, st.Direction)
+	}
+	return nil
+}
+
+// Name returns a description of this manager that is used for the UI.
+func (pm *PhysicalManager) Name() string {
+	return "Physical Manager"
+}
+
+var _ simulation.PointsItemManager = new(PhysicalManager)
+var _ simulation.StatefulManager = new(PhysicalManager)
+
+// NewInstance returns a new PhysicalManager, so that each simulation has its own state.
+func (pm *PhysicalManager) NewInstance() interface{} {
+	return newPhysicalManager()
+}
+
+var _ simulation.ManagerFactory = new(PhysicalManager)
+
+// newPhysicalManager returns a pointer to a new PhysicalManager.
+func newPhysicalManager() *PhysicalManager {
+	return &PhysicalManager{
+		standard: newStandardManager(),
+		points:   make(map[*simulation.PointsItem]*pointsState),
+	}
+}
+
 func init() {
-	simulation.RegisterPointsItemManager(newStandardManager())
+	simulation.RegisterPointsItemManager(newPhysicalManager())
 }
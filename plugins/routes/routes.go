@@ -30,8 +30,9 @@ import (
 type StandardManager struct{}
 
 // CanActivate returns an error if the given route cannot be activated.
-// In this implementation, it checks route conflicts and returns
-// false if a conflict is found.
+// In this implementation, it checks route conflicts, including with the
+// locked overlaps of other routes and with the overlap of the given route,
+// and returns false if a conflict is found.
 func (sm StandardManager) CanActivate(r *simulation.Route) error {
 	var flag *simulation.Route
 	for _, pos := range r.Positions {
@@ -42,6 +43,16 @@ func (sm StandardManager) CanActivate(r *simulation.Route) error {
 			// Our trackItem has a conflicting item with an active route
 			return fmt.Errorf("conflicting route %s is active", pos.TrackItem().ConflictItem().ActiveRoute().ID())
 		}
+		if ci := pos.TrackItem().ConflictItem(); ci != nil && ci.OverlapRoute() != nil {
+			// Our trackItem has a conflicting item in a locked overlap
+			return fmt.Errorf("overlap of route %s is locked", ci.OverlapRoute().ID())
+		}
+		for _, ol := range pos.TrackItem().OverlapRoutes() {
+			if !continuesOverlap(r, ol, pos) {
+				// Our trackItem is in the locked overlap of another route
+				return fmt.Errorf("overlap of route %s is locked", ol.ID())
+			}
+		}
 		if pos.TrackItem().ActiveRoute() == nil {
 			if flag != nil {
 				// We had a route with same direction but does not end with the same signal
@@ -68,12 +79,79 @@ func (sm StandardManager) CanActivate(r *simulation.Route) error {
 		// signal when it is cleared by a train still on the route
 		flag = pos.TrackItem().ActiveRoute()
 	}
+	return checkOverlap(r)
+}
+
+// continuesOverlap returns true if route r may be set on pos while the
+// overlap of route ol is locked on it, that is if r starts at the end signal
+// of ol and sets the points of the overlap in the same direction.
+func continuesOverlap(r, ol *simulation.Route, pos simulation.Position) bool {
+	if r.BeginSignalId != ol.EndSignalId {
+		return false
+	}
+	if pos.TrackItem().Type() != simulation.TypePoints {
+		return true
+	}
+	return r.Directions[pos.TrackItemID] == ol.Overlap.Directions[pos.TrackItemID]
+}
+
+// checkOverlap returns an error if the overlap of the given route, if any,
+// conflicts with active routes or with the locked overlaps of other routes.
+//
+// The overlap may share items with active routes and other overlaps in the
+// same direction, as long as points are set the same way.
+func checkOverlap(r *simulation.Route) error {
+	if r.Overlap == nil {
+		return nil
+	}
+	for _, pos := range r.Overlap.Positions {
+		ti := pos.TrackItem()
+		isPoints := ti.Type() == simulation.TypePoints
+		if ci := ti.ConflictItem(); ci != nil {
+			if ci.ActiveRoute() != nil {
+				return fmt.Errorf("conflicting route %s is active", ci.ActiveRoute().ID())
+			}
+			for _, ol := range ci.OverlapRoutes() {
+				if ol.ID() != r.ID() {
+					return fmt.Errorf("overlap of route %s is locked", ol.ID())
+				}
+			}
+		}
+		if ar := ti.ActiveRoute(); ar != nil && ar.ID() != r.ID() {
+			if pos.PreviousItemID != ti.ActiveRoutePreviousItem().ID() {
+				return fmt.Errorf("conflicting route %s is active", ar.ID())
+			}
+			if isPoints && ar.Directions[ti.ID()] != r.Overlap.Directions[ti.ID()] {
+				return fmt.Errorf("conflicting route %s is active", ar.ID())
+			}
+		}
+		for _, ol := range ti.OverlapRoutes() {
+			if ol.ID() == r.ID() {
+				continue
+			}
+			for _, olPos := range ol.Overlap.Positions {
+				if olPos.TrackItemID != ti.ID() {
+					continue
+				}
+				if olPos.PreviousItemID != pos.PreviousItemID {
+					return fmt.Errorf("overlap of route %s is locked", ol.ID())
+				}
+			}
+			if isPoints && ol.Overlap.Directions[ti.ID()] != r.Overlap.Directions[ti.ID()] {
+				return fmt.Errorf("overlap of route %s is locked", ol.ID())
+			}
+		}
+	}
 	return nil
 }
 
 // CanDeactivate returns an error if the given route cannot be deactivated.
-// In this implementation, it always returns true.
+// In this implementation, it returns an error only if the route is already
+// being cancelled in front of an approaching train.
 func (sm StandardManager) CanDeactivate(r *simulation.Route) error {
+	if r.State() == simulation.Cancelling {
+		return fmt.Errorf("route %s is already being cancelled", r.ID())
+	}
 	return nil
 }
 
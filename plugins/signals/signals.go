@@ -18,24 +18,193 @@
 
 package signals
 
-import "github.com/ts2/ts2-sim-server/simulation"
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
-// StandardManager is a lines manager that never fails.
-type StandardManager struct{}
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// StandardManager is a signals manager that simulates lamp failures
+// according to the options of the simulation.
+//
+// The lamps of each signal fail randomly at an average of signalFailureRate
+// times per hour, and are repaired after signalRepairTime. Failures set
+// manually are only repaired manually.
+//
+// A failed signal shows the dark aspect of the signal library if it is
+// defined, or the most restrictive aspect of its type otherwise.
+//
+// With the default options, signals never fail by themselves.
+type StandardManager struct {
+	sync.RWMutex
+	lamps map[*simulation.SignalItem]*lampState
+}
+
+// lampState is the state of the lamps of a signal.
+//
+// A random failure is in progress if repairedAt is not zero.
+type lampState struct {
+	failsAt    time.Time
+	repairedAt time.Time
+	manual     bool
+}
+
+// failed returns true if the lamps are failed.
+func (ls *lampState) failed() bool {
+	return ls.manual || !ls.repairedAt.IsZero()
+}
 
 // Name returns a description of this signalItemManager that is used for the UI.
-func (sm StandardManager) Name() string {
+func (sm *StandardManager) Name() string {
 	return "Standard Manager"
 }
 
 // GetAspect returns the aspect of the given signal that should be active
-func (sm StandardManager) GetAspect(signal *simulation.SignalItem) *simulation.SignalAspect {
-	// Don't do anything particular in this implementation (no failures)
+func (sm *StandardManager) GetAspect(signal *simulation.SignalItem) *simulation.SignalAspect {
+	sm.RLock()
+	ls, ok := sm.lamps[signal]
+	failed := ok && ls.failed()
+	sm.RUnlock()
+	if failed {
+		return signal.FailedAspect()
+	}
 	return signal.SignalType().GetAspect(signal)
 }
 
-var _ simulation.SignalItemManager = StandardManager{}
+// IsFailed returns true if the lamps of the given signal have failed
+func (sm *StandardManager) IsFailed(signal *simulation.SignalItem) bool {
+	sm.RLock()
+	defer sm.RUnlock()
+	ls, ok := sm.lamps[signal]
+	if !ok {
+		return false
+	}
+	return ls.failed()
+}
+
+// Update makes the lamps of the given signal fail or be repaired according
+// to the simulation time.
+func (sm *StandardManager) Update(signal *simulation.SignalItem) {
+	sm.Lock()
+	defer sm.Unlock()
+	ls := sm.lamp(signal)
+	sim := signal.Simulation()
+	now := sim.Options.CurrentTime.Time
+	if ls.failsAt.IsZero() {
+		ls.failsAt = nextFailure(sim, now)
+		return
+	}
+	if now.Before(ls.failsAt) {
+		return
+	}
+	if ls.repairedAt.IsZero() {
+		ls.repairedAt = ls.failsAt.Add(sim.Options.SignalRepairTime.YieldFrom(sim.Rand()))
+	}
+	if !now.Before(ls.repairedAt) {
+		ls.failsAt = nextFailure(sim, ls.repairedAt)
+		ls.repairedAt = time.Time{}
+	}
+}
+
+// SetFailed sets or repairs a lamp failure on the given signal
+func (sm *StandardManager) SetFailed(signal *simulation.SignalItem, failed bool) {
+	sm.Lock()
+	defer sm.Unlock()
+	ls := sm.lamp(signal)
+	ls.manual = failed
+	if !failed && !ls.repairedAt.IsZero() {
+		// Repair the current random failure now
+		ls.failsAt = time.Time{}
+		ls.repairedAt = time.Time{}
+	}
+}
+
+// lamp returns the lampState of the given signal, creating it if needed.
+func (sm *StandardManager) lamp(signal *simulation.SignalItem) *lampState {
+	ls, ok := sm.lamps[signal]
+	if !ok {
+		ls = new(lampState)
+		sm.lamps[signal] = ls
+	}
+	return ls
+}
+
+// savedLampState is a lampState as saved in simulation snapshots.
+type savedLampState struct {
+	FailsAt    time.Time `json:"failsAt"`
+	RepairedAt time.Time `json:"repairedAt"`
+	Manual     bool      `json:"manual"`
+}
+
+// SaveState returns the state of the signal lamps of the given simulation.
+func (sm *StandardManager) SaveState(*simulation.Simulation) (json.RawMessage, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+	states := make(map[string]savedLampState)
+	for item, st := range sm.lamps {
+		states[item.ID()] = savedLampState{
+			FailsAt:    st.failsAt,
+			RepairedAt: st.repairedAt,
+			Manual:     st.manual,
+		}
+	}
+	return json.Marshal(states)
+}
+
+// RestoreState restores the state of the signal lamps of the given simulation.
+func (sm *StandardManager) RestoreState(sim *simulation.Simulation, data json.RawMessage) error {
+	var states map[string]savedLampState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	sm.lamps = make(map[*simulation.SignalItem]*lampState)
+	for id, st := range states {
+		item, ok := sim.TrackItems[id].(*simulation.SignalItem)
+		if !ok {
+			return fmt.Errorf("unknown signal: %s", id)
+		}
+		sm.lamps[item] = &lampState{
+			failsAt:    st.FailsAt,
+			repairedAt: st.RepairedAt,
+			manual:     st.Manual,
+		}
+	}
+	return nil
+}
+
+// nextFailure returns the time of the next random failure of signal lamps
+// after the given time, or a zero time if signals do not fail.
+func nextFailure(sim *simulation.Simulation, after time.Time) time.Time {
+	rate := sim.Options.SignalFailureRate
+	if rate <= 0 {
+		return time.Time{}
+	}
+	return after.Add(time.Duration(sim.Rand().ExpFloat64() / rate * float64(time.Hour)))
+}
+
+var _ simulation.SignalItemManager = new(StandardManager)
+var _ simulation.SignalItemFailureManager = new(StandardManager)
+var _ simulation.StatefulManager = new(StandardManager)
+
+// NewInstance returns a new StandardManager, so that each simulation has its own state.
+func (sm *StandardManager) NewInstance() interface{} {
+	return newStandardManager()
+}
+
+var _ simulation.ManagerFactory = new(StandardManager)
+
+// newStandardManager returns a pointer to a new StandardManager.
+func newStandardManager() *StandardManager {
+	return &StandardManager{
+		lamps: make(map[*simulation.SignalItem]*lampState),
+	}
+}
 
 func init() {
-	simulation.RegisterSignalItemManager(StandardManager{})
+	simulation.RegisterSignalItemManager(newStandardManager())
 }
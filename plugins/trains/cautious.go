@@ -0,0 +1,56 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package trains
+
+import (
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+	cautiousBrakingFactor float64 = 0.6
+	cautiousAccelFactor   float64 = 0.5
+)
+
+// The CautiousManager implements a driver behaviour where trains brake early,
+// as if the braking capacity of the train was only 60% of its standard braking,
+// and accelerate at half of their standard acceleration.
+//
+// It is typically used for freight trains or for inexperienced crews.
+type CautiousManager struct{}
+
+// Speed computes and returns the speed of the given train after timeElapsed
+func (m CautiousManager) Speed(t *simulation.Train, timeElapsed time.Duration) float64 {
+	return driverSpeed(t, timeElapsed,
+		t.TrainType().StdBraking*cautiousBrakingFactor,
+		t.TrainType().StdAccel*cautiousAccelFactor)
+}
+
+// Name of this manager, for use in UI messages
+func (m CautiousManager) Name() string {
+	return "Cautious Driver"
+}
+
+// Code of this manager, with which trains are set to use it
+func (m CautiousManager) Code() string {
+	return "cautious"
+}
+
+var _ simulation.TrainsManager = CautiousManager{}
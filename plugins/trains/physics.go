@@ -0,0 +1,100 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package trains
+
+import (
+	"math"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+const (
+	gravity float64 = 9.81
+	// adhesionFactor is the part of the weight of a train that can be used as
+	// tractive effort when its type does not define a maximum tractive effort.
+	adhesionFactor float64 = 0.25
+)
+
+// The PhysicsManager implements a driver behaviour where trains accelerate
+// as fast as their dynamics allow, that is according to their mass, their
+// tractive effort curve, their running resistance and the line gradient.
+// Trains brake at the latest to keep in speed limits, as with the
+// StandardManager.
+//
+// Train types which do not define a mass and a power are driven by the
+// StandardManager.
+type PhysicsManager struct{}
+
+// Speed computes and returns the speed of the given train after timeElapsed
+func (m PhysicsManager) Speed(t *simulation.Train, timeElapsed time.Duration) float64 {
+	tt := t.TrainType()
+	if tt.Mass <= 0 || tt.Power <= 0 {
+		// Not enough data to compute the train dynamics
+		return StandardManager{}.Speed(t, timeElapsed)
+	}
+	return driverSpeed(t, timeElapsed, tt.StdBraking, physicsAcceleration(t))
+}
+
+// Name of this manager, for use in UI messages
+func (m PhysicsManager) Name() string {
+	return "Physics Driver"
+}
+
+// Code of this manager, with which trains are set to use it
+func (m PhysicsManager) Code() string {
+	return "physics"
+}
+
+// physicsAcceleration returns the acceleration in m/s² of the given train at
+// its current speed when the driver applies full power.
+//
+// It is negative if the train cannot keep its speed, e.g. on a steep gradient.
+func physicsAcceleration(t *simulation.Train) float64 {
+	tt := t.TrainType()
+	// All forces are computed in newtons
+	mass := tt.Mass * 1000
+	effort := tt.MaxTractiveEffort * 1000
+	if effort <= 0 {
+		effort = adhesionFactor * mass * gravity
+	}
+	if t.Speed > 0 {
+		effort = math.Min(effort, tt.Power*1000/t.Speed)
+	}
+	resistance := (tt.DavisA + tt.DavisB*t.Speed + tt.DavisC*math.Pow(t.Speed, 2)) * 1000
+	gradientForce := mass * gravity * gradientAt(t.TrainHead) / 1000
+	return (effort - resistance - gradientForce) / mass
+}
+
+// gradientAt returns the gradient in per mille at the given position.
+//
+// Only line items have a gradient, other items are considered flat.
+func gradientAt(pos simulation.Position) float64 {
+	switch ti := pos.TrackItem().(type) {
+	case *simulation.LineItem:
+		return ti.GradientAt(pos)
+	case *simulation.PlatformItem:
+		return ti.GradientAt(pos)
+	case *simulation.InvisibleLinkItem:
+		return ti.GradientAt(pos)
+	}
+	return 0
+}
+
+var _ simulation.TrainsManager = PhysicsManager{}
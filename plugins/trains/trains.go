@@ -38,6 +38,23 @@ type StandardManager struct{}
 
 // Speed computes and returns the speed of the given train after timeElapsed
 func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) float64 {
+	return driverSpeed(t, timeElapsed, t.TrainType().StdBraking, t.TrainType().StdAccel)
+}
+
+// Name of this manager, for use in UI messages
+func (m StandardManager) Name() string {
+	return "Standard Manager"
+}
+
+// Code of this manager, with which trains are set to use it
+func (m StandardManager) Code() string {
+	return "standard"
+}
+
+// driverSpeed computes and returns the speed of the given train after timeElapsed
+// for a driver that accelerates at the given acceleration rate and plans
+// braking at the given braking rate.
+func driverSpeed(t *simulation.Train, timeElapsed time.Duration, braking, accel float64) float64 {
 	if !t.IsActive() || t.Status == simulation.Stopped {
 		return 0
 	}
@@ -45,11 +62,11 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	secs := float64(timeElapsed) / float64(time.Second)
 
 	// maxDistance is the maximum distance we look ahead to find speed limits
-	maxDistance := math.Max(math.Pow(t.Speed, 2)/t.TrainType().StdBraking, defaultMaxDistance)
+	maxDistance := math.Max(math.Pow(t.Speed, 2)/braking, defaultMaxDistance)
 
 	// Get distances to next targets
 	dtnStation, okStation := distanceToNextStop(t, maxDistance)
-	dtnSpeedLimit, speedLimit, okSpeedLimit := nextSpeedLimit(t, maxDistance, secs)
+	dtnSpeedLimit, speedLimit, okSpeedLimit := nextSpeedLimit(t, maxDistance, secs, braking)
 	dtnTrain, okTrain := distanceToNextTrain(t, maxDistance)
 	safetyDistance := lineSafetyDistance
 	if t.IsShunting() {
@@ -64,8 +81,8 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	switch t.ApplicableAction().Target {
 	case simulation.ASAP:
 		// We emulate a distance to next signal to get a stdBraking
-		dtnSignal = (math.Pow(t.Speed-t.TrainType().StdBraking*secs, 2)-math.Pow(t.ApplicableAction().Speed, 2))/
-			(2*t.TrainType().StdBraking) + (t.Speed * secs / 2)
+		dtnSignal = (math.Pow(t.Speed-braking*secs, 2)-math.Pow(t.ApplicableAction().Speed, 2))/
+			(2*braking) + (t.Speed * secs / 2)
 	case simulation.BeforeNextSignal:
 		if nsp.TrackItemID == t.LastSeenSignal().ID() {
 			// The signal with the applicable action is still ahead
@@ -83,19 +100,19 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	// Calculate speeds to manage each target
 	targetSpeedForStation := getMaxSpeed(t)
 	if okStation {
-		targetSpeedForStation = targetSpeed(t, secs, dtnStation, 0)
+		targetSpeedForStation = targetSpeed(t, secs, braking, dtnStation, 0)
 	}
 	targetSpeedForLimit := getMaxSpeed(t)
 	if okSpeedLimit {
-		targetSpeedForLimit = targetSpeed(t, secs, dtnSpeedLimit, speedLimit)
+		targetSpeedForLimit = targetSpeed(t, secs, braking, dtnSpeedLimit, speedLimit)
 	}
 	targetSpeedForTrain := getMaxSpeed(t)
 	if okTrain {
-		targetSpeedForTrain = targetSpeed(t, secs, dtnTrain, 0)
+		targetSpeedForTrain = targetSpeed(t, secs, braking, dtnTrain, 0)
 	}
 	targetSpeedForSignal := getMaxSpeed(t)
 	if okSignal {
-		targetSpeedForSignal = targetSpeed(t, secs, dtnSignal, t.ApplicableAction().Speed)
+		targetSpeedForSignal = targetSpeed(t, secs, braking, dtnSignal, t.ApplicableAction().Speed)
 	}
 	if t.ApplicableAction().Target == simulation.BeforeThisSignal && nsp.TrackItemID != t.LastSeenSignal().ID() {
 		// We passed the signal, and we keep its speed limit until we see the next one.
@@ -105,7 +122,7 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 		math.Min(targetSpeedForLimit,
 			math.Min(targetSpeedForTrain, targetSpeedForSignal)))
 	acceleration := math.Max(-t.TrainType().EmergBraking,
-		math.Min(1/secs*(targetSpeed-t.Speed), t.TrainType().StdAccel))
+		math.Min(1/secs*(targetSpeed-t.Speed), accel))
 	simulation.Logger.Debug("Set Train speed", "ID", t.ID(),
 		"dtnStation", dtnStation,
 		"dtnSpeedLimit", dtnSpeedLimit,
@@ -118,11 +135,6 @@ func (m StandardManager) Speed(t *simulation.Train, timeElapsed time.Duration) f
 	return math.Max(0, t.Speed+acceleration*secs)
 }
 
-// Name of this manager, for use in UI messages
-func (m StandardManager) Name() string {
-	return "Standard Manager"
-}
-
 // distanceToNextSignal returns the distance to the next signal by looking forward of
 // the given train's head
 //
@@ -180,13 +192,13 @@ func distanceToNextStop(t *simulation.Train, maxDistance float64) (float64, bool
 // the given train's head up to a maximum distance of maxDistance.
 //
 // The last argument is true if a new speed limit has been found within maxDistance.
-func nextSpeedLimit(t *simulation.Train, maxDistance, secs float64) (float64, float64, bool) {
+func nextSpeedLimit(t *simulation.Train, maxDistance, secs, braking float64) (float64, float64, bool) {
 	pos := t.TrainHead
 	distance := pos.TrackItem().RealLength() - t.TrainHead.PositionOnTI
 	for pos.TrackItem().Type() != simulation.TypeEnd && distance < maxDistance {
 		pos = pos.Next(simulation.DirectionCurrent)
 		ti := pos.TrackItem()
-		if ti.MaxSpeed() < getMaxSpeed(t)-t.TrainType().StdBraking*secs {
+		if ti.MaxSpeed() < getMaxSpeed(t)-braking*secs {
 			return distance, ti.MaxSpeed(), true
 		}
 		distance += ti.RealLength()
@@ -222,15 +234,15 @@ func distanceToNextTrain(t *simulation.Train, maxDistance float64) (float64, boo
 }
 
 // targetSpeed defines the current target speed of the train depending on the parameters.
-func targetSpeed(t *simulation.Train, secs, targetDistance, targetSpeed float64) float64 {
+func targetSpeed(t *simulation.Train, secs, braking, targetDistance, targetSpeed float64) float64 {
 	// d is the maximum distance that can be travelled during the last
 	// sample. It is used to determine when to stop the train.
-	d := 0.5 * t.TrainType().StdBraking * math.Pow(secs, 2)
+	d := 0.5 * braking * math.Pow(secs, 2)
 	if targetDistance < d {
 		return targetSpeed
 	}
 
-	theoreticalSpeed := calculatedSpeed(t, targetDistance, targetSpeed)
+	theoreticalSpeed := calculatedSpeed(t, braking, targetDistance, targetSpeed)
 
 	// s1 is half the distance run at the train's current speed during secs
 	// This value is used to get a centered sampling of the braking curve.
@@ -239,19 +251,19 @@ func targetSpeed(t *simulation.Train, secs, targetDistance, targetSpeed float64)
 	s2 := theoreticalSpeed * secs / 2
 
 	if theoreticalSpeed < t.Speed {
-		return calculatedSpeed(t, targetDistance-s1, targetSpeed)
+		return calculatedSpeed(t, braking, targetDistance-s1, targetSpeed)
 	}
-	return calculatedSpeed(t, targetDistance-s2, targetSpeed)
+	return calculatedSpeed(t, braking, targetDistance-s2, targetSpeed)
 }
 
 // calculatedSpeed returns the speed the train should be right now to be able to be
 // at a speed of targetSpeedAtPos at a distance of targetDistance from
-// the train head, not exceeding maxSpeed. This function does not take
-// into account any sampling margin.
-func calculatedSpeed(t *simulation.Train, targetDistance, targetSpeed float64) float64 {
+// the train head when braking at the given rate, not exceeding maxSpeed.
+// This function does not take into account any sampling margin.
+func calculatedSpeed(t *simulation.Train, braking, targetDistance, targetSpeed float64) float64 {
 	return math.Min(
 		getMaxSpeed(t),
-		math.Sqrt(math.Abs(2*targetDistance*t.TrainType().StdBraking)+math.Pow(targetSpeed, 2)))
+		math.Sqrt(math.Abs(2*targetDistance*braking)+math.Pow(targetSpeed, 2)))
 }
 
 // getMaxSpeed returns the maximum speed allowed for the train in its current position
@@ -262,5 +274,8 @@ func getMaxSpeed(t *simulation.Train) float64 {
 var _ simulation.TrainsManager = StandardManager{}
 
 func init() {
+	// The StandardManager is registered first so that it is the default one.
 	simulation.RegisterTrainsManager(StandardManager{})
+	simulation.RegisterTrainsManager(CautiousManager{})
+	simulation.RegisterTrainsManager(PhysicsManager{})
 }
@@ -0,0 +1,193 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// runReport is the report written at the end of a headless run
+type runReport struct {
+	Title              string           `json:"title"`
+	Seed               int64            `json:"seed"`
+	StartTime          string           `json:"startTime"`
+	EndTime            string           `json:"endTime"`
+	Score              int              `json:"score"`
+	LateArrivals       int              `json:"lateArrivals"`
+	MaxDelay           int              `json:"maxDelay"`
+	Arrivals           []*arrivalReport `json:"arrivals"`
+	PlatformMismatches []*arrivalReport `json:"platformMismatches"`
+}
+
+// arrivalReport is the report of a train stop at a scheduled place.
+// Delay is in seconds.
+type arrivalReport struct {
+	*simulation.ObservedStop
+	Delay         int  `json:"delay"`
+	WrongPlatform bool `json:"wrongPlatform"`
+}
+
+// runCommand loads the simulation file given in args and runs it headless
+// as fast as possible until the given time, with automatic route setting
+// enabled on all signals.
+// A report is then written with the arrivals of trains and the final score.
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	until := flags.String("until", "", "The simulation time (HH:MM or HH:MM:SS) at which to stop the run.")
+	reportFile := flags.String("report", "", "The filename in which to save the JSON report. If not specified, the report is written to stdout.")
+	csvFile := flags.String("csv", "", "The filename in which to save the timetable with the actual times of the trains as CSV.")
+	stopTimesFile := flags.String("stoptimes", "", "The filename in which to save the actual times of the trains as a GTFS stop_times.txt file.")
+	seed := flags.Int64("seed", 0, "The seed of the random delays of the simulation. If not 0, it overrides the seed option of the simulation file.")
+	logLevel := flags.String("loglevel", "warn", "The minimum level of log to be written. Possible values are 'crit', 'error', 'warn', 'info' and 'debug'.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage of ts2-sim-server run:
+  ts2-sim-server run [options...] file
+
+ARGUMENTS:
+  file
+		The JSON simulation file to run
+
+OPTIONS:
+`)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("please specify a simulation file")
+	}
+	if *until == "" {
+		flags.Usage()
+		return fmt.Errorf("please specify the time at which to stop the run")
+	}
+	untilStr := *until
+	if strings.Count(untilStr, ":") == 1 {
+		untilStr += ":00"
+	}
+	untilTime := simulation.ParseTime(untilStr).Time
+	if untilTime.IsZero() {
+		return fmt.Errorf("invalid time: %s", *until)
+	}
+
+	logger = log.New()
+	logLvl, err := log.LvlFromString(*logLevel)
+	if err != nil {
+		return fmt.Errorf("unknown loglevel: %s", *logLevel)
+	}
+	logger.SetHandler(log.LvlFilterHandler(logLvl, log.StreamHandler(os.Stderr, log.TerminalFormat())))
+	simulation.InitializeLogger(logger)
+
+	simFile := flags.Arg(0)
+	data, err := ioutil.ReadFile(simFile)
+	if err != nil {
+		return fmt.Errorf("unable to read file %s: %s", simFile, err)
+	}
+	var sim simulation.Simulation
+	if err = json.Unmarshal(data, &sim); err != nil {
+		return fmt.Errorf("unable to load %s: %s", simFile, err)
+	}
+	if *seed != 0 {
+		sim.Options.Seed = *seed
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-sim.EventChan:
+			case <-done:
+				return
+			}
+		}
+	}()
+	if err = sim.Initialize(); err != nil {
+		return fmt.Errorf("invalid simulation %s: %s", simFile, err)
+	}
+	startTime := sim.Options.CurrentTime.Format("15:04:05")
+	if !untilTime.After(sim.Options.CurrentTime.Time) {
+		return fmt.Errorf("%s is before the start of the simulation at %s", *until, startTime)
+	}
+	logger.Info("Running simulation", "sim", sim.Options.Title, "until", untilStr)
+
+	for _, ti := range sim.TrackItems {
+		if si, ok := ti.(*simulation.SignalItem); ok {
+			if err = si.SetARSEnabled(true); err != nil {
+				return err
+			}
+		}
+	}
+	for sim.Options.CurrentTime.Time.Before(untilTime) {
+		if err = sim.Step(1); err != nil {
+			return err
+		}
+	}
+
+	report := runReport{
+		Title:              sim.Options.Title,
+		Seed:               sim.Options.Seed,
+		StartTime:          startTime,
+		EndTime:            sim.Options.CurrentTime.Format("15:04:05"),
+		Score:              sim.Options.CurrentScore,
+		Arrivals:           []*arrivalReport{},
+		PlatformMismatches: []*arrivalReport{},
+	}
+	for _, st := range sim.ObservedStops() {
+		ar := arrivalReport{
+			ObservedStop:  st,
+			Delay:         int(st.ArrivalDelay() / time.Second),
+			WrongPlatform: st.WrongPlatform(),
+		}
+		report.Arrivals = append(report.Arrivals, &ar)
+		if ar.WrongPlatform {
+			report.PlatformMismatches = append(report.PlatformMismatches, &ar)
+		}
+		if st.ArrivalDelay() > time.Minute {
+			report.LateArrivals++
+		}
+		if ar.Delay > report.MaxDelay {
+			report.MaxDelay = ar.Delay
+		}
+	}
+	if err = writeTimetables(&sim, *csvFile, *stopTimesFile, true); err != nil {
+		return err
+	}
+	res, err := json.MarshalIndent(&report, "", "    ")
+	if err != nil {
+		return fmt.Errorf("unable to write report: %s", err)
+	}
+	if *reportFile == "" {
+		fmt.Println(string(res))
+		return nil
+	}
+	if err = ioutil.WriteFile(*reportFile, res, 0644); err != nil {
+		return fmt.Errorf("unable to write report: %s", err)
+	}
+	logger.Info("Report written", "file", *reportFile)
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiCollection is a collection of objects of the HTTP API.
+type apiCollection struct {
+	// object is the name of the hub object handling the requests
+	object string
+	// intIDs is true if the objects of this collection have integer IDs
+	intIDs bool
+	// actionsOnly is true if this collection has no objects, only actions
+	actionsOnly bool
+	// noShow is true if the hub object has no show action for single objects
+	noShow bool
+}
+
+// apiCollections maps the collection names of the HTTP API to hub objects.
+var apiCollections = map[string]apiCollection{
+	"trains":     {object: "train", intIDs: true},
+	"routes":     {object: "route"},
+	"areas":      {object: "area"},
+	"trackItems": {object: "trackItem"},
+	"places":     {object: "place"},
+	"services":   {object: "service"},
+	"trainTypes": {object: "trainType"},
+	"options":    {object: "option", noShow: true},
+	"ars":        {object: "ars", noShow: true},
+	"simulation": {object: "simulation", actionsOnly: true},
+}
+
+// serveAPI serves the REST/JSON API by translating HTTP requests into hub
+// requests, so that the behaviour is the same as on the websocket.
+//
+//	GET  /api/{collection}               - list action
+//	GET  /api/{collection}/{id}          - show action for this ID only, if the
+//	                                       collection has one
+//	POST /api/{collection}/{id}/{action} - action on the object with this ID, the
+//	                                       body holding the other parameters
+//	POST /api/{collection}/{action}      - action on the collection, the body
+//	                                       holding the parameters
+//	PUT  /api/{collection}/{name}        - set action with the body as value
+//
+// For the simulation collection, GET and POST /api/simulation/{action} call
+// the action directly.
+//
+// Requests must be authenticated with the client token of the simulation. The
+// simulation instance is given in the `sim` query parameter.
+func serveAPI(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("New API request", "submodule", "http", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+	h := requestHub(r)
+	if h == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	cred, ok := authenticateRequest(h, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	coll, ok := apiCollections[segments[0]]
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read request: %s", err), http.StatusBadRequest)
+		return
+	}
+	req := Request{Object: coll.object, Params: RawJSON("null")}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		req.Params = body
+	}
+	single := false
+	switch {
+	case r.Method == http.MethodGet && len(segments) == 1:
+		req.Action = "list"
+	case (r.Method == http.MethodGet || r.Method == http.MethodPost) && len(segments) == 2 && coll.actionsOnly:
+		req.Action = segments[1]
+	case r.Method == http.MethodGet && len(segments) == 2 && !coll.noShow:
+		id, err := coll.idJSON(segments[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		req.Action = "show"
+		req.Params = RawJSON(fmt.Sprintf(`{"ids": [%s]}`, id))
+		single = true
+	case r.Method == http.MethodPost && len(segments) == 2:
+		req.Action = segments[1]
+	case r.Method == http.MethodPost && len(segments) == 3:
+		id, err := coll.idJSON(segments[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		req.Action = segments[2]
+		if req.Params, err = withParam(req.Params, "id", id); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid parameters: %s", err), http.StatusBadRequest)
+			return
+		}
+	case r.Method == http.MethodPut && len(segments) == 2:
+		name, _ := json.Marshal(segments[1])
+		req.Action = "set"
+		req.Params = RawJSON(fmt.Sprintf(`{"name": %s, "value": %s}`, name, req.Params))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	obj, ok := hubObjects[req.Object]
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	conn := &connection{
+		hub:        h,
+		pushChan:   make(chan interface{}, 1),
+		clientType: Client,
+		credential: cred,
+	}
+	obj.dispatch(h, req, conn)
+	writeAPIResponse(w, <-conn.pushChan, single)
+}
+
+// idJSON returns the JSON representation of the given object ID of this collection.
+func (c apiCollection) idJSON(id string) (string, error) {
+	if c.intIDs {
+		if _, err := strconv.Atoi(id); err != nil {
+			return "", fmt.Errorf("invalid %s ID: %s", c.object, id)
+		}
+		return id, nil
+	}
+	res, err := json.Marshal(id)
+	return string(res), err
+}
+
+// withParam returns the given JSON object params with the key set to the given JSON value.
+func withParam(params RawJSON, key, value string) (RawJSON, error) {
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = make(map[string]json.RawMessage)
+	}
+	p[key] = json.RawMessage(value)
+	res, err := json.Marshal(p)
+	return RawJSON(res), err
+}
+
+// writeAPIResponse writes the given hub response to w.
+//
+// Status messages are written with a 400 HTTP status if they failed. If single
+// is true, the response data is a list or a map with a single object, which is
+// written alone.
+func writeAPIResponse(w http.ResponseWriter, resp interface{}, single bool) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	switch r := resp.(type) {
+	case *ResponseStatus:
+		if r.Data.Status == Fail {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_ = json.NewEncoder(w).Encode(r.Data)
+	case *Response:
+		data := []byte(r.Data)
+		if single {
+			data = singleObject(data)
+		}
+		_, _ = w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("internal error: unexpected response %v", resp), http.StatusInternalServerError)
+	}
+}
+
+// singleObject returns the only object of the given JSON list or map, or data
+// itself if it does not hold a single object.
+func singleObject(data []byte) []byte {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err == nil && len(list) == 1 {
+		return list[0]
+	}
+	var objects map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objects); err == nil && len(objects) == 1 {
+		for _, obj := range objects {
+			return obj
+		}
+	}
+	return data
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// apiRequest sends a request to the HTTP API with the given token and returns
+// the HTTP status and the body of the response.
+func apiRequest(method, path, token, body string) (int, string) {
+	req, err := http.NewRequest(method, "http://127.0.0.1:22222"+path, strings.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// waitForAPI polls the HTTP API until it answers, or fails the test after a
+// few seconds.
+func waitForAPI(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := apiRequest("GET", "/api/simulation/isStarted", "client-secret", "")
+		if status == http.StatusOK {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("HTTP API not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	waitForAPI(t)
+	Convey("Testing the HTTP API", t, func() {
+		Convey("Requests without a valid token should fail", func() {
+			status, _ := apiRequest("GET", "/api/trains", "", "")
+			So(status, ShouldEqual, http.StatusUnauthorized)
+			status, _ = apiRequest("GET", "/api/trains", "wrong-secret", "")
+			So(status, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Tokens without the Bearer prefix should fail", func() {
+			req, err := http.NewRequest("GET", "http://127.0.0.1:22222/api/trains?token=client-secret", nil)
+			So(err, ShouldBeNil)
+			req.Header.Set("Authorization", "client-secret")
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Unknown collections should fail", func() {
+			status, _ := apiRequest("GET", "/api/undefined", "client-secret", "")
+			So(status, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Listing trains", func() {
+			status, body := apiRequest("GET", "/api/trains", "client-secret", "")
+			So(status, ShouldEqual, http.StatusOK)
+			var trains []simulation.Train
+			So(json.Unmarshal([]byte(body), &trains), ShouldBeNil)
+			So(trains, ShouldHaveLength, 2)
+		})
+		Convey("Showing a train", func() {
+			status, body := apiRequest("GET", "/api/trains/1", "client-secret", "")
+			So(status, ShouldEqual, http.StatusOK)
+			var train struct {
+				ID          string `json:"id"`
+				ServiceCode string `json:"serviceCode"`
+			}
+			So(json.Unmarshal([]byte(body), &train), ShouldBeNil)
+			So(train.ID, ShouldEqual, "1")
+			So(train.ServiceCode, ShouldEqual, hub.sim.Trains[1].ServiceCode)
+		})
+		Convey("Showing an unknown train should fail", func() {
+			status, body := apiRequest("GET", "/api/trains/999", "client-secret", "")
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(body, ShouldContainSubstring, `"message":"Error: unknown train: 999"`)
+			status, _ = apiRequest("GET", "/api/trains/abc", "client-secret", "")
+			So(status, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Activating a route", func() {
+			status, body := apiRequest("POST", "/api/routes/1/activate", "client-secret", "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldContainSubstring, `"message":"Route 1 activated successfully"`)
+		})
+		Convey("Activating a conflicting route should fail as on the websocket", func() {
+			status, body := apiRequest("POST", "/api/routes/2/activate", "client-secret", `{"persistent": true}`)
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(body, ShouldContainSubstring, `"message":"Error: cannot activate route 2: Standard Manager vetoed route activation: conflicting route 1 is active"`)
+		})
+		Convey("Setting an option", func() {
+			status, body := apiRequest("PUT", "/api/options/description", "client-secret", `"New description"`)
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldContainSubstring, `"status":"OK"`)
+			So(hub.sim.Options.Description, ShouldEqual, "New description")
+			status, body = apiRequest("PUT", "/api/options/undefined", "client-secret", `85`)
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(body, ShouldContainSubstring, `"message":"Error: error while setting option: unknown option undefined"`)
+		})
+		Convey("Calling simulation actions", func() {
+			status, body := apiRequest("GET", "/api/simulation/isStarted", "client-secret", "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "false")
+		})
+		Convey("Wrong methods should fail", func() {
+			status, _ := apiRequest("DELETE", "/api/trains/1", "client-secret", "")
+			So(status, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+		Convey("Showing objects of collections without show action should fail", func() {
+			status, _ := apiRequest("GET", "/api/options/description", "client-secret", "")
+			So(status, ShouldEqual, http.StatusMethodNotAllowed)
+			status, _ = apiRequest("GET", "/api/ars/STN", "client-secret", "")
+			So(status, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
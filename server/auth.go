@@ -0,0 +1,263 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// A Role defines what a registered client is allowed to do.
+type Role string
+
+const (
+	// RoleSupervisor can do everything, including changing the simulation
+	// options and injecting failures.
+	RoleSupervisor Role = "supervisor"
+	// RoleSignaller can operate routes, signals and trains, restricted to the
+	// signals of its places if any.
+	RoleSignaller Role = "signaller"
+	// RoleObserver can only read data and listen to events.
+	RoleObserver Role = "observer"
+)
+
+// level returns the level of permissions of this Role.
+func (r Role) level() int {
+	switch r {
+	case RoleSupervisor:
+		return 2
+	case RoleSignaller:
+		return 1
+	}
+	return 0
+}
+
+// A Credential grants a role to the clients that register with its token.
+//
+// Places restricts signallers to the signals of these places. Signallers
+// with no places may operate all signals.
+type Credential struct {
+	Token  string   `json:"token"`
+	Role   Role     `json:"role"`
+	Places []string `json:"places"`
+}
+
+// credentials are the credentials with which clients can register.
+//
+// If it is empty, clients register with the clientToken option of the
+// simulation and get the supervisor role.
+var credentials []Credential
+
+// SetCredentials sets the credentials with which clients can register,
+// replacing the clientToken option of the simulation.
+func SetCredentials(creds []Credential) error {
+	for _, c := range creds {
+		switch c.Role {
+		case RoleSupervisor, RoleSignaller, RoleObserver:
+		default:
+			return fmt.Errorf("unknown role: %s", c.Role)
+		}
+		if c.Token == "" {
+			return fmt.Errorf("empty token for role %s", c.Role)
+		}
+	}
+	credentials = creds
+	return nil
+}
+
+// LoadCredentials reads the credentials with which clients can register from
+// the given JSON file, which holds a list of credential objects.
+func LoadCredentials(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to read file %s: %s", fileName, err)
+	}
+	var creds []Credential
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return fmt.Errorf("unable to load %s: %s", fileName, err)
+	}
+	return SetCredentials(creds)
+}
+
+// authenticate returns the Credential with the given token for the
+// simulation of this hub.
+//
+// The second returned value is false if no credential matches the token.
+func (h *Hub) authenticate(token string) (Credential, bool) {
+	if len(credentials) == 0 {
+		if token == h.currentSim().Options.ClientToken {
+			return Credential{Token: token, Role: RoleSupervisor}, true
+		}
+		return Credential{}, false
+	}
+	for _, c := range credentials {
+		if c.Token == token {
+			return c, true
+		}
+	}
+	return Credential{}, false
+}
+
+// authorize returns an error if the client of this connection does not have
+// at least the permissions of the given role.
+func (conn *connection) authorize(role Role) error {
+	if conn.credential.Role.level() < role.level() {
+		return fmt.Errorf("permission denied: %s role required", role)
+	}
+	return nil
+}
+
+// authorizeSignal returns an error if the client of this connection is not
+// allowed to operate the given signal.
+func (conn *connection) authorizeSignal(si *simulation.SignalItem) error {
+	if err := conn.authorize(RoleSignaller); err != nil {
+		return err
+	}
+	if conn.credential.Role == RoleSupervisor || len(conn.credential.Places) == 0 {
+		return nil
+	}
+	if !signalInPlaces(conn.hub.currentSim(), si, conn.credential.Places) {
+		return fmt.Errorf("permission denied: signal %s is outside of your area", si.ID())
+	}
+	return nil
+}
+
+// authorizeRoute returns an error if the client of this connection is not
+// allowed to activate or deactivate the given route.
+//
+// Routes that belong to controlled areas may only be operated by the
+// controller of one of these areas.
+func (conn *connection) authorizeRoute(r *simulation.Route) error {
+	if err := conn.authorizeSignal(r.BeginSignal()); err != nil {
+		return err
+	}
+	if conn.controlledByOthers(conn.hub.currentSim().AreasOf(r)) {
+		return fmt.Errorf("permission denied: route %s is outside of your area", r.ID())
+	}
+	return nil
+}
+
+// authorizeTrain returns an error if the client of this connection is not
+// allowed to give orders to the given train.
+//
+// The track item of the head of the train must be in the places of the
+// client, and trains in controlled areas may only be operated by the
+// controller of one of these areas.
+func (conn *connection) authorizeTrain(t *simulation.Train) error {
+	if err := conn.authorize(RoleSignaller); err != nil {
+		return err
+	}
+	ti := t.TrainHead.TrackItem()
+	if conn.credential.Role != RoleSupervisor && len(conn.credential.Places) > 0 &&
+		!trackItemInPlaces(conn.hub.currentSim(), ti, conn.credential.Places) {
+		return fmt.Errorf("permission denied: train %s is outside of your area", t.ID())
+	}
+	if conn.controlledByOthers(conn.hub.currentSim().AreasOfTrackItem(ti)) {
+		return fmt.Errorf("permission denied: train %s is outside of your area", t.ID())
+	}
+	return nil
+}
+
+// controlledByOthers returns true if some of the given areas are controlled
+// by other clients and none of them by the client of this connection.
+func (conn *connection) controlledByOthers(areas []*simulation.Area) bool {
+	controlled := false
+	for _, a := range areas {
+		switch a.Controller() {
+		case "":
+		case conn.name:
+			return false
+		default:
+			controlled = true
+		}
+	}
+	return controlled
+}
+
+// authorizeArea returns an error if the client of this connection is not
+// allowed to take control of the given area.
+//
+// Signallers may only take areas that are not controlled by another client,
+// whereas supervisors may take any area.
+func (conn *connection) authorizeArea(a *simulation.Area) error {
+	if err := conn.authorize(RoleSignaller); err != nil {
+		return err
+	}
+	if conn.name == "" {
+		return fmt.Errorf("permission denied: unnamed clients cannot control areas")
+	}
+	ctrl := a.Controller()
+	if ctrl == "" || ctrl == conn.name || conn.credential.Role == RoleSupervisor {
+		return nil
+	}
+	return fmt.Errorf("permission denied: area %s is controlled by %s", a.ID(), ctrl)
+}
+
+// signalInPlaces returns true if the given signal belongs to one of the given
+// places, or if a route starting at this signal goes through one of them.
+func signalInPlaces(sim *simulation.Simulation, si *simulation.SignalItem, places []string) bool {
+	if itemInPlaces(si, places) {
+		return true
+	}
+	for _, r := range sim.RoutesFrom(si) {
+		for _, pos := range r.Positions {
+			if itemInPlaces(pos.TrackItem(), places) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackItemInPlaces returns true if the given track item belongs to one of
+// the given places, or if it is on a route the begin signal of which is in
+// one of them.
+func trackItemInPlaces(sim *simulation.Simulation, ti simulation.TrackItem, places []string) bool {
+	if si, ok := ti.(*simulation.SignalItem); ok {
+		return signalInPlaces(sim, si, places)
+	}
+	if itemInPlaces(ti, places) {
+		return true
+	}
+	for _, r := range sim.Routes {
+		for _, pos := range r.Positions {
+			if pos.TrackItemID == ti.ID() && signalInPlaces(sim, r.BeginSignal(), places) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// itemInPlaces returns true if the place of the given track item is one of
+// the given places.
+func itemInPlaces(ti simulation.TrackItem, places []string) bool {
+	pl := ti.Place()
+	if pl == nil {
+		return false
+	}
+	for _, code := range places {
+		if pl.PlaceCode == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+func TestRoles(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+	Convey("Testing roles", t, func() {
+		err := SetCredentials([]Credential{
+			{Token: "supervisor-secret", Role: RoleSupervisor},
+			{Token: "stn-secret", Role: RoleSignaller, Places: []string{"STN"}},
+			{Token: "rgt-secret", Role: RoleSignaller, Places: []string{"RGT"}},
+			{Token: "observer-secret", Role: RoleObserver},
+		})
+		So(err, ShouldBeNil)
+		c := clientDial(t)
+		Convey("Unknown roles should be rejected", func() {
+			err := SetCredentials([]Credential{{Token: "secret", Role: "driver"}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown role: driver")
+		})
+		Convey("The client token should not be valid any more", func() {
+			err := register(t, c, Client, "", "client-secret")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Error: invalid register parameters")
+		})
+		Convey("Observers should only read data", func() {
+			So(register(t, c, Client, "", "observer-secret"), ShouldBeNil)
+			err := c.WriteJSON(Request{Object: "simulation", Action: "isStarted"})
+			So(err, ShouldBeNil)
+			var started Response
+			err = c.ReadJSON(&started)
+			So(err, ShouldBeNil)
+			So(string(started.Data), ShouldEqual, "false")
+			resp := sendRequestStatus(c, "route", "activate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: signaller role required")
+			resp = sendRequestStatus(c, "train", "proceed", `{"id": 0}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: signaller role required")
+		})
+		Convey("Signallers should only operate their area", func() {
+			So(register(t, c, Client, "", "stn-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "route", "activate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "simulation", "pause", "")
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: supervisor role required")
+			resp = sendRequestStatus(c, "option", "set", `{"name": "title", "value": "Signaller Title"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: supervisor role required")
+		})
+		Convey("Signallers should not operate outside their area", func() {
+			So(register(t, c, Client, "", "rgt-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "route", "deactivate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: signal 5 is outside of your area")
+			resp = sendRequestStatus(c, "ars", "enable", `{"ids": ["5"]}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: signal 5 is outside of your area")
+			resp = sendRequestStatus(c, "train", "proceed", `{"id": 0}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: train 0 is outside of your area")
+			resp = sendRequestStatus(c, "train", "reverse", `{"id": 0}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: train 0 is outside of your area")
+		})
+		Convey("Supervisors should do everything", func() {
+			So(register(t, c, Client, "", "supervisor-secret"), ShouldBeNil)
+			resp := sendRequestStatus(c, "option", "set", `{"name": "description", "value": "Supervised"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+		})
+		Convey("The HTTP API should use the same roles", func() {
+			status, body := apiRequest("POST", "/api/routes/1/activate", "observer-secret", "")
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(body, ShouldContainSubstring, `"message":"Error: permission denied: signaller role required"`)
+			status, _ = apiRequest("GET", "/api/routes", "client-secret", "")
+			So(status, ShouldEqual, http.StatusUnauthorized)
+		})
+		Reset(func() {
+			_ = c.Close()
+			So(SetCredentials(nil), ShouldBeNil)
+		})
+	})
+}
+
+// registerNamed logs the client in with the given token and client name.
+func registerNamed(c *websocket.Conn, token, name string) ResponseStatus {
+	err := c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{Client, "", token, name}})
+	So(err, ShouldBeNil)
+	var resp ResponseStatus
+	err = c.ReadJSON(&resp)
+	So(err, ShouldBeNil)
+	return resp
+}
+
+// readControlChange reads the next areaControlChanged notification and
+// returns the area it holds.
+func readControlChange(c *websocket.Conn) map[string]interface{} {
+	var event struct {
+		MsgType MessageType `json:"msgType"`
+		Data    struct {
+			Name   simulation.EventName   `json:"name"`
+			Object map[string]interface{} `json:"object"`
+		} `json:"data"`
+	}
+	err := c.ReadJSON(&event)
+	So(err, ShouldBeNil)
+	So(event.MsgType, ShouldEqual, TypeNotification)
+	So(event.Data.Name, ShouldEqual, simulation.AreaControlChangedEvent)
+	return event.Data.Object
+}
+
+func TestAreas(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+	Convey("Testing areas of control", t, func() {
+		err := SetCredentials([]Credential{
+			{Token: "supervisor-secret", Role: RoleSupervisor},
+			{Token: "box-secret", Role: RoleSignaller},
+		})
+		So(err, ShouldBeNil)
+		c1 := clientDial(t)
+		c2 := clientDial(t)
+		c3 := clientDial(t)
+		Convey("Signallers should only operate the areas they control", func() {
+			So(registerNamed(c1, "box-secret", "box1").Data.Status, ShouldEqual, Ok)
+			So(registerNamed(c2, "box-secret", "box2").Data.Status, ShouldEqual, Ok)
+			resp := registerNamed(c3, "box-secret", "box1")
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: client name box1 already in use")
+
+			resp = sendRequestStatus(c2, "server", "addListener", `{"event": "areaControlChanged"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			resp = sendRequestStatus(c1, "area", "take", `{"id": "STN"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(resp.Data.Message, ShouldEqual, "Area STN taken successfully")
+			area := readControlChange(c2)
+			So(area["id"], ShouldEqual, "STN")
+			So(area["controller"], ShouldEqual, "box1")
+
+			resp = sendRequestStatus(c2, "route", "deactivate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: route 1 is outside of your area")
+			resp = sendRequestStatus(c2, "area", "take", `{"id": "STN"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: area STN is controlled by box1")
+			resp = sendRequestStatus(c2, "area", "handOver", `{"id": "STN", "client": "box2"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: area STN is not under your control")
+			resp = sendRequestStatus(c2, "route", "activate", `{"id": "11"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			// Move the head of train 1 on item 4, which is on route 3 of STN
+			head := hub.sim.Trains[1].TrainHead
+			hub.sim.Trains[1].TrainHead = head.Add(500)
+			So(hub.sim.Trains[1].TrainHead.TrackItemID, ShouldEqual, "4")
+			resp = sendRequestStatus(c2, "train", "setService", `{"id": 1, "service": "S001"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: train 1 is outside of your area")
+			resp = sendRequestStatus(c1, "train", "proceed", `{"id": 1}`)
+			So(resp.Data.Message, ShouldNotContainSubstring, "permission denied")
+			hub.sim.Trains[1].TrainHead = head
+
+			resp = sendRequestStatus(c1, "route", "activate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c1, "area", "handOver", `{"id": "STN", "client": "nobody"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: unknown client: nobody")
+			resp = sendRequestStatus(c1, "area", "handOver", `{"id": "STN", "client": "box2"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(resp.Data.Message, ShouldEqual, "Area STN handed over successfully")
+			area = readControlChange(c2)
+			So(area["controller"], ShouldEqual, "box2")
+
+			resp = sendRequestStatus(c1, "route", "deactivate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: permission denied: route 1 is outside of your area")
+
+			c4 := clientDial(t)
+			defer c4.Close()
+			So(registerNamed(c4, "supervisor-secret", "chief").Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c4, "area", "handOver", `{"id": "STN", "client": ""}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			area = readControlChange(c2)
+			So(area["controller"], ShouldBeNil)
+			resp = sendRequestStatus(c1, "route", "activate", `{"id": "1"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+		})
+		Reset(func() {
+			_ = c1.Close()
+			_ = c2.Close()
+			_ = c3.Close()
+			So(SetCredentials(nil), ShouldBeNil)
+		})
+	})
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
@@ -30,19 +31,42 @@ import (
 type ClientType string
 
 const (
-	Client ClientType = "client"
+	Client  ClientType = "client"
+	Manager ClientType = "manager"
 )
 
 type ManagerType string
 
+const (
+	RoutesManager  ManagerType = "routes"
+	PointsManager  ManagerType = "points"
+	SignalsManager ManagerType = "signals"
+	TrainsManager  ManagerType = "trains"
+	LinesManager   ManagerType = "lines"
+)
+
 // connection is a wrapper around the websocket.Conn
 type connection struct {
 	websocket.Conn
+	// hub is the hub of the simulation instance this connection is bound to
+	hub *Hub
 	// pushChan is the channel on which pushed messaged are sent
 	pushChan    chan interface{}
 	clientType  ClientType
 	ManagerType ManagerType
 	Requests    []Request
+	// credential is the credential the client registered with
+	credential Credential
+	// name identifies the client, e.g. as the controller of an area
+	name string
+	// calls holds the channels on which the responses of a manager to the
+	// pending requests of the simulation are sent, by request ID.
+	calls      map[int]chan RawJSON
+	callsMutex sync.Mutex
+	lastCallID int
+	// unregisterManager restores the managers of the simulation that this
+	// manager replaced.
+	unregisterManager func()
 }
 
 // loop starts the reading and writing loops of the connection.
@@ -68,8 +92,9 @@ func (conn *connection) processRead(ctx context.Context) {
 			return
 		default:
 		}
-		var req Request
-		err := conn.ReadJSON(&req)
+		var msg message
+		err := conn.ReadJSON(&msg)
+		req := msg.Request
 		if err != nil {
 			switch err.(type) {
 			case *websocket.CloseError, net.Error:
@@ -82,8 +107,12 @@ func (conn *connection) processRead(ctx context.Context) {
 				continue
 			}
 		}
+		if msg.MsgType == TypeResponse {
+			conn.deliver(msg.ID, msg.Data)
+			continue
+		}
 		conn.Requests = append(conn.Requests, req)
-		hub.readChan <- conn
+		conn.hub.readChan <- conn
 	}
 }
 
@@ -92,6 +121,11 @@ func (conn *connection) processWrite(ctx context.Context) {
 	for {
 		select {
 		case req := <-conn.pushChan:
+			if cf, ok := req.(*closeFrame); ok {
+				conn.writeClose()
+				close(cf.done)
+				continue
+			}
 			if err := conn.WriteJSON(req); err != nil {
 				logger.Info("Error while writing", "connection", conn.RemoteAddr(), "request", req, "error", err)
 			}
@@ -118,25 +152,50 @@ func (conn *connection) registerClient() (error, *Request) {
 	}
 
 	// Authenticate client and type
-	if registerParams.ClientType == Client &&
-		registerParams.Token == sim.Options.ClientToken {
+	cred, ok := conn.hub.authenticate(registerParams.Token)
+	if !ok {
+		return fmt.Errorf("invalid register parameters"), req
+	}
+	switch registerParams.ClientType {
+	case Client:
 		conn.clientType = Client
-	} else {
+		conn.credential = cred
+	case Manager:
+		if cred.Role != RoleSupervisor {
+			return fmt.Errorf("permission denied: %s role required", RoleSupervisor), req
+		}
+		conn.clientType = Manager
+		conn.ManagerType = registerParams.ClientSubType
+		conn.credential = cred
+	default:
 		return fmt.Errorf("invalid register parameters"), req
 	}
+	conn.name = registerParams.Name
+	if conn.name == "" {
+		conn.name = conn.RemoteAddr().String()
+	}
+	if conn.hub.clientNamed(conn.name) != nil {
+		return fmt.Errorf("client name %s already in use", conn.name), req
+	}
+	if conn.clientType == Manager {
+		conn.calls = make(map[int]chan RawJSON)
+		if err := conn.registerManager(conn.hub.currentSim()); err != nil {
+			return err, req
+		}
+	}
 
 	// authenticated, so setup
 	if err := conn.WriteJSON(NewOkResponse(req.ID, "Successfully registered")); err != nil {
 		logger.Info("Error while writing", "connection", conn.RemoteAddr(), "request", "NewOkResponse", "error", err)
 	}
-	hub.registerChan <- conn
-	logger.Info("Registered client", "connection", conn.RemoteAddr(), "clientType", conn.clientType, "managerType", conn.ManagerType)
+	conn.hub.registerChan <- conn
+	logger.Info("Registered client", "connection", conn.RemoteAddr(), "clientType", conn.clientType, "managerType", conn.ManagerType, "name", conn.name, "role", conn.credential.Role)
 	return nil, req
 }
 
 // Close terminates the websocket connection and closes associated resources
 func (conn *connection) Close() error {
 	_ = conn.Conn.Close()
-	hub.unregisterChan <- conn
+	conn.hub.unregisterChan <- conn
 	return nil
 }
@@ -60,7 +60,7 @@ func TestConnection(t *testing.T) {
 		Convey("Login double test", func() {
 			err := register(t, c, Client, "", "client-secret")
 			So(err, ShouldBeNil)
-			err = c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{Client, "", "client-secret"}})
+			err = c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{Client, "", "client-secret", ""}})
 			So(err, ShouldBeNil)
 			var resp ResponseStatus
 			err = c.ReadJSON(&resp)
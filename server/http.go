@@ -21,11 +21,13 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/rakyll/statik/fs"
@@ -41,7 +43,7 @@ const (
 )
 
 var (
-	sim    *simulation.Simulation
+	// hub is the hub of the default simulation instance
 	hub    *Hub
 	logger log.Logger
 )
@@ -54,14 +56,19 @@ func InitializeLogger(parentLogger log.Logger) {
 // Run starts a http web server and websocket hub for the given simulation, on the given address and port.
 func Run(s *simulation.Simulation, addr, port string) {
 	logger.Info("Starting server")
-	sim = s
+	hub.setSim(s)
 	hubUp := make(chan bool)
 	timer := time.After(MaxHubStartupTime)
 	go hub.run(hubUp)
 	select {
 	case <-hubUp:
 		HttpdStart(addr, port)
-		os.Exit(1)
+		select {
+		case <-shutdownChan:
+			// Server stopped by Shutdown
+		default:
+			os.Exit(1)
+		}
 	case <-timer:
 		log.Crit("Hub did not start")
 		os.Exit(1)
@@ -74,6 +81,15 @@ func Run(s *simulation.Simulation, addr, port string) {
 //        It also includes a JavaScript WebSocket client to communicate and manage the server.
 //
 //    /ws - WebSocket endpoint for all TS2 clients and managers.
+//
+//    /ws/{name} - WebSocket endpoint of the simulation instance with this name.
+//
+//    /api/ - REST/JSON API for scripts, mirroring the websocket hub objects.
+//
+//    /events - Server-Sent Events stream of the simulation notifications.
+//
+// The /api/ and /events routes serve the simulation instance given in the
+// `sim` query parameter, or the default one if it is not given.
 func HttpdStart(addr, port string) {
 	statikFS, err := fs.New()
 	if err != nil {
@@ -96,10 +112,18 @@ func HttpdStart(addr, port string) {
 
 	http.HandleFunc("/", serveHome)
 	http.HandleFunc("/ws", serveWs)
+	http.HandleFunc("/ws/", serveWs)
+	http.HandleFunc("/api/", serveAPI)
+	http.HandleFunc("/events", serveEvents)
 
 	serverAddress := fmt.Sprintf("%s:%s", addr, port)
 	logger.Info("Starting HTTP", "submodule", "http", "address", serverAddress)
-	err = http.ListenAndServe(serverAddress, nil)
+	httpServer = &http.Server{Addr: serverAddress}
+	err = httpServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		logger.Info("HTTP server stopped", "submodule", "http")
+		return
+	}
 	logger.Crit("HTTP crashed", "submodule", "http", "error", err)
 }
 
@@ -120,11 +144,136 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 		Description string
 		Host        string
 	}{
-		sim.Options.Title,
-		sim.Options.Description,
+		hub.currentSim().Options.Title,
+		hub.currentSim().Options.Description,
 		"ws://" + r.Host + "/ws",
 	}
 	homeTempl.Execute(w, data)
 }
 
 var homeTempl *template.Template
+
+// requestHub returns the hub of the simulation instance given in the `sim`
+// query parameter of the given HTTP request, or nil if there is none.
+func requestHub(r *http.Request) *Hub {
+	return hubNamed(r.URL.Query().Get("sim"))
+}
+
+// authenticateRequest returns the Credential of the given HTTP request on the
+// given hub, whose token is given either in an `Authorization: Bearer <token>`
+// header or in a `token` query parameter.
+//
+// The second returned value is false if the request is not authenticated,
+// including when the Authorization header is not a Bearer token.
+func authenticateRequest(h *Hub, r *http.Request) (Credential, bool) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return Credential{}, false
+		}
+		return h.authenticate(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return h.authenticate(r.URL.Query().Get("token"))
+}
+
+// serveEvents streams the notifications of the simulation as Server-Sent Events.
+//
+// The `event` query parameter, which can be repeated, selects the names of the
+// events to stream. The `ids` query parameter is an optional comma separated
+// list of object IDs to restrict the events to, as in listener requests.
+//
+// The last notification of each selected event and object is sent on connect.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("New events connection", "submodule", "http", "remote", r.RemoteAddr)
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h := requestHub(r)
+	if h == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := authenticateRequest(h, r); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	events := query["event"]
+	if len(events) == 0 {
+		http.Error(w, "No event given", http.StatusBadRequest)
+		return
+	}
+	ids := []string{""}
+	if idsStr := query.Get("ids"); idsStr != "" {
+		ids = strings.Split(idsStr, ",")
+	}
+	conn := &connection{
+		hub:        h,
+		pushChan:   make(chan interface{}, 256),
+		clientType: Client,
+	}
+	for _, event := range events {
+		for _, id := range ids {
+			h.addConnectionToRegistry(conn, simulation.EventName(event), id)
+		}
+	}
+	defer func() {
+		// Drain notifications being sent while we unregister
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-conn.pushChan:
+				case <-done:
+					return
+				}
+			}
+		}()
+		defer close(done)
+		for _, event := range events {
+			for _, id := range ids {
+				h.removeEntryFromRegistry(conn, simulation.EventName(event), id)
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+	go func() {
+		_ = h.renotifyClient(Request{}, conn)
+	}()
+	for {
+		select {
+		case resp := <-conn.pushChan:
+			notification, ok := resp.(*ResponseNotification)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(notification)
+			if err != nil {
+				logger.Info("Error while writing event", "submodule", "http", "remote", r.RemoteAddr, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", notification.Data.Name, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			logger.Debug("Events connection closed", "submodule", "http", "remote", r.RemoteAddr)
+			return
+		case <-shutdownChan:
+			data, _ := json.Marshal(NewNotificationResponse(&simulation.Event{
+				Name:   ServerShutdownEvent,
+				Object: messageObject{Message: "Server shutting down"},
+			}))
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ServerShutdownEvent, data)
+			flusher.Flush()
+			return
+		}
+	}
+}
@@ -4,11 +4,14 @@
 package server
 
 import (
+	"bufio"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
 )
 
 func TestHTTP(t *testing.T) {
@@ -33,3 +36,72 @@ func TestHTTP(t *testing.T) {
 		})
 	})
 }
+
+// readEvent reads the next Server-Sent Event of the given stream and returns
+// its name and data.
+func readEvent(r *bufio.Reader) (string, string, error) {
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return name, data, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data, nil
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(500 * time.Millisecond)
+	Convey("Testing Server-Sent Events", t, func() {
+		Convey("Streaming without token should fail", func() {
+			res, err := http.Get("http://127.0.0.1:22222/events?event=trackItemChanged")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Streaming without event should fail", func() {
+			res, err := http.Get("http://127.0.0.1:22222/events?token=client-secret")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Streaming selected events", func() {
+			client := http.Client{Timeout: 3 * time.Second}
+			res, err := client.Get("http://127.0.0.1:22222/events?token=client-secret&event=trackItemChanged&ids=2")
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+			li := hub.sim.TrackItems["2"].(*simulation.LineItem)
+			li.SetFailed(true)
+			li.SetFailed(false)
+			reader := bufio.NewReader(res.Body)
+			name, data, err := readEvent(reader)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "trackItemChanged")
+			So(data, ShouldContainSubstring, `"msgType":"notification"`)
+			So(data, ShouldContainSubstring, `"id":"2"`)
+		})
+		Convey("Last events should be replayed on connect", func() {
+			li := hub.sim.TrackItems["2"].(*simulation.LineItem)
+			li.SetFailed(true)
+			li.SetFailed(false)
+			time.Sleep(100 * time.Millisecond)
+			client := http.Client{Timeout: 3 * time.Second}
+			res, err := client.Get("http://127.0.0.1:22222/events?token=client-secret&event=trackItemChanged&ids=2,3")
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+			name, data, err := readEvent(bufio.NewReader(res.Body))
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "trackItemChanged")
+			So(data, ShouldContainSubstring, `"failed":false`)
+		})
+	})
+}
@@ -27,9 +27,21 @@ import (
 
 // The Hub makes the interface between the Simulation and the websocket clients
 type Hub struct {
+	// name of the simulation instance served by this hub, empty for the default one
+	name string
+
+	// sim is the simulation served by this hub
+	sim *simulation.Simulation
+
+	// simMutex protects sim, which is replaced when another simulation is loaded
+	simMutex sync.RWMutex
+
 	// Registered client connections
 	clientConnections map[*connection]bool
 
+	// clientsMutex protects the clientConnections map
+	clientsMutex sync.RWMutex
+
 	// Registry of client listeners
 	registry map[registryEntry]map[*connection]bool
 
@@ -51,16 +63,29 @@ type Hub struct {
 	// Received requests channel
 	readChan chan *connection
 
-	objects map[string]hubObject
+	// Simulations to serve instead of the current one
+	loadChan chan *simulation.Simulation
 }
 
 type hubObject interface {
 	dispatch(h *Hub, req Request, c *connection)
 }
 
+var (
+	// hubObjects holds the objects that clients can query, by name. They are
+	// shared by all hubs.
+	hubObjects = make(map[string]hubObject)
+
+	// hubs holds the hubs of the named simulation instances
+	hubs = make(map[string]*Hub)
+
+	// hubsMutex protects the hubs map
+	hubsMutex sync.RWMutex
+)
+
 // run is the loop for handling dispatching requests and responses
 func (h *Hub) run(hubUp chan bool) {
-	logger.Info("Hub starting...", "submodule", "hub")
+	logger.Info("Hub starting...", "submodule", "hub", "instance", h.name)
 
 	hubUp <- true
 	var (
@@ -69,7 +94,7 @@ func (h *Hub) run(hubUp chan bool) {
 	)
 	for {
 		select {
-		case e = <-sim.EventChan:
+		case e = <-h.currentSim().EventChan:
 			logger.Debug("Received event from simulation", "submodule", "hub", "event", e.Name, "object", e.Object)
 			h.notifyClients(e)
 		case c = <-h.readChan:
@@ -81,18 +106,53 @@ func (h *Hub) run(hubUp chan bool) {
 		case c = <-h.unregisterChan:
 			logger.Info("Unregistering connection", "submodule", "hub", "connection", c.RemoteAddr())
 			h.unregister(c)
+		case s := <-h.loadChan:
+			logger.Info("Switching simulation", "submodule", "hub", "sim", s.Options.Title)
+			h.setSim(s)
+			h.lastEventsMutex.Lock()
+			h.lastEvents = make(map[registryEntry]*simulation.Event)
+			h.lastEventsMutex.Unlock()
 		}
 	}
 }
 
+// currentSim returns the simulation currently served by this hub.
+func (h *Hub) currentSim() *simulation.Simulation {
+	h.simMutex.RLock()
+	defer h.simMutex.RUnlock()
+	return h.sim
+}
+
+// setSim makes this hub serve the given simulation.
+func (h *Hub) setSim(s *simulation.Simulation) {
+	h.simMutex.Lock()
+	defer h.simMutex.Unlock()
+	h.sim = s
+}
+
 // register registers the given connection to this hub
 func (h *Hub) register(c *connection) {
 	switch c.clientType {
-	case Client:
+	case Client, Manager:
+		h.clientsMutex.Lock()
+		defer h.clientsMutex.Unlock()
 		h.clientConnections[c] = true
 	}
 }
 
+// clientNamed returns the registered client connection with the given name,
+// or nil if there is none.
+func (h *Hub) clientNamed(name string) *connection {
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+	for c := range h.clientConnections {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
 // addConnectionToRegistry adds this connection to the registry for eventName and id.
 func (h *Hub) addConnectionToRegistry(conn *connection, eventName simulation.EventName, id string) {
 	h.registryMutex.Lock()
@@ -126,11 +186,18 @@ func (h *Hub) removeConnectionFromRegistry(conn *connection) {
 // unregister unregisters the connection to this hub
 func (h *Hub) unregister(c *connection) {
 	switch c.clientType {
-	case Client:
+	case Client, Manager:
+		if c.unregisterManager != nil {
+			c.unregisterManager()
+		}
+		h.clientsMutex.Lock()
 		if _, ok := h.clientConnections[c]; ok {
 			delete(h.clientConnections, c)
 		}
+		h.clientsMutex.Unlock()
 		h.removeConnectionFromRegistry(c)
+		// Releasing areas sends events that are read by this hub's loop
+		go h.releaseAreas(c.name)
 	}
 }
 
@@ -154,6 +221,16 @@ func (h *Hub) notifyClients(e *simulation.Event) {
 	}
 }
 
+// broadcast sends the given event to all registered clients, whether they
+// listen to it or not.
+func (h *Hub) broadcast(e *simulation.Event) {
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+	for c := range h.clientConnections {
+		c.pushChan <- NewNotificationResponse(e)
+	}
+}
+
 // updateLastEvents updates the lastEvents map in a concurrently safe way
 func (h *Hub) updateLastEvents(e *simulation.Event) {
 	h.lastEventsMutex.Lock()
@@ -168,7 +245,7 @@ func (h *Hub) updateLastEvents(e *simulation.Event) {
 func (h *Hub) dispatchObject(conn *connection) {
 	req := conn.Requests[0]
 	conn.Requests = conn.Requests[1:]
-	obj, ok := h.objects[req.Object]
+	obj, ok := hubObjects[req.Object]
 	if !ok {
 		conn.pushChan <- NewErrorResponse(req.ID, fmt.Errorf("unknown object %s", req.Object))
 		logger.Debug("Request for unknown object received", "submodule", "hub", "object", req.Object)
@@ -177,9 +254,11 @@ func (h *Hub) dispatchObject(conn *connection) {
 	obj.dispatch(h, req, conn)
 }
 
-// newHub returns a pointer to a new Hub instance
-func newHub() *Hub {
+// newHub returns a pointer to a new Hub instance serving the simulation
+// instance with the given name.
+func newHub(name string) *Hub {
 	h := new(Hub)
+	h.name = name
 	// make connection maps
 	h.clientConnections = make(map[*connection]bool)
 	// make registry map
@@ -189,10 +268,33 @@ func newHub() *Hub {
 	h.registerChan = make(chan *connection)
 	h.unregisterChan = make(chan *connection)
 	h.readChan = make(chan *connection)
-	h.objects = make(map[string]hubObject)
+	h.loadChan = make(chan *simulation.Simulation)
 	return h
 }
 
+// hubNamed returns the hub of the simulation instance with the given name, or
+// nil if there is none. The default hub is returned for an empty name.
+func hubNamed(name string) *Hub {
+	if name == "" {
+		return hub
+	}
+	hubsMutex.RLock()
+	defer hubsMutex.RUnlock()
+	return hubs[name]
+}
+
+// allHubs returns the default hub and the hubs of all the named simulation
+// instances.
+func allHubs() []*Hub {
+	hubsMutex.RLock()
+	defer hubsMutex.RUnlock()
+	res := []*Hub{hub}
+	for _, h := range hubs {
+		res = append(res, h)
+	}
+	return res
+}
+
 func init() {
-	hub = newHub()
+	hub = newHub("")
 }
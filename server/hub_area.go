@@ -0,0 +1,143 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+type areaObject struct{}
+
+// dispatch processes requests made on the Area object
+func (a *areaObject) dispatch(h *Hub, req Request, conn *connection) {
+	ch := conn.pushChan
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for area list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		al, err := json.Marshal(h.currentSim().Areas)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, al)
+	case "show":
+		var idsParams = struct {
+			IDs []string `json:"ids"`
+		}{}
+		err := json.Unmarshal(req.Params, &idsParams)
+		logger.Debug("Request for area show received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idsParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		areas := make(map[string]*simulation.Area)
+		for _, id := range idsParams.IDs {
+			area, ok := h.currentSim().Areas[id]
+			if !ok {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown area: %s", id))
+				return
+			}
+			areas[id] = area
+		}
+		ad, err := json.Marshal(areas)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, ad)
+	case "take":
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug("Request for area take received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		area, ok := h.currentSim().Areas[idParams.ID]
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown area: %s", idParams.ID))
+			return
+		}
+		if err = conn.authorizeArea(area); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		area.SetController(conn.name)
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Area %s taken successfully", idParams.ID))
+	case "handOver":
+		var hoParams = struct {
+			ID     string `json:"id"`
+			Client string `json:"client"`
+		}{}
+		err := json.Unmarshal(req.Params, &hoParams)
+		logger.Debug("Request for area handOver received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", hoParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		area, ok := h.currentSim().Areas[hoParams.ID]
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown area: %s", hoParams.ID))
+			return
+		}
+		if conn.name == "" || area.Controller() != conn.name {
+			if err = conn.authorize(RoleSupervisor); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("permission denied: area %s is not under your control", hoParams.ID))
+				return
+			}
+		}
+		if hoParams.Client != "" {
+			target := h.clientNamed(hoParams.Client)
+			if target == nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown client: %s", hoParams.Client))
+				return
+			}
+			if err = target.authorize(RoleSignaller); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("client %s cannot control areas", hoParams.Client))
+				return
+			}
+		}
+		area.SetController(hoParams.Client)
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Area %s handed over successfully", hoParams.ID))
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+// releaseAreas releases all the areas controlled by the client with the
+// given name.
+func (h *Hub) releaseAreas(name string) {
+	for _, area := range h.currentSim().Areas {
+		if area.Controller() == name {
+			area.SetController("")
+		}
+	}
+}
+
+var _ hubObject = new(areaObject)
+
+func init() {
+	hubObjects["area"] = new(areaObject)
+}
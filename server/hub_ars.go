@@ -0,0 +1,135 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+type arsObject struct{}
+
+// dispatch processes requests made on the ars object
+func (a *arsObject) dispatch(h *Hub, req Request, conn *connection) {
+	ch := conn.pushChan
+	switch req.Action {
+	case "list":
+		logger.Debug("Request for ars list received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		status := make(map[string]bool)
+		for id, ti := range h.currentSim().TrackItems {
+			if si, ok := ti.(*simulation.SignalItem); ok {
+				status[id] = si.ARSEnabled()
+			}
+		}
+		data, err := json.Marshal(status)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, data)
+	case "enable", "disable":
+		var areaParams = struct {
+			IDs   []string `json:"ids"`
+			Place string   `json:"place"`
+		}{}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &areaParams); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+				return
+			}
+		}
+		logger.Debug(fmt.Sprintf("Request for ars %s received", req.Action), "submodule", "hub", "object", req.Object, "action", req.Action, "params", areaParams)
+		signals, err := h.arsSignals(areaParams.IDs, areaParams.Place)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		for _, si := range signals {
+			if err = conn.authorizeSignal(si); err != nil {
+				ch <- NewErrorResponse(req.ID, err)
+				return
+			}
+		}
+		for _, si := range signals {
+			if err = si.SetARSEnabled(req.Action == "enable"); err != nil {
+				ch <- NewErrorResponse(req.ID, fmt.Errorf("cannot %s ARS on signal %s: %s", req.Action, si.ID(), err))
+				return
+			}
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("ARS %sd successfully on %d signals", req.Action, len(signals)))
+	default:
+		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
+		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
+	}
+}
+
+// arsSignals returns the signals on which to enable or disable ARS.
+//
+// If ids is not empty, the signals with these ids are returned. Otherwise if
+// placeCode is not empty, the signals from which a route goes through this
+// place are returned. Otherwise, all the signals of the simulation are returned.
+func (h *Hub) arsSignals(ids []string, placeCode string) ([]*simulation.SignalItem, error) {
+	var signals []*simulation.SignalItem
+	switch {
+	case len(ids) > 0:
+		for _, id := range ids {
+			si, ok := h.currentSim().TrackItems[id].(*simulation.SignalItem)
+			if !ok {
+				return nil, fmt.Errorf("unknown signal: %s", id)
+			}
+			signals = append(signals, si)
+		}
+	case placeCode != "":
+		if _, ok := h.currentSim().Places[placeCode]; !ok {
+			return nil, fmt.Errorf("unknown place: %s", placeCode)
+		}
+		found := make(map[string]bool)
+		for _, r := range h.currentSim().Routes {
+			if found[r.BeginSignalId] {
+				continue
+			}
+			for _, pos := range r.Positions {
+				if pl := pos.TrackItem().Place(); pl != nil && pl.PlaceCode == placeCode {
+					found[r.BeginSignalId] = true
+					signals = append(signals, r.BeginSignal())
+					break
+				}
+			}
+		}
+	default:
+		for _, ti := range h.currentSim().TrackItems {
+			if si, ok := ti.(*simulation.SignalItem); ok {
+				signals = append(signals, si)
+			}
+		}
+	}
+	sort.Slice(signals, func(i, j int) bool {
+		return signals[i].ID() < signals[j].ID()
+	})
+	return signals, nil
+}
+
+var _ hubObject = new(arsObject)
+
+func init() {
+	hubObjects["ars"] = new(arsObject)
+}
@@ -31,13 +31,17 @@ func (s *optionObject) dispatch(h *Hub, req Request, conn *connection) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for option list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		opts, err := json.Marshal(sim.Options)
+		opts, err := json.Marshal(h.currentSim().Options)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
 		ch <- NewResponse(req.ID, opts)
 	case "set":
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		var setParams = struct {
 			Name  string      `json:"name"`
 			Value interface{} `json:"value"`
@@ -48,7 +52,7 @@ func (s *optionObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("error on parameters: %s", err))
 			return
 		}
-		err = sim.Options.Set(setParams.Name, setParams.Value)
+		err = h.currentSim().Options.Set(setParams.Name, setParams.Value)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("error while setting option: %s", err))
 			return
@@ -63,5 +67,5 @@ func (s *optionObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(optionObject)
 
 func init() {
-	hub.objects["option"] = new(optionObject)
+	hubObjects["option"] = new(optionObject)
 }
@@ -33,7 +33,7 @@ func (s *placeObject) dispatch(h *Hub, req Request, conn *connection) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for place list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		til, err := json.Marshal(sim.Places)
+		til, err := json.Marshal(h.currentSim().Places)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +51,7 @@ func (s *placeObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		tkis := make(map[string]*simulation.Place)
 		for _, id := range idsParams.IDs {
-			tsID, ok := sim.Places[id]
+			tsID, ok := h.currentSim().Places[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown place: %s", id))
 				return
@@ -73,5 +73,5 @@ func (s *placeObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(placeObject)
 
 func init() {
-	hub.objects["place"] = new(placeObject)
+	hubObjects["place"] = new(placeObject)
 }
@@ -33,7 +33,7 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for route list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		rtes, err := json.Marshal(sim.Routes)
+		rtes, err := json.Marshal(h.currentSim().Routes)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +51,7 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		rtes := make(map[string]*simulation.Route)
 		for _, id := range idsParams.IDs {
-			rte, ok := sim.Routes[id]
+			rte, ok := h.currentSim().Routes[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown route: %s", id))
 				return
@@ -75,11 +75,15 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		rte, ok := sim.Routes[actParams.ID]
+		rte, ok := h.currentSim().Routes[actParams.ID]
 		if !ok {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown route: %s", actParams.ID))
 			return
 		}
+		if err = conn.authorizeRoute(rte); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		err = rte.Activate(actParams.Persistent)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("cannot activate route %s: %s", actParams.ID, err))
@@ -96,11 +100,15 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		rte, ok := sim.Routes[idParams.ID]
+		rte, ok := h.currentSim().Routes[idParams.ID]
 		if !ok {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown route: %s", idParams.ID))
 			return
 		}
+		if err = conn.authorizeRoute(rte); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		err = rte.Deactivate()
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("cannot deactivate route %s: %s", idParams.ID, err))
@@ -116,5 +124,5 @@ func (r *routeObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(routeObject)
 
 func init() {
-	hub.objects["route"] = new(routeObject)
+	hubObjects["route"] = new(routeObject)
 }
@@ -21,6 +21,8 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/ts2/ts2-sim-server/simulation"
 )
 
 type serverObject struct{}
@@ -53,6 +55,55 @@ func (s *serverObject) dispatch(h *Hub, req Request, conn *connection) {
 			return
 		}
 		ch <- NewOkResponse(req.ID, "Renotify request taken into account")
+	case "listInstances":
+		logger.Debug("Request for listInstances received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		data, err := json.Marshal(listInstances())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, data)
+	case "listConditionTypes":
+		logger.Debug("Request for listConditionTypes received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		data, err := json.Marshal(simulation.ConditionTypes())
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, data)
+	case "listSimulations":
+		logger.Debug("Request for listSimulations received", "submodule", "hub", "object", req.Object, "action", req.Action)
+		sims, err := listSimulations()
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		data, err := json.Marshal(sims)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ch <- NewResponse(req.ID, data)
+	case "loadSimulation":
+		logger.Debug("Request for loadSimulation received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", req.Params)
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		var params = struct {
+			File string `json:"file"`
+		}{}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		s, err := loadSimulation(params.File)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to load simulation %s: %s", params.File, err))
+			return
+		}
+		h.switchSimulation(s, params.File)
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Simulation %s loaded successfully", params.File))
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action, "params", req.Params)
@@ -123,5 +174,5 @@ func (h *Hub) renotifyClient(req Request, conn *connection) error {
 var _ hubObject = new(serverObject)
 
 func init() {
-	hub.objects["server"] = new(serverObject)
+	hubObjects["server"] = new(serverObject)
 }
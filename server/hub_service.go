@@ -33,7 +33,7 @@ func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for service list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		sl, err := json.Marshal(sim.Services)
+		sl, err := json.Marshal(h.currentSim().Services)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +51,7 @@ func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		sl := make(map[string]*simulation.Service)
 		for _, id := range idsParams.IDs {
-			sld, ok := sim.Services[id]
+			sld, ok := h.currentSim().Services[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown service: %s", id))
 				return
@@ -73,5 +73,5 @@ func (s *serviceObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(serviceObject)
 
 func init() {
-	hub.objects["service"] = new(serviceObject)
+	hubObjects["service"] = new(serviceObject)
 }
@@ -21,8 +21,32 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 )
 
+// SnapshotsDir is the directory in which simulations are saved with the
+// simulation/save action. If it is empty, simulations cannot be saved by
+// clients.
+var SnapshotsDir string
+
+// snapshotPath returns the path of the given snapshot file of SnapshotsDir.
+//
+// Only base file names are accepted so that clients cannot write outside of
+// SnapshotsDir.
+func snapshotPath(fileName string) (string, error) {
+	if SnapshotsDir == "" {
+		return "", fmt.Errorf("no snapshots directory")
+	}
+	if fileName == "" {
+		return "", fmt.Errorf("no file given to save the simulation to")
+	}
+	if fileName == "." || fileName == ".." || fileName != filepath.Base(fileName) {
+		return "", fmt.Errorf("invalid snapshot file: %s", fileName)
+	}
+	return filepath.Join(SnapshotsDir, fileName), nil
+}
+
 type simulationObject struct{}
 
 // dispatch processes requests made on the Simulation object
@@ -31,25 +55,60 @@ func (s *simulationObject) dispatch(h *Hub, req Request, conn *connection) {
 	logger.Debug("Request for simulation received", "submodule", "hub", "object", req.Object, "action", req.Action)
 	switch req.Action {
 	case "start":
-		sim.Start()
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		h.currentSim().Start()
 		ch <- NewOkResponse(req.ID, "Simulation started successfully")
 	case "pause":
-		sim.Pause()
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		h.currentSim().Pause()
 		ch <- NewOkResponse(req.ID, "Simulation paused successfully")
 	case "isStarted":
-		j, err := json.Marshal(sim.IsStarted())
+		j, err := json.Marshal(h.currentSim().IsStarted())
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
 		ch <- NewResponse(req.ID, RawJSON(j))
 	case "dump":
-		data, err := json.Marshal(sim)
+		data, err := json.Marshal(h.currentSim())
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
 		ch <- NewResponse(req.ID, data)
+	case "save":
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		var params = struct {
+			File string `json:"file"`
+		}{}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		file, err := snapshotPath(params.File)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		data, err := h.currentSim().Snapshot()
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to save simulation: %s", err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "Simulation saved successfully")
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
@@ -59,5 +118,5 @@ func (s *simulationObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(simulationObject)
 
 func init() {
-	hub.objects["simulation"] = new(simulationObject)
+	hubObjects["simulation"] = new(simulationObject)
 }
@@ -20,11 +20,16 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
 	. "github.com/smartystreets/goconvey/convey"
+	_ "github.com/ts2/ts2-sim-server/plugins/ars"
 	_ "github.com/ts2/ts2-sim-server/plugins/lines"
 	_ "github.com/ts2/ts2-sim-server/plugins/points"
 	_ "github.com/ts2/ts2-sim-server/plugins/routes"
@@ -77,6 +82,19 @@ func TestHub(t *testing.T) {
 			So(resp.Data.Status, ShouldEqual, Fail)
 			So(resp.Data.Message, ShouldEqual, "Error: unknown object undefined")
 		})
+		Convey("Listing condition types", func() {
+			err = c.WriteJSON(Request{Object: "server", Action: "listConditionTypes"})
+			So(err, ShouldBeNil)
+			var resp Response
+			err = c.ReadJSON(&resp)
+			So(err, ShouldBeNil)
+			var cts []simulation.ConditionTypeInfo
+			err := json.Unmarshal(resp.Data, &cts)
+			So(err, ShouldBeNil)
+			So(len(cts), ShouldBeGreaterThanOrEqualTo, 10)
+			So(cts[0].Code, ShouldEqual, "NEXT_ROUTE_ACTIVE")
+			So(cts[0].Description, ShouldEqual, "Met if a route is set starting from this signal.")
+		})
 		Convey("Option functions", func() {
 			Convey("Calling unknown action should fail", func() {
 				err = c.WriteJSON(Request{Object: "option", Action: "undefined"})
@@ -106,13 +124,13 @@ func TestHub(t *testing.T) {
 				resp := sendRequestStatus(c, "option", "set", `{"name": "Title", "value": "New Title"}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Ok)
-				So(sim.Options.Title, ShouldEqual, "New Title")
+				So(hub.sim.Options.Title, ShouldEqual, "New Title")
 			})
 			Convey("Setting an option by its json name", func() {
 				resp := sendRequestStatus(c, "option", "set", `{"name": "title", "value": "New Title again"}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Ok)
-				So(sim.Options.Title, ShouldEqual, "New Title again")
+				So(hub.sim.Options.Title, ShouldEqual, "New Title again")
 			})
 			Convey("Setting an option with invalid params should fail", func() {
 				resp := sendRequestStatus(c, "option", "set", `{"name": [], "value": "Another Title"}`)
@@ -213,6 +231,60 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Message, ShouldEqual, "Error: cannot activate route 2: Standard Manager vetoed route activation: conflicting route 1 is active")
 			})
 		})
+		Convey("ARS functions", func() {
+			Convey("Calling unknown action should fail", func() {
+				resp := sendRequestStatus(c, "ars", "undefined", "")
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown action ars/undefined")
+			})
+			Convey("Listing ARS status", func() {
+				err = c.WriteJSON(Request{Object: "ars", Action: "list"})
+				So(err, ShouldBeNil)
+				var resp Response
+				err = c.ReadJSON(&resp)
+				So(err, ShouldBeNil)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				var status map[string]bool
+				err = json.Unmarshal(resp.Data, &status)
+				So(err, ShouldBeNil)
+				So(status, ShouldHaveLength, 7)
+				for _, enabled := range status {
+					So(enabled, ShouldBeFalse)
+				}
+			})
+			Convey("Enabling ARS on signals", func() {
+				resp := sendRequestStatus(c, "ars", "enable", `{"ids": ["5", "9"]}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "ARS enabled successfully on 2 signals")
+				So(hub.sim.TrackItems["5"].(*simulation.SignalItem).ARSEnabled(), ShouldBeTrue)
+				So(hub.sim.TrackItems["9"].(*simulation.SignalItem).ARSEnabled(), ShouldBeTrue)
+				So(hub.sim.TrackItems["15"].(*simulation.SignalItem).ARSEnabled(), ShouldBeFalse)
+			})
+			Convey("Disabling ARS on an area", func() {
+				resp := sendRequestStatus(c, "ars", "disable", `{"place": "STN"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(hub.sim.TrackItems["5"].(*simulation.SignalItem).ARSEnabled(), ShouldBeFalse)
+				So(hub.sim.TrackItems["9"].(*simulation.SignalItem).ARSEnabled(), ShouldBeTrue)
+			})
+			Convey("Enabling ARS on an unknown signal should fail", func() {
+				resp := sendRequestStatus(c, "ars", "enable", `{"ids": ["5", "10"]}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown signal: 10")
+			})
+			Convey("Enabling ARS on an unknown place should fail", func() {
+				resp := sendRequestStatus(c, "ars", "enable", `{"place": "XXX"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown place: XXX")
+			})
+			Convey("Enabling and disabling ARS on all signals", func() {
+				resp := sendRequestStatus(c, "ars", "enable", "")
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "ARS enabled successfully on 7 signals")
+				resp = sendRequestStatus(c, "ars", "disable", "")
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "ARS disabled successfully on 7 signals")
+			})
+		})
 		Convey("Trains functions", func() {
 			Convey("Calling unknown action should fail", func() {
 				err = c.WriteJSON(Request{Object: "train", Action: "undefined"})
@@ -272,9 +344,9 @@ func TestHub(t *testing.T) {
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Fail)
 				So(resp.Data.Message, ShouldEqual, "Error: unable to reverse train 0: train is not stopped")
-				pos := simulation.NewPosition(sim, "2", "1", 20)
-				sim.Trains[0].TrainHead = pos
-				sim.Trains[0].Speed = 0
+				pos := simulation.NewPosition(hub.sim, "2", "1", 20)
+				hub.sim.Trains[0].TrainHead = pos
+				hub.sim.Trains[0].Speed = 0
 				resp = sendRequestStatus(c, "train", "reverse", `{"id": 0}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Ok)
@@ -289,12 +361,12 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Message, ShouldEqual, "Error: unknown train: 999")
 			})
 			Convey("Setting a service to a train", func() {
-				So(sim.Trains[0].ServiceCode, ShouldEqual, "S001")
+				So(hub.sim.Trains[0].ServiceCode, ShouldEqual, "S001")
 				resp := sendRequestStatus(c, "train", "setService", `{"id": 0, "service": "S002"}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Ok)
 				So(resp.Data.Message, ShouldEqual, "service assigned successfully")
-				So(sim.Trains[0].ServiceCode, ShouldEqual, "S002")
+				So(hub.sim.Trains[0].ServiceCode, ShouldEqual, "S002")
 			})
 			Convey("SetService with a wrong train ID or ServiceID should fail", func() {
 				resp := sendRequestStatus(c, "train", "setService", `{"id": 999, "service": "S002"}`)
@@ -306,13 +378,32 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Status, ShouldEqual, Fail)
 				So(resp.Data.Message, ShouldEqual, "Error: unable to assign service S042 to train 0: unknown service: S042")
 			})
+			Convey("Setting the trains manager of a train", func() {
+				resp := sendRequestStatus(c, "train", "setManager", `{"id": 1, "manager": "cautious"}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "trains manager set successfully")
+				So(hub.sim.Trains[1].TrainsManager, ShouldEqual, "cautious")
+				resp = sendRequestStatus(c, "train", "setManager", `{"id": 1, "manager": "standard"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(hub.sim.Trains[1].TrainsManager, ShouldEqual, "standard")
+			})
+			Convey("Setting a wrong trains manager should fail", func() {
+				resp := sendRequestStatus(c, "train", "setManager", `{"id": 999, "manager": "cautious"}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown train: 999")
+				resp = sendRequestStatus(c, "train", "setManager", `{"id": 1, "manager": "Reckless Driver"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unable to set manager of train 1: unknown trains manager: Reckless Driver")
+			})
 			Convey("Resetting a service", func() {
-				sim.Trains[0].NextPlaceIndex = 1
+				hub.sim.Trains[0].NextPlaceIndex = 1
 				resp := sendRequestStatus(c, "train", "resetService", `{"id": 0}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Ok)
 				So(resp.Data.Message, ShouldEqual, "service reset successfully")
-				So(sim.Trains[0].NextPlaceIndex, ShouldEqual, 0)
+				So(hub.sim.Trains[0].NextPlaceIndex, ShouldEqual, 0)
 			})
 			Convey("Resetting a service with a wrong train ID should fail", func() {
 				resp := sendRequestStatus(c, "train", "resetService", `{"id": 999}`)
@@ -321,14 +412,14 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Message, ShouldEqual, "Error: unknown train: 999")
 			})
 			Convey("Ask a train to proceed", func() {
-				So(sim.Trains[0].ApplicableAction().Speed, ShouldEqual, simulation.VeryHighSpeed)
-				So(sim.Trains[0].ApplicableAction().Target, ShouldEqual, simulation.ASAP)
+				So(hub.sim.Trains[0].ApplicableAction().Speed, ShouldEqual, simulation.VeryHighSpeed)
+				So(hub.sim.Trains[0].ApplicableAction().Target, ShouldEqual, simulation.ASAP)
 				resp := sendRequestStatus(c, "train", "proceed", `{"id": 0}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Ok)
 				So(resp.Data.Message, ShouldEqual, "proceed order passed successfully")
-				So(sim.Trains[0].ApplicableAction().Speed, ShouldEqual, 8.34)
-				So(sim.Trains[0].ApplicableAction().Target, ShouldEqual, simulation.ASAP)
+				So(hub.sim.Trains[0].ApplicableAction().Speed, ShouldEqual, 8.34)
+				So(hub.sim.Trains[0].ApplicableAction().Target, ShouldEqual, simulation.ASAP)
 			})
 			Convey("Asking a wrong train ID to proceed should fail", func() {
 				resp := sendRequestStatus(c, "train", "proceed", `{"id": 999}`)
@@ -337,12 +428,36 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Message, ShouldEqual, "Error: unknown train: 999")
 			})
 			Convey("Asking a running train ID to proceed should fail", func() {
-				sim.Trains[0].Speed = 5
+				hub.sim.Trains[0].Speed = 5
 				resp := sendRequestStatus(c, "train", "proceed", `{"id": 0}`)
 				So(resp.MsgType, ShouldEqual, TypeResponse)
 				So(resp.Data.Status, ShouldEqual, Fail)
 				So(resp.Data.Message, ShouldEqual, "Error: unable to proceed for train 0: train is not stopped")
 			})
+			Convey("Splitting a train that cannot be split should fail", func() {
+				resp := sendRequestStatus(c, "train", "split", `{"id": 0, "element": 1}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unable to split train 0: train type UT cannot be split")
+				resp = sendRequestStatus(c, "train", "split", `{"id": 999, "element": 1}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown train: 999")
+			})
+			Convey("Joining a train with no train around should fail", func() {
+				hub.sim.Trains[1].Speed = 0
+				status := hub.sim.Trains[1].Status
+				hub.sim.Trains[1].Status = simulation.Stopped
+				defer func() { hub.sim.Trains[1].Status = status }()
+				resp := sendRequestStatus(c, "train", "join", `{"id": 1, "ahead": true}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unable to join train 1: no train to join with")
+			})
+			Convey("Joining a train which is not on the scenery should fail", func() {
+				resp := sendRequestStatus(c, "train", "join", `{"id": 1, "ahead": true}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unable to join train 1: train is not on the scenery")
+			})
 		})
 		Convey("TrackItems functions", func() {
 			Convey("Calling unknown action should fail", func() {
@@ -389,6 +504,40 @@ func TestHub(t *testing.T) {
 				So(resp.Data.Status, ShouldEqual, Fail)
 				So(resp.Data.Message, ShouldEqual, "Error: unknown trackItem: 999")
 			})
+			Convey("Failing a track circuit", func() {
+				resp := sendRequestStatus(c, "trackItem", "fail", `{"id": "2"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "Track circuit of 2 failed successfully")
+				So(hub.sim.TrackItems["2"].TrainPresent(), ShouldBeTrue)
+			})
+			Convey("Repairing a track circuit", func() {
+				resp := sendRequestStatus(c, "trackItem", "repair", `{"id": "2"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "Track circuit of 2 repaired successfully")
+				So(hub.sim.TrackItems["2"].(*simulation.LineItem).IsFailed(), ShouldBeFalse)
+			})
+			Convey("Failing a signal", func() {
+				resp := sendRequestStatus(c, "trackItem", "fail", `{"id": "11"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "Signal 11 failed successfully")
+				So(hub.sim.TrackItems["11"].(*simulation.SignalItem).IsFailed(), ShouldBeTrue)
+			})
+			Convey("Repairing a signal", func() {
+				resp := sendRequestStatus(c, "trackItem", "repair", `{"id": "11"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				So(resp.Data.Message, ShouldEqual, "Signal 11 repaired successfully")
+				So(hub.sim.TrackItems["11"].(*simulation.SignalItem).IsFailed(), ShouldBeFalse)
+			})
+			Convey("Failing an item that cannot fail should fail", func() {
+				resp := sendRequestStatus(c, "trackItem", "fail", `{"id": "7"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: trackItem 7 cannot fail")
+			})
+			Convey("Failing an unknown trackItem should fail", func() {
+				resp := sendRequestStatus(c, "trackItem", "fail", `{"id": "999"}`)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: unknown trackItem: 999")
+			})
 		})
 		Convey("Places functions", func() {
 			Convey("Calling unknown action should fail", func() {
@@ -553,6 +702,56 @@ func TestHub(t *testing.T) {
 				So(simu.Places, ShouldHaveLength, 3)
 				So(simu.Places, ShouldContainKey, "STN")
 			})
+			Convey("Saving simulation", func() {
+				SnapshotsDir = os.TempDir()
+				defer func() { SnapshotsDir = "" }()
+				file := filepath.Join(os.TempDir(), "ts2-hub-test-snapshot.json")
+				defer os.Remove(file)
+				resp := sendRequestStatus(c, "simulation", "save", `{"file": "ts2-hub-test-snapshot.json"}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				data, err := ioutil.ReadFile(file)
+				So(err, ShouldBeNil)
+				var simu simulation.Simulation
+				err = json.Unmarshal(data, &simu)
+				So(err, ShouldBeNil)
+				So(simu.HasSnapshot(), ShouldBeTrue)
+				So(simu.Trains, ShouldHaveLength, len(hub.sim.Trains))
+			})
+			Convey("Saving simulation to a file name with dots should work", func() {
+				SnapshotsDir = os.TempDir()
+				defer func() { SnapshotsDir = "" }()
+				file := filepath.Join(os.TempDir(), "ts2-hub-test..snapshot.json")
+				defer os.Remove(file)
+				resp := sendRequestStatus(c, "simulation", "save", `{"file": "ts2-hub-test..snapshot.json"}`)
+				So(resp.Data.Status, ShouldEqual, Ok)
+				_, err := os.Stat(file)
+				So(err, ShouldBeNil)
+			})
+			Convey("Saving simulation without file should fail", func() {
+				SnapshotsDir = os.TempDir()
+				defer func() { SnapshotsDir = "" }()
+				resp := sendRequestStatus(c, "simulation", "save", `{}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: no file given to save the simulation to")
+			})
+			Convey("Saving simulation outside of the snapshots directory should fail", func() {
+				SnapshotsDir = os.TempDir()
+				defer func() { SnapshotsDir = "" }()
+				for _, file := range []string{"../snapshot.json", "/tmp/snapshot.json", "dir/snapshot.json", ".", ".."} {
+					resp := sendRequestStatus(c, "simulation", "save", fmt.Sprintf(`{"file": %q}`, file))
+					So(resp.MsgType, ShouldEqual, TypeResponse)
+					So(resp.Data.Status, ShouldEqual, Fail)
+					So(resp.Data.Message, ShouldEqual, fmt.Sprintf("Error: invalid snapshot file: %s", file))
+				}
+			})
+			Convey("Saving simulation without snapshots directory should fail", func() {
+				resp := sendRequestStatus(c, "simulation", "save", `{"file": "snapshot.json"}`)
+				So(resp.MsgType, ShouldEqual, TypeResponse)
+				So(resp.Data.Status, ShouldEqual, Fail)
+				So(resp.Data.Message, ShouldEqual, "Error: no snapshots directory")
+			})
 			Convey("Starting simulation", func() {
 				resp := sendRequestStatus(c, "simulation", "start", "")
 				So(resp.MsgType, ShouldEqual, TypeResponse)
@@ -747,3 +946,81 @@ func TestHub(t *testing.T) {
 		})
 	})
 }
+
+func TestLoadSimulation(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+	Convey("Testing loading simulations at runtime", t, func() {
+		c := clientDial(t)
+		err := register(t, c, Client, "", "client-secret")
+		So(err, ShouldBeNil)
+		Convey("Without simulations directory, no simulation should be listed", func() {
+			resp := sendRequestStatus(c, "server", "listSimulations", "")
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: no simulations directory")
+		})
+		Convey("Simulations of the directory should be listed", func() {
+			SimulationsDir = "../simulation/testdata"
+			err := c.WriteJSON(Request{Object: "server", Action: "listSimulations"})
+			So(err, ShouldBeNil)
+			var resp Response
+			err = c.ReadJSON(&resp)
+			So(err, ShouldBeNil)
+			var sims []SimulationFile
+			err = json.Unmarshal(resp.Data, &sims)
+			So(err, ShouldBeNil)
+			So(sims, ShouldHaveLength, 3)
+			So(sims[2].File, ShouldEqual, "demo.json")
+			So(sims[2].Title, ShouldEqual, "TS2 - Demo & Test Sim")
+		})
+		Convey("Files outside the simulations directory should not be loaded", func() {
+			SimulationsDir = "../simulation/testdata"
+			resp := sendRequestStatus(c, "server", "loadSimulation", `{"file": "../testdata/demo.json"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: unable to load simulation ../testdata/demo.json: invalid simulation file: ../testdata/demo.json")
+		})
+		Convey("Invalid simulations should not be loaded", func() {
+			SimulationsDir = "../simulation/testdata"
+			resp := sendRequestStatus(c, "server", "loadSimulation", `{"file": "badroutes.json"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldStartWith, "Error: unable to load simulation badroutes.json: ")
+		})
+		Convey("Loading a simulation should switch the simulation and notify clients", func() {
+			SimulationsDir = "../simulation/testdata"
+			Seed = 42
+			before := hub.currentSim()
+			resp := sendRequestStatus(c, "option", "set", `{"name": "description", "value": "Before loading"}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			err := c.WriteJSON(Request{Object: "server", Action: "loadSimulation", Params: RawJSON(`{"file": "demo.json"}`)})
+			So(err, ShouldBeNil)
+			var event ResponseNotification
+			err = c.ReadJSON(&event)
+			So(err, ShouldBeNil)
+			So(event.MsgType, ShouldEqual, TypeNotification)
+			So(event.Data.Name, ShouldEqual, SimulationLoadedEvent)
+			err = c.ReadJSON(&resp)
+			So(err, ShouldBeNil)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(resp.Data.Message, ShouldEqual, "Simulation demo.json loaded successfully")
+
+			err = c.WriteJSON(Request{Object: "option", Action: "list"})
+			So(err, ShouldBeNil)
+			var optsResp Response
+			err = c.ReadJSON(&optsResp)
+			So(err, ShouldBeNil)
+			var opts map[string]interface{}
+			err = json.Unmarshal(optsResp.Data, &opts)
+			So(err, ShouldBeNil)
+			So(opts["description"], ShouldEqual, "This is a developers test/demo simulation!")
+			So(hub.currentSim(), ShouldNotEqual, before)
+			So(hub.currentSim().Options.Seed, ShouldEqual, 42)
+			So(Simulations()[""], ShouldEqual, hub.currentSim())
+		})
+		Reset(func() {
+			SimulationsDir = ""
+			Seed = 0
+			err := c.Close()
+			So(err, ShouldBeNil)
+		})
+	})
+}
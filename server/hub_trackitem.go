@@ -33,7 +33,7 @@ func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for trackitem list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		til, err := json.Marshal(sim.TrackItems)
+		til, err := json.Marshal(h.currentSim().TrackItems)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +51,7 @@ func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		tkis := make(map[string]simulation.TrackItem)
 		for _, id := range idsParams.IDs {
-			tsID, ok := sim.TrackItems[id]
+			tsID, ok := h.currentSim().TrackItems[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown trackItem: %s", id))
 				return
@@ -64,14 +64,60 @@ func (s *trackItemObject) dispatch(h *Hub, req Request, conn *connection) {
 			return
 		}
 		ch <- NewResponse(req.ID, tid)
+	case "fail", "repair":
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		var idParams = struct {
+			ID string `json:"id"`
+		}{}
+		err := json.Unmarshal(req.Params, &idParams)
+		logger.Debug(fmt.Sprintf("Request for trackItem %s received", req.Action), "submodule", "hub", "object", req.Object, "action", req.Action, "params", idParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		ti, ok := h.currentSim().TrackItems[idParams.ID]
+		if !ok {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown trackItem: %s", idParams.ID))
+			return
+		}
+		failed := req.Action == "fail"
+		var li *simulation.LineItem
+		switch item := ti.(type) {
+		case *simulation.LineItem:
+			li = item
+		case *simulation.PlatformItem:
+			li = &item.LineItem
+		case *simulation.InvisibleLinkItem:
+			li = &item.LineItem
+		case *simulation.SignalItem:
+			item.SetFailed(failed)
+			ch <- NewOkResponse(req.ID, fmt.Sprintf("Signal %s %s successfully", idParams.ID, pastTense(req.Action)))
+			return
+		default:
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("trackItem %s cannot fail", idParams.ID))
+			return
+		}
+		li.SetFailed(failed)
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("Track circuit of %s %s successfully", idParams.ID, pastTense(req.Action)))
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
 	}
 }
 
+// pastTense returns the past tense of the fail and repair actions.
+func pastTense(action string) string {
+	if action == "fail" {
+		return "failed"
+	}
+	return "repaired"
+}
+
 var _ hubObject = new(trackItemObject)
 
 func init() {
-	hub.objects["trackItem"] = new(trackItemObject)
+	hubObjects["trackItem"] = new(trackItemObject)
 }
@@ -33,7 +33,7 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 	ch := conn.pushChan
 	switch req.Action {
 	case "list":
-		sl, err := json.Marshal(sim.Trains)
+		sl, err := json.Marshal(h.currentSim().Trains)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -50,11 +50,11 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		ts := make([]*simulation.Train, len(idsParams.IDs))
 		for i, id := range idsParams.IDs {
-			if id < 0 || id >= len(sim.Trains) {
+			if id < 0 || id >= len(h.currentSim().Trains) {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", id))
 				return
 			}
-			ts[i] = sim.Trains[id]
+			ts[i] = h.currentSim().Trains[id]
 		}
 		tid, err := json.Marshal(ts)
 		if err != nil {
@@ -63,6 +63,10 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		ch <- NewResponse(req.ID, tid)
 	case "reverse":
+		if err := conn.authorize(RoleSignaller); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		var idParams = struct {
 			ID int `json:"id"`
 		}{}
@@ -71,17 +75,25 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if idParams.ID < 0 || idParams.ID >= len(sim.Trains) {
+		if idParams.ID < 0 || idParams.ID >= len(h.currentSim().Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
 			return
 		}
-		train := sim.Trains[idParams.ID]
+		train := h.currentSim().Trains[idParams.ID]
+		if err = conn.authorizeTrain(train); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		if err = train.Reverse(); err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to reverse train %d: %s", idParams.ID, err))
 			return
 		}
 		ch <- NewOkResponse(req.ID, "train reversed successfully")
 	case "setService":
+		if err := conn.authorize(RoleSignaller); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		var smParams = struct {
 			ID      int    `json:"id"`
 			Service string `json:"service"`
@@ -91,16 +103,48 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if smParams.ID < 0 || smParams.ID >= len(sim.Trains) {
+		if smParams.ID < 0 || smParams.ID >= len(h.currentSim().Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", smParams.ID))
 			return
 		}
-		if err = sim.Trains[smParams.ID].AssignService(smParams.Service); err != nil {
+		train := h.currentSim().Trains[smParams.ID]
+		if err = conn.authorizeTrain(train); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		if err = train.AssignService(smParams.Service); err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to assign service %s to train %d: %s", smParams.Service, smParams.ID, err))
 			return
 		}
 		ch <- NewOkResponse(req.ID, "service assigned successfully")
+	case "setManager":
+		if err := conn.authorize(RoleSupervisor); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		var smParams = struct {
+			ID      int    `json:"id"`
+			Manager string `json:"manager"`
+		}{}
+		err := json.Unmarshal(req.Params, &smParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if smParams.ID < 0 || smParams.ID >= len(h.currentSim().Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", smParams.ID))
+			return
+		}
+		if err = h.currentSim().Trains[smParams.ID].SetTrainsManager(smParams.Manager); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to set manager of train %d: %s", smParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "trains manager set successfully")
 	case "resetService":
+		if err := conn.authorize(RoleSignaller); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		var idParams = struct {
 			ID int `json:"id"`
 		}{}
@@ -109,14 +153,22 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if idParams.ID < 0 || idParams.ID >= len(sim.Trains) {
+		if idParams.ID < 0 || idParams.ID >= len(h.currentSim().Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
 			return
 		}
-		train := sim.Trains[idParams.ID]
+		train := h.currentSim().Trains[idParams.ID]
+		if err = conn.authorizeTrain(train); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		_ = train.ResetService()
 		ch <- NewOkResponse(req.ID, "service reset successfully")
 	case "proceed":
+		if err := conn.authorize(RoleSignaller); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		var idParams = struct {
 			ID int `json:"id"`
 		}{}
@@ -125,16 +177,78 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
 		}
-		if idParams.ID < 0 || idParams.ID >= len(sim.Trains) {
+		if idParams.ID < 0 || idParams.ID >= len(h.currentSim().Trains) {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", idParams.ID))
 			return
 		}
-		train := sim.Trains[idParams.ID]
+		train := h.currentSim().Trains[idParams.ID]
+		if err = conn.authorizeTrain(train); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
 		if err = train.ProceedWithCaution(); err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to proceed for train %d: %s", idParams.ID, err))
 			return
 		}
 		ch <- NewOkResponse(req.ID, "proceed order passed successfully")
+	case "split":
+		if err := conn.authorize(RoleSignaller); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		var splitParams = struct {
+			ID      int    `json:"id"`
+			Element int    `json:"element"`
+			Service string `json:"service"`
+		}{}
+		err := json.Unmarshal(req.Params, &splitParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if splitParams.ID < 0 || splitParams.ID >= len(h.currentSim().Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", splitParams.ID))
+			return
+		}
+		train := h.currentSim().Trains[splitParams.ID]
+		if err = conn.authorizeTrain(train); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		newTrain, err := train.Split(splitParams.Element, splitParams.Service)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to split train %d: %s", splitParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, fmt.Sprintf("train split successfully, new train is %s", newTrain.ID()))
+	case "join":
+		if err := conn.authorize(RoleSignaller); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		var joinParams = struct {
+			ID    int  `json:"id"`
+			Ahead bool `json:"ahead"`
+		}{}
+		err := json.Unmarshal(req.Params, &joinParams)
+		if err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
+			return
+		}
+		if joinParams.ID < 0 || joinParams.ID >= len(h.currentSim().Trains) {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown train: %d", joinParams.ID))
+			return
+		}
+		train := h.currentSim().Trains[joinParams.ID]
+		if err = conn.authorizeTrain(train); err != nil {
+			ch <- NewErrorResponse(req.ID, err)
+			return
+		}
+		if err = train.Join(joinParams.Ahead); err != nil {
+			ch <- NewErrorResponse(req.ID, fmt.Errorf("unable to join train %d: %s", joinParams.ID, err))
+			return
+		}
+		ch <- NewOkResponse(req.ID, "trains joined successfully")
 	default:
 		ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown action %s/%s", req.Object, req.Action))
 		logger.Debug("Request for unknown action received", "submodule", "hub", "object", req.Object, "action", req.Action)
@@ -144,5 +258,5 @@ func (t *trainObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(trainObject)
 
 func init() {
-	hub.objects["train"] = new(trainObject)
+	hubObjects["train"] = new(trainObject)
 }
@@ -33,7 +33,7 @@ func (s *trainTypeObject) dispatch(h *Hub, req Request, conn *connection) {
 	switch req.Action {
 	case "list":
 		logger.Debug("Request for trainType list received", "submodule", "hub", "object", req.Object, "action", req.Action)
-		tts, err := json.Marshal(sim.TrainTypes)
+		tts, err := json.Marshal(h.currentSim().TrainTypes)
 		if err != nil {
 			ch <- NewErrorResponse(req.ID, fmt.Errorf("internal error: %s", err))
 			return
@@ -51,7 +51,7 @@ func (s *trainTypeObject) dispatch(h *Hub, req Request, conn *connection) {
 		}
 		tts := make(map[string]*simulation.TrainType)
 		for _, id := range idsParams.IDs {
-			ttID, ok := sim.TrainTypes[id]
+			ttID, ok := h.currentSim().TrainTypes[id]
 			if !ok {
 				ch <- NewErrorResponse(req.ID, fmt.Errorf("unknown trainType: %s", id))
 				return
@@ -73,5 +73,5 @@ func (s *trainTypeObject) dispatch(h *Hub, req Request, conn *connection) {
 var _ hubObject = new(trainTypeObject)
 
 func init() {
-	hub.objects["trainType"] = new(trainTypeObject)
+	hubObjects["trainType"] = new(trainTypeObject)
 }
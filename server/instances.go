@@ -0,0 +1,92 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// instanceNameRegexp matches the valid names of simulation instances
+var instanceNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// An Instance describes a simulation instance served by the server.
+type Instance struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// AddSimulation serves the given simulation as a new instance with the given
+// name. Its clients connect to the /ws/{name} websocket endpoint and are
+// served by a hub of its own.
+//
+// As for Run, the simulation must be initialized after AddSimulation is called.
+func AddSimulation(name string, s *simulation.Simulation) error {
+	if !instanceNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid instance name: %s", name)
+	}
+	hubsMutex.Lock()
+	defer hubsMutex.Unlock()
+	if _, ok := hubs[name]; ok {
+		return fmt.Errorf("instance %s already exists", name)
+	}
+	h := newHub(name)
+	h.setSim(s)
+	hubUp := make(chan bool)
+	go h.run(hubUp)
+	<-hubUp
+	hubs[name] = h
+	logger.Info("Simulation instance added", "instance", name, "sim", s.Options.Title)
+	return nil
+}
+
+// Simulations returns the simulations currently served by the server, by
+// instance name. The main simulation has an empty name.
+func Simulations() map[string]*simulation.Simulation {
+	hubsMutex.RLock()
+	defer hubsMutex.RUnlock()
+	res := map[string]*simulation.Simulation{"": hub.currentSim()}
+	for name, h := range hubs {
+		res[name] = h.currentSim()
+	}
+	return res
+}
+
+// listInstances returns the named simulation instances served by the server,
+// sorted by name.
+func listInstances() []Instance {
+	hubsMutex.RLock()
+	defer hubsMutex.RUnlock()
+	res := make([]Instance, 0, len(hubs))
+	for name, h := range hubs {
+		res = append(res, Instance{
+			Name:        name,
+			Title:       h.currentSim().Options.Title,
+			Description: h.currentSim().Options.Description,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// arsStatus returns the ARS status of the signals of the simulation served to c.
+func arsStatus(c *websocket.Conn) map[string]bool {
+	err := c.WriteJSON(Request{Object: "ars", Action: "list"})
+	So(err, ShouldBeNil)
+	var resp Response
+	err = c.ReadJSON(&resp)
+	So(err, ShouldBeNil)
+	var status map[string]bool
+	err = json.Unmarshal(resp.Data, &status)
+	So(err, ShouldBeNil)
+	return status
+}
+
+func TestInstances(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+	Convey("Testing simulation instances", t, func() {
+		data, err := ioutil.ReadFile("../simulation/testdata/demo.json")
+		So(err, ShouldBeNil)
+		var s simulation.Simulation
+		So(json.Unmarshal(data, &s), ShouldBeNil)
+		err = AddSimulation("trainee", &s)
+		if err == nil {
+			So(s.Initialize(), ShouldBeNil)
+		} else {
+			So(err.Error(), ShouldEqual, "instance trainee already exists")
+		}
+		Convey("Invalid instance names should be rejected", func() {
+			var s2 simulation.Simulation
+			So(json.Unmarshal(data, &s2), ShouldBeNil)
+			err := AddSimulation("trainee/1", &s2)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "invalid instance name: trainee/1")
+		})
+		Convey("Unknown instances should not be served", func() {
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws/unknown"}
+			_, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldNotBeNil)
+			So(resp.StatusCode, ShouldEqual, 404)
+		})
+		Convey("Instances should be independent", func() {
+			u := url.URL{Scheme: "ws", Host: "127.0.0.1:22222", Path: "/ws/trainee"}
+			ct, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			So(err, ShouldBeNil)
+			defer ct.Close()
+			So(register(t, ct, Client, "", "client-secret"), ShouldBeNil)
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+
+			before := arsStatus(c)
+			resp := sendRequestStatus(ct, "ars", "enable", `{"ids": ["5"]}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(arsStatus(ct)["5"], ShouldBeTrue)
+			So(arsStatus(c), ShouldResemble, before)
+			resp = sendRequestStatus(ct, "ars", "disable", `{"ids": ["5"]}`)
+			So(resp.Data.Status, ShouldEqual, Ok)
+			So(arsStatus(ct)["5"], ShouldBeFalse)
+			So(arsStatus(c), ShouldResemble, before)
+		})
+		Convey("Simulations should return the simulation of each instance", func() {
+			sims := Simulations()
+			So(sims[""], ShouldEqual, hub.currentSim())
+			So(sims["trainee"], ShouldNotBeNil)
+			So(sims["trainee"], ShouldNotEqual, sims[""])
+		})
+		Convey("Instances should be listed", func() {
+			c := clientDial(t)
+			defer c.Close()
+			So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+			err := c.WriteJSON(Request{Object: "server", Action: "listInstances"})
+			So(err, ShouldBeNil)
+			var resp Response
+			err = c.ReadJSON(&resp)
+			So(err, ShouldBeNil)
+			var instances []Instance
+			err = json.Unmarshal(resp.Data, &instances)
+			So(err, ShouldBeNil)
+			So(instances, ShouldResemble, []Instance{{
+				Name:        "trainee",
+				Title:       "TS2 - Demo & Test Sim",
+				Description: "This is a developers test/demo simulation!",
+			}})
+		})
+	})
+}
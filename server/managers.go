@@ -0,0 +1,639 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// ManagerTimeout is the time the simulation waits for the response of a
+// manager client before giving up.
+var ManagerTimeout = 500 * time.Millisecond
+
+// ManagerRefreshInterval is the interval at which the state of all the items
+// of points, signals and lines manager clients is fetched again.
+var ManagerRefreshInterval = time.Second
+
+// registerManager registers the manager of this connection in the given
+// simulation, so that the simulation delegates to it.
+//
+// Routes and trains managers are added to the already registered ones.
+// Points, signals and lines managers replace the registered one until this
+// connection is closed.
+//
+// If the manager was registered in another simulation, it is unregistered
+// from it first.
+func (conn *connection) registerManager(s *simulation.Simulation) error {
+	if conn.unregisterManager != nil {
+		conn.unregisterManager()
+		conn.unregisterManager = nil
+	}
+	switch conn.ManagerType {
+	case RoutesManager:
+		rm := &remoteRoutesManager{conn: conn}
+		s.RegisterRoutesManager(rm)
+		conn.unregisterManager = func() {
+			s.UnregisterRoutesManager(rm)
+		}
+	case TrainsManager:
+		tm := newRemoteTrainsManager(conn)
+		s.RegisterTrainsManager(tm)
+		conn.unregisterManager = func() {
+			s.UnregisterTrainsManager(tm)
+			tm.cache.stop()
+		}
+	case PointsManager, SignalsManager, LinesManager:
+		if m := conn.hub.managerOfType(conn.ManagerType); m != nil && m != conn {
+			return fmt.Errorf("a %s manager is already registered", conn.ManagerType)
+		}
+		switch conn.ManagerType {
+		case PointsManager:
+			pm := newRemotePointsManager(conn, s)
+			previous := s.RegisterPointsItemManager(pm)
+			conn.unregisterManager = func() {
+				s.RegisterPointsItemManager(previous)
+				pm.cache.stop()
+			}
+		case SignalsManager:
+			sm := newRemoteSignalsManager(conn, s)
+			previous := s.RegisterSignalItemManager(sm)
+			conn.unregisterManager = func() {
+				s.RegisterSignalItemManager(previous)
+				sm.cache.stop()
+			}
+		case LinesManager:
+			lm := newRemoteLinesManager(conn, s)
+			previous := s.RegisterLineItemManager(lm)
+			conn.unregisterManager = func() {
+				s.RegisterLineItemManager(previous)
+				lm.cache.stop()
+			}
+		}
+	default:
+		return fmt.Errorf("unknown manager type: %s", conn.ManagerType)
+	}
+	return nil
+}
+
+// managerOfType returns the registered manager connection of the given type,
+// or nil if there is none.
+func (h *Hub) managerOfType(mt ManagerType) *connection {
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+	for c := range h.clientConnections {
+		if c.clientType == Manager && c.ManagerType == mt {
+			return c
+		}
+	}
+	return nil
+}
+
+// call sends a request with the given action and params to the manager of
+// this connection, and waits for its response which is decoded into result.
+//
+// An error is returned if the manager does not respond within ManagerTimeout.
+func (conn *connection) call(action string, params interface{}, result interface{}) error {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("internal error: %s", err)
+	}
+	respChan := make(chan RawJSON, 1)
+	conn.callsMutex.Lock()
+	conn.lastCallID++
+	id := conn.lastCallID
+	conn.calls[id] = respChan
+	conn.callsMutex.Unlock()
+	defer func() {
+		conn.callsMutex.Lock()
+		delete(conn.calls, id)
+		conn.callsMutex.Unlock()
+	}()
+
+	timeout := time.NewTimer(ManagerTimeout)
+	defer timeout.Stop()
+	req := ManagerRequest{
+		ID:      id,
+		MsgType: TypeRequest,
+		Object:  conn.ManagerType,
+		Action:  action,
+		Params:  RawJSON(p),
+	}
+	select {
+	case conn.pushChan <- req:
+	case <-timeout.C:
+		return fmt.Errorf("manager %s did not respond in time", conn.name)
+	}
+	select {
+	case data := <-respChan:
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("invalid response from manager %s: %s", conn.name, err)
+		}
+		return nil
+	case <-timeout.C:
+		return fmt.Errorf("manager %s did not respond in time", conn.name)
+	}
+}
+
+// callStatus calls the given action on the manager of this connection, which
+// must respond with a status message. It returns an error with the message
+// of the manager if the status is not OK.
+func (conn *connection) callStatus(action string, params interface{}) error {
+	var status DataStatus
+	if err := conn.call(action, params, &status); err != nil {
+		return err
+	}
+	if status.Status != Ok {
+		return errors.New(status.Message)
+	}
+	return nil
+}
+
+// deliver sends the data of a response of the manager to the pending call
+// with the given ID.
+func (conn *connection) deliver(id int, data RawJSON) {
+	conn.callsMutex.Lock()
+	defer conn.callsMutex.Unlock()
+	respChan, ok := conn.calls[id]
+	if !ok {
+		logger.Info("Response received for unknown call", "connection", conn.RemoteAddr(), "id", id)
+		return
+	}
+	select {
+	case respChan <- data:
+	default:
+		logger.Info("Duplicate response received", "connection", conn.RemoteAddr(), "id", id)
+	}
+}
+
+// remoteCache holds the last state reported by a manager client for each
+// item of the simulation it manages.
+//
+// The requests to the manager client are sent by a background goroutine, so
+// that the simulation never waits for the manager client.
+type remoteCache struct {
+	sync.RWMutex
+	conn     *connection
+	ids      []string
+	fetch    func(id string) (interface{}, error)
+	states   map[string]interface{}
+	pending  map[string]bool
+	tasks    chan func()
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newRemoteCache returns a new remoteCache for the items with the given IDs,
+// whose state is given by fetch, and starts its background goroutine.
+func newRemoteCache(conn *connection, ids []string, fetch func(id string) (interface{}, error)) *remoteCache {
+	rc := &remoteCache{
+		conn:    conn,
+		ids:     ids,
+		fetch:   fetch,
+		states:  make(map[string]interface{}),
+		pending: make(map[string]bool),
+		tasks:   make(chan func(), 256),
+		done:    make(chan struct{}),
+	}
+	go rc.run()
+	return rc
+}
+
+// run fetches the state of all the items every ManagerRefreshInterval and
+// executes the tasks sent to this cache, until it is stopped.
+func (rc *remoteCache) run() {
+	ticker := time.NewTicker(ManagerRefreshInterval)
+	defer ticker.Stop()
+	rc.refreshAll()
+	for {
+		select {
+		case task := <-rc.tasks:
+			task()
+		case <-ticker.C:
+			rc.refreshAll()
+		case <-rc.done:
+			return
+		}
+	}
+}
+
+// refreshAll fetches the state of all the items, unless the cache is stopped.
+func (rc *remoteCache) refreshAll() {
+	for _, id := range rc.ids {
+		select {
+		case <-rc.done:
+			return
+		default:
+		}
+		rc.refresh(id)
+	}
+}
+
+// refresh fetches the state of the item with the given ID. The state of the
+// item is unknown if the manager client does not respond.
+func (rc *remoteCache) refresh(id string) {
+	state, err := rc.fetch(id)
+	rc.Lock()
+	defer rc.Unlock()
+	if err != nil {
+		logger.Warn("Unable to get item state", "submodule", "manager", "manager", rc.conn.name, "item", id, "error", err)
+		delete(rc.states, id)
+		return
+	}
+	rc.states[id] = state
+}
+
+// get returns the last state reported for the item with the given ID, and
+// false if it is unknown.
+func (rc *remoteCache) get(id string) (interface{}, bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	state, ok := rc.states[id]
+	return state, ok
+}
+
+// refreshLater asks the background goroutine to fetch the state of the item
+// with the given ID, unless it is already waiting to do so.
+func (rc *remoteCache) refreshLater(id string) {
+	rc.Lock()
+	if rc.pending[id] {
+		rc.Unlock()
+		return
+	}
+	rc.pending[id] = true
+	rc.Unlock()
+	queued := rc.enqueue(func() {
+		rc.Lock()
+		delete(rc.pending, id)
+		rc.Unlock()
+		rc.refresh(id)
+	})
+	if !queued {
+		rc.Lock()
+		delete(rc.pending, id)
+		rc.Unlock()
+	}
+}
+
+// enqueue sends the given task to the background goroutine of this cache. It
+// returns false if the task has been dropped because too many tasks are
+// pending.
+func (rc *remoteCache) enqueue(task func()) bool {
+	select {
+	case rc.tasks <- task:
+		return true
+	default:
+		logger.Warn("Too many pending requests, request dropped", "submodule", "manager", "manager", rc.conn.name)
+		return false
+	}
+}
+
+// stop stops the background goroutine of this cache.
+func (rc *remoteCache) stop() {
+	rc.stopOnce.Do(func() {
+		close(rc.done)
+	})
+}
+
+// trackItemIDs returns the sorted IDs of the track items of the given
+// simulation for which keep returns true.
+func trackItemIDs(s *simulation.Simulation, keep func(simulation.TrackItem) bool) []string {
+	var ids []string
+	for id, ti := range s.TrackItems {
+		if keep(ti) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// idParams are the params of manager requests about a single object.
+type idParams struct {
+	ID string `json:"id"`
+}
+
+// failedParams are the params of the setFailed manager requests.
+type failedParams struct {
+	ID     string `json:"id"`
+	Failed bool   `json:"failed"`
+}
+
+// remoteRoutesManager is a simulation.RoutesManager that delegates to a
+// manager client.
+type remoteRoutesManager struct {
+	conn *connection
+}
+
+// Name returns the name of the manager client.
+func (m *remoteRoutesManager) Name() string {
+	return m.conn.name
+}
+
+// CanActivate returns an error if the manager client does not allow the
+// given route to be activated.
+func (m *remoteRoutesManager) CanActivate(r *simulation.Route) error {
+	return m.conn.callStatus("canActivate", idParams{ID: r.ID()})
+}
+
+// CanDeactivate returns an error if the manager client does not allow the
+// given route to be deactivated.
+func (m *remoteRoutesManager) CanDeactivate(r *simulation.Route) error {
+	return m.conn.callStatus("canDeactivate", idParams{ID: r.ID()})
+}
+
+var _ simulation.RoutesManager = new(remoteRoutesManager)
+
+// remotePointsManager is a simulation.PointsItemManager that delegates to a
+// manager client.
+type remotePointsManager struct {
+	conn  *connection
+	cache *remoteCache
+}
+
+// newRemotePointsManager returns a remotePointsManager for the points of the
+// given simulation, which fetches their direction in the background.
+func newRemotePointsManager(conn *connection, s *simulation.Simulation) *remotePointsManager {
+	ids := trackItemIDs(s, func(ti simulation.TrackItem) bool {
+		_, ok := ti.(*simulation.PointsItem)
+		return ok
+	})
+	fetch := func(id string) (interface{}, error) {
+		var dir simulation.PointDirection
+		err := conn.call("direction", idParams{ID: id}, &dir)
+		return dir, err
+	}
+	return &remotePointsManager{
+		conn:  conn,
+		cache: newRemoteCache(conn, ids, fetch),
+	}
+}
+
+// Name returns the name of the manager client.
+func (m *remotePointsManager) Name() string {
+	return m.conn.name
+}
+
+// Direction returns the last direction of the points given by the manager
+// client, or DirectionUnknown if it has not responded.
+func (m *remotePointsManager) Direction(pi *simulation.PointsItem) simulation.PointDirection {
+	dir, ok := m.cache.get(pi.ID())
+	if !ok {
+		return simulation.DirectionUnknown
+	}
+	return dir.(simulation.PointDirection)
+}
+
+// SetDirection asks the manager client to set the given points to the given
+// direction, without waiting for its response.
+func (m *remotePointsManager) SetDirection(pi *simulation.PointsItem, dir simulation.PointDirection) {
+	params := struct {
+		ID        string                    `json:"id"`
+		Direction simulation.PointDirection `json:"direction"`
+	}{
+		ID:        pi.ID(),
+		Direction: dir,
+	}
+	m.cache.enqueue(func() {
+		if err := m.conn.call("setDirection", params, nil); err != nil {
+			logger.Warn("Unable to set points direction", "submodule", "manager", "points", params.ID, "error", err)
+		}
+		m.cache.refresh(params.ID)
+	})
+}
+
+var _ simulation.PointsItemManager = new(remotePointsManager)
+
+// remoteSignalsManager is a simulation.SignalItemManager that delegates to a
+// manager client.
+type remoteSignalsManager struct {
+	conn  *connection
+	sim   *simulation.Simulation
+	cache *remoteCache
+}
+
+// signalState is the state of a signal reported by a signals manager client.
+type signalState struct {
+	aspect string
+	failed bool
+}
+
+// newRemoteSignalsManager returns a remoteSignalsManager for the signals of
+// the given simulation, which fetches their aspect and failure in the
+// background.
+func newRemoteSignalsManager(conn *connection, s *simulation.Simulation) *remoteSignalsManager {
+	ids := trackItemIDs(s, func(ti simulation.TrackItem) bool {
+		_, ok := ti.(*simulation.SignalItem)
+		return ok
+	})
+	fetch := func(id string) (interface{}, error) {
+		var st signalState
+		if err := conn.call("getAspect", idParams{ID: id}, &st.aspect); err != nil {
+			return nil, err
+		}
+		err := conn.call("isFailed", idParams{ID: id}, &st.failed)
+		return st, err
+	}
+	return &remoteSignalsManager{
+		conn:  conn,
+		sim:   s,
+		cache: newRemoteCache(conn, ids, fetch),
+	}
+}
+
+// Name returns the name of the manager client.
+func (m *remoteSignalsManager) Name() string {
+	return m.conn.name
+}
+
+// GetAspect returns the last aspect of the given signal given by the manager
+// client.
+//
+// If the manager has not responded or responded with an unknown aspect, the
+// failed aspect of the signal is returned.
+func (m *remoteSignalsManager) GetAspect(si *simulation.SignalItem) *simulation.SignalAspect {
+	st, ok := m.cache.get(si.ID())
+	if !ok {
+		return si.FailedAspect()
+	}
+	name := st.(signalState).aspect
+	aspect, ok := m.sim.SignalLib.Aspects[name]
+	if !ok {
+		logger.Warn("Unknown signal aspect", "submodule", "manager", "signal", si.ID(), "aspect", name)
+		return si.FailedAspect()
+	}
+	return aspect
+}
+
+// IsFailed returns true if the manager client last reported that the lamps of
+// the given signal have failed.
+func (m *remoteSignalsManager) IsFailed(si *simulation.SignalItem) bool {
+	st, ok := m.cache.get(si.ID())
+	return ok && st.(signalState).failed
+}
+
+// SetFailed asks the manager client to set or repair a lamp failure on the
+// given signal, without waiting for its response.
+func (m *remoteSignalsManager) SetFailed(si *simulation.SignalItem, failed bool) {
+	params := failedParams{ID: si.ID(), Failed: failed}
+	m.cache.enqueue(func() {
+		if err := m.conn.call("setFailed", params, nil); err != nil {
+			logger.Warn("Unable to set signal failure", "submodule", "manager", "signal", params.ID, "error", err)
+		}
+		m.cache.refresh(params.ID)
+	})
+}
+
+// Update does nothing since manager clients make signals fail on their own.
+func (m *remoteSignalsManager) Update(*simulation.SignalItem) {}
+
+var _ simulation.SignalItemManager = new(remoteSignalsManager)
+var _ simulation.SignalItemFailureManager = new(remoteSignalsManager)
+
+// remoteLinesManager is a simulation.LineItemManager that delegates to a
+// manager client.
+type remoteLinesManager struct {
+	conn  *connection
+	cache *remoteCache
+}
+
+// newRemoteLinesManager returns a remoteLinesManager for the lines, platforms
+// and invisible links of the given simulation, which fetches their failures
+// in the background.
+func newRemoteLinesManager(conn *connection, s *simulation.Simulation) *remoteLinesManager {
+	ids := trackItemIDs(s, func(ti simulation.TrackItem) bool {
+		switch ti.(type) {
+		case *simulation.LineItem, *simulation.PlatformItem, *simulation.InvisibleLinkItem:
+			return true
+		}
+		return false
+	})
+	fetch := func(id string) (interface{}, error) {
+		var failed bool
+		err := conn.call("isFailed", idParams{ID: id}, &failed)
+		return failed, err
+	}
+	return &remoteLinesManager{
+		conn:  conn,
+		cache: newRemoteCache(conn, ids, fetch),
+	}
+}
+
+// Name returns the name of the manager client.
+func (m *remoteLinesManager) Name() string {
+	return m.conn.name
+}
+
+// IsFailed returns true if the manager client last reported a track circuit
+// failure on the given line.
+func (m *remoteLinesManager) IsFailed(li *simulation.LineItem) bool {
+	failed, ok := m.cache.get(li.ID())
+	return ok && failed.(bool)
+}
+
+// SetFailed asks the manager client to set or repair a track circuit failure
+// on the given line, without waiting for its response.
+func (m *remoteLinesManager) SetFailed(li *simulation.LineItem, failed bool) {
+	params := failedParams{ID: li.ID(), Failed: failed}
+	m.cache.enqueue(func() {
+		if err := m.conn.call("setFailed", params, nil); err != nil {
+			logger.Warn("Unable to set line failure", "submodule", "manager", "line", params.ID, "error", err)
+		}
+		m.cache.refresh(params.ID)
+	})
+}
+
+// Update does nothing since manager clients make lines fail on their own.
+func (m *remoteLinesManager) Update(*simulation.LineItem) {}
+
+var _ simulation.LineItemManager = new(remoteLinesManager)
+var _ simulation.LineItemFailureManager = new(remoteLinesManager)
+
+// remoteTrainsManager is a simulation.TrainsManager that delegates to a
+// manager client.
+type remoteTrainsManager struct {
+	sync.Mutex
+	conn  *connection
+	cache *remoteCache
+	// elapsed is the time elapsed of the last call of Speed
+	elapsed time.Duration
+}
+
+// newRemoteTrainsManager returns a remoteTrainsManager which fetches the
+// speed of the trains in the background.
+func newRemoteTrainsManager(conn *connection) *remoteTrainsManager {
+	m := &remoteTrainsManager{conn: conn}
+	fetch := func(id string) (interface{}, error) {
+		trainID, _ := strconv.Atoi(id)
+		m.Lock()
+		elapsed := m.elapsed
+		m.Unlock()
+		params := struct {
+			ID      int     `json:"id"`
+			Elapsed float64 `json:"elapsed"`
+		}{
+			ID:      trainID,
+			Elapsed: elapsed.Seconds(),
+		}
+		var speed float64
+		err := conn.call("speed", params, &speed)
+		return speed, err
+	}
+	m.cache = newRemoteCache(conn, nil, fetch)
+	return m
+}
+
+// Name returns the name of the manager client.
+func (m *remoteTrainsManager) Name() string {
+	return m.conn.name
+}
+
+// Code returns the name of the manager client, which is also the code with
+// which trains can be set to use this manager.
+func (m *remoteTrainsManager) Code() string {
+	return m.conn.name
+}
+
+// Speed returns the last speed of the given train given by the manager
+// client, or the current speed of the train if it has not responded. The
+// speed after timeElapsed is asked to the manager client in the background,
+// to be used at the next call.
+func (m *remoteTrainsManager) Speed(t *simulation.Train, timeElapsed time.Duration) float64 {
+	m.Lock()
+	m.elapsed = timeElapsed
+	m.Unlock()
+	m.cache.refreshLater(t.ID())
+	speed, ok := m.cache.get(t.ID())
+	if !ok {
+		return t.Speed
+	}
+	return speed.(float64)
+}
+
+var _ simulation.TrainsManager = new(remoteTrainsManager)
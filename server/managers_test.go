@@ -0,0 +1,190 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// registerManager logs the manager in with the given manager type and name.
+func registerManager(c *websocket.Conn, mt ManagerType, name string) ResponseStatus {
+	err := c.WriteJSON(RequestRegister{1234, "server", "register", ParamsRegister{Manager, mt, "client-secret", name}})
+	So(err, ShouldBeNil)
+	var resp ResponseStatus
+	err = c.ReadJSON(&resp)
+	So(err, ShouldBeNil)
+	return resp
+}
+
+func TestManagers(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+	Convey("Testing manager clients", t, func() {
+		c := clientDial(t)
+		m := clientDial(t)
+		So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+		Convey("Unknown manager types should be rejected", func() {
+			resp := registerManager(m, "interlocking", "ilk")
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: unknown manager type: interlocking")
+		})
+		Convey("Routes managers should be able to veto route activation", func() {
+			resp := registerManager(m, RoutesManager, "ilk")
+			So(resp.Data.Status, ShouldEqual, Ok)
+
+			err := c.WriteJSON(Request{Object: "route", Action: "activate", Params: RawJSON(`{"id": "11"}`)})
+			So(err, ShouldBeNil)
+			var mReq ManagerRequest
+			err = m.ReadJSON(&mReq)
+			So(err, ShouldBeNil)
+			So(mReq.MsgType, ShouldEqual, TypeRequest)
+			So(mReq.Object, ShouldEqual, RoutesManager)
+			So(mReq.Action, ShouldEqual, "canActivate")
+			So(string(mReq.Params), ShouldEqual, `{"id":"11"}`)
+			err = m.WriteJSON(ResponseStatus{ID: mReq.ID, MsgType: TypeResponse, Data: DataStatus{Fail, "track occupied"}})
+			So(err, ShouldBeNil)
+			var cResp ResponseStatus
+			err = c.ReadJSON(&cResp)
+			So(err, ShouldBeNil)
+			So(cResp.Data.Status, ShouldEqual, Fail)
+			So(cResp.Data.Message, ShouldEqual, "Error: cannot activate route 11: ilk vetoed route activation: track occupied")
+
+			err = c.WriteJSON(Request{Object: "route", Action: "activate", Params: RawJSON(`{"id": "11"}`)})
+			So(err, ShouldBeNil)
+			err = m.ReadJSON(&mReq)
+			So(err, ShouldBeNil)
+			err = m.WriteJSON(NewOkResponse(mReq.ID, ""))
+			So(err, ShouldBeNil)
+			err = c.ReadJSON(&cResp)
+			So(err, ShouldBeNil)
+			So(cResp.Data.Status, ShouldEqual, Ok)
+		})
+		Convey("Managers which do not respond should time out", func() {
+			ManagerTimeout = 100 * time.Millisecond
+			defer func() { ManagerTimeout = 500 * time.Millisecond }()
+			resp := registerManager(m, RoutesManager, "ilk")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			resp = sendRequestStatus(c, "route", "activate", `{"id": "11"}`)
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: cannot activate route 11: ilk vetoed route activation: manager ilk did not respond in time")
+		})
+		Convey("Only one points manager should be registered at a time", func() {
+			resp := registerManager(m, PointsManager, "points1")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			m2 := clientDial(t)
+			defer m2.Close()
+			resp = registerManager(m2, PointsManager, "points2")
+			So(resp.Data.Status, ShouldEqual, Fail)
+			So(resp.Data.Message, ShouldEqual, "Error: a points manager is already registered")
+		})
+		Convey("Points managers should be queried in the background", func() {
+			pi := hub.sim.TrackItems["7"].(*simulation.PointsItem)
+			resp := registerManager(m, PointsManager, "points1")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			start := time.Now()
+			So(pi.Direction(), ShouldEqual, simulation.DirectionUnknown)
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+
+			var mReq ManagerRequest
+			err := m.ReadJSON(&mReq)
+			So(err, ShouldBeNil)
+			So(mReq.Object, ShouldEqual, PointsManager)
+			So(mReq.Action, ShouldEqual, "direction")
+			So(string(mReq.Params), ShouldEqual, `{"id":"7"}`)
+			err = m.WriteJSON(Response{ID: mReq.ID, MsgType: TypeResponse, Data: RawJSON(`1`)})
+			So(err, ShouldBeNil)
+			for i := 0; i < 100 && pi.Direction() != simulation.DirectionReversed; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(pi.Direction(), ShouldEqual, simulation.DirectionReversed)
+		})
+		Convey("Lines managers should manage the failures of platforms", func() {
+			pi := hub.sim.TrackItems["22"].(*simulation.PlatformItem)
+			resp := registerManager(m, LinesManager, "lines1")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			for i := 0; i < 100; i++ {
+				var mReq ManagerRequest
+				err := m.ReadJSON(&mReq)
+				So(err, ShouldBeNil)
+				So(mReq.Action, ShouldEqual, "isFailed")
+				failed := string(mReq.Params) == `{"id":"22"}`
+				err = m.WriteJSON(Response{ID: mReq.ID, MsgType: TypeResponse, Data: RawJSON(fmt.Sprintf("%t", failed))})
+				So(err, ShouldBeNil)
+				if failed {
+					break
+				}
+			}
+			for i := 0; i < 100 && !pi.IsFailed(); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(pi.IsFailed(), ShouldBeTrue)
+		})
+		Convey("Signals managers should be queried in the background", func() {
+			si := hub.sim.TrackItems["5"].(*simulation.SignalItem)
+			resp := registerManager(m, SignalsManager, "signals1")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			start := time.Now()
+			So(si.IsFailed(), ShouldBeFalse)
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+			for i := 0; i < 100; i++ {
+				var mReq ManagerRequest
+				err := m.ReadJSON(&mReq)
+				So(err, ShouldBeNil)
+				if mReq.Action == "getAspect" {
+					err = m.WriteJSON(Response{ID: mReq.ID, MsgType: TypeResponse, Data: RawJSON(`"UK_DANGER"`)})
+					So(err, ShouldBeNil)
+					continue
+				}
+				So(mReq.Action, ShouldEqual, "isFailed")
+				failed := string(mReq.Params) == `{"id":"5"}`
+				err = m.WriteJSON(Response{ID: mReq.ID, MsgType: TypeResponse, Data: RawJSON(fmt.Sprintf("%t", failed))})
+				So(err, ShouldBeNil)
+				if failed {
+					break
+				}
+			}
+			for i := 0; i < 100 && !si.IsFailed(); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(si.IsFailed(), ShouldBeTrue)
+		})
+		Convey("Trains managers should be queried in the background", func() {
+			resp := registerManager(m, TrainsManager, "driver1")
+			So(resp.Data.Status, ShouldEqual, Ok)
+			conn := hub.managerOfType(TrainsManager)
+			So(conn != nil, ShouldBeTrue)
+			tm := newRemoteTrainsManager(conn)
+			defer tm.cache.stop()
+			tr := hub.sim.Trains[0]
+			speed := tr.Speed
+			start := time.Now()
+			So(tm.Speed(tr, 2*time.Second), ShouldEqual, speed)
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+
+			var mReq ManagerRequest
+			err := m.ReadJSON(&mReq)
+			So(err, ShouldBeNil)
+			So(mReq.Action, ShouldEqual, "speed")
+			So(string(mReq.Params), ShouldEqual, `{"id":0,"elapsed":2}`)
+			err = m.WriteJSON(Response{ID: mReq.ID, MsgType: TypeResponse, Data: RawJSON(`7.5`)})
+			So(err, ShouldBeNil)
+			for i := 0; i < 100 && tm.Speed(tr, 2*time.Second) != 7.5; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(tm.Speed(tr, 2*time.Second), ShouldEqual, 7.5)
+		})
+		Reset(func() {
+			_ = m.Close()
+			_ = c.Close()
+			// Wait for the managers to be unregistered
+			time.Sleep(100 * time.Millisecond)
+		})
+	})
+}
@@ -61,11 +61,31 @@ type Request struct {
 	Params RawJSON `json:"params"`
 }
 
+// ManagerRequest is a request made by the simulation to a manager client.
+//
+// The manager must send back a Response with the same ID.
+type ManagerRequest struct {
+	ID      int         `json:"id"`
+	MsgType MessageType `json:"msgType"`
+	Object  ManagerType `json:"object"`
+	Action  string      `json:"action"`
+	Params  RawJSON     `json:"params"`
+}
+
+// message is any message received from a websocket client, that is either a
+// Request or, for managers, a Response to a ManagerRequest.
+type message struct {
+	Request
+	MsgType MessageType `json:"msgType"`
+	Data    RawJSON     `json:"data"`
+}
+
 // ParamsRegister is the struct of the Request Params for a RequestRegister
 type ParamsRegister struct {
 	ClientType    ClientType  `json:"type"`
 	ClientSubType ManagerType `json:"subType"`
 	Token         string      `json:"token"`
+	Name          string      `json:"name"`
 }
 
 // RequestRegister is a request made by a websocket client to log onto the server.
@@ -37,6 +37,7 @@ type MessageType string
 const (
 	TypeResponse     MessageType = "response"
 	TypeNotification MessageType = "notification"
+	TypeRequest      MessageType = "request"
 )
 
 // Response is a status message sent to a websocket client
@@ -65,6 +66,17 @@ type DataEvent struct {
 	Object interface{}          `json:"object"`
 }
 
+// messageObject is the object of the notifications of the server which are
+// not related to a simulation object.
+type messageObject struct {
+	Message string `json:"message"`
+}
+
+// ID method to implement SimObject. Returns an empty string.
+func (mo messageObject) ID() string {
+	return ""
+}
+
 // ResponseNotification is a message sent by the server to the clients when an event is triggered in the simulation
 type ResponseNotification struct {
 	MsgType MessageType `json:"msgType"`
@@ -0,0 +1,110 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// ServerShutdownEvent is sent to all clients when the server shuts down,
+// whether they listen to it or not.
+const ServerShutdownEvent simulation.EventName = "serverShutdown"
+
+// closeWriteWait is the time allowed to write the close frame to a client.
+const closeWriteWait = time.Second
+
+var (
+	// httpServer is the HTTP server started by HttpdStart
+	httpServer *http.Server
+
+	// shutdownChan is closed when the server shuts down
+	shutdownChan = make(chan struct{})
+	shutdownOnce sync.Once
+)
+
+// closeFrame is pushed to a connection to close it with a close frame, once
+// the messages pushed before it have been written. done is closed when the
+// close frame has been written.
+type closeFrame struct {
+	done chan struct{}
+}
+
+// Shutdown stops the server gracefully.
+//
+// The simulations of all instances are paused, all clients are notified with
+// a serverShutdown event and their websocket connections are closed with a
+// close frame. The HTTP server is then stopped. Shutdown returns an error if
+// ctx is done before all of this is finished.
+func Shutdown(ctx context.Context) error {
+	logger.Info("Shutting down server")
+	shutdownOnce.Do(func() {
+		close(shutdownChan)
+	})
+	for _, h := range allHubs() {
+		if h.currentSim().IsStarted() {
+			h.currentSim().Pause()
+		}
+		if err := h.closeConnections(ctx); err != nil {
+			return err
+		}
+	}
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+// closeConnections notifies all the registered clients of the shutdown and
+// closes their websocket connection.
+func (h *Hub) closeConnections(ctx context.Context) error {
+	h.broadcast(&simulation.Event{
+		Name:   ServerShutdownEvent,
+		Object: messageObject{Message: "Server shutting down"},
+	})
+	h.clientsMutex.RLock()
+	frames := make([]*closeFrame, 0, len(h.clientConnections))
+	for c := range h.clientConnections {
+		cf := &closeFrame{done: make(chan struct{})}
+		c.pushChan <- cf
+		frames = append(frames, cf)
+	}
+	h.clientsMutex.RUnlock()
+	for _, cf := range frames {
+		select {
+		case <-cf.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// writeClose writes a close frame to the client of this connection.
+func (conn *connection) writeClose() {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait)); err != nil {
+		logger.Info("Error while closing", "connection", conn.RemoteAddr(), "error", err)
+	}
+}
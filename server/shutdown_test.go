@@ -0,0 +1,39 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCloseConnections(t *testing.T) {
+	// Wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+	Convey("Testing closing connections on shutdown", t, func() {
+		c := clientDial(t)
+		So(register(t, c, Client, "", "client-secret"), ShouldBeNil)
+		// Wait for the client to be registered on the hub
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		So(hub.closeConnections(ctx), ShouldBeNil)
+
+		var event ResponseNotification
+		err := c.ReadJSON(&event)
+		So(err, ShouldBeNil)
+		So(event.MsgType, ShouldEqual, TypeNotification)
+		So(event.Data.Name, ShouldEqual, ServerShutdownEvent)
+
+		_, _, err = c.ReadMessage()
+		So(websocket.IsCloseError(err, websocket.CloseGoingAway), ShouldBeTrue)
+		Reset(func() {
+			_ = c.Close()
+		})
+	})
+}
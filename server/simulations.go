@@ -0,0 +1,155 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ts2/ts2-sim-server/simulation"
+)
+
+// SimulationLoadedEvent is sent to all clients when another simulation has
+// been loaded, so that they reload their data.
+const SimulationLoadedEvent simulation.EventName = "simulationLoaded"
+
+// SimulationsDir is the directory of the simulation files that can be loaded
+// with the server/loadSimulation action. If it is empty, no simulation can be
+// loaded at runtime.
+var SimulationsDir string
+
+// Seed is the seed of the random delays of the simulations loaded with the
+// server/loadSimulation action. If it is 0, the seed option of the simulation
+// file is used.
+var Seed int64
+
+// A SimulationFile describes a simulation file of SimulationsDir.
+type SimulationFile struct {
+	File        string `json:"file"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// listSimulations returns the simulation files of SimulationsDir.
+func listSimulations() ([]SimulationFile, error) {
+	if SimulationsDir == "" {
+		return nil, fmt.Errorf("no simulations directory")
+	}
+	files, err := ioutil.ReadDir(SimulationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read simulations directory: %s", err)
+	}
+	res := make([]SimulationFile, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(SimulationsDir, f.Name()))
+		if err != nil {
+			logger.Info("Unable to read simulation file", "submodule", "hub", "file", f.Name(), "error", err)
+			continue
+		}
+		var header struct {
+			Options struct {
+				Title       string `json:"title"`
+				Description string `json:"description"`
+			} `json:"options"`
+		}
+		if err := json.Unmarshal(data, &header); err != nil {
+			logger.Info("Invalid simulation file", "submodule", "hub", "file", f.Name(), "error", err)
+			continue
+		}
+		res = append(res, SimulationFile{
+			File:        f.Name(),
+			Title:       header.Options.Title,
+			Description: header.Options.Description,
+		})
+	}
+	return res, nil
+}
+
+// loadSimulation loads and initializes the simulation of the given file of
+// SimulationsDir.
+func loadSimulation(fileName string) (*simulation.Simulation, error) {
+	if SimulationsDir == "" {
+		return nil, fmt.Errorf("no simulations directory")
+	}
+	if fileName == "" || fileName != filepath.Base(fileName) {
+		return nil, fmt.Errorf("invalid simulation file: %s", fileName)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(SimulationsDir, fileName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %s", fileName, err)
+	}
+	s := new(simulation.Simulation)
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if Seed != 0 {
+		s.Options.Seed = Seed
+	}
+	// Events sent while initializing are dropped since clients will reload
+	// the whole simulation.
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.EventChan:
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer close(done)
+	if err = s.Initialize(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// switchSimulation makes the hub serve the given simulation instead of the
+// current one, which is paused. The manager clients of the hub are moved to
+// the new simulation and all clients are notified with a simulationLoaded
+// event.
+func (h *Hub) switchSimulation(s *simulation.Simulation, fileName string) {
+	if h.currentSim().IsStarted() {
+		h.currentSim().Pause()
+	}
+	h.loadChan <- s
+	h.clientsMutex.RLock()
+	var mgrs []*connection
+	for c := range h.clientConnections {
+		if c.clientType == Manager {
+			mgrs = append(mgrs, c)
+		}
+	}
+	h.clientsMutex.RUnlock()
+	for _, c := range mgrs {
+		if err := c.registerManager(s); err != nil {
+			logger.Warn("Unable to register manager", "submodule", "hub", "connection", c.RemoteAddr(), "error", err)
+		}
+	}
+	h.broadcast(&simulation.Event{
+		Name:   SimulationLoadedEvent,
+		Object: messageObject{Message: fmt.Sprintf("Simulation %s loaded", fileName)},
+	})
+}
@@ -20,6 +20,7 @@ package server
 
 import (
 	"net/http"
+	"strings"
 
 	"context"
 
@@ -35,7 +36,15 @@ var upgrader = websocket.Upgrader{
 //
 // It reads JSON from the client and sends a Request object to the hub.
 // It receives Response objects from the hub and send JSON to the client.
+//
+// Clients connecting to /ws/{name} are bound to the hub of the simulation
+// instance with this name, and those connecting to /ws to the default hub.
 func serveWs(w http.ResponseWriter, r *http.Request) {
+	h := hubNamed(strings.Trim(strings.TrimPrefix(r.URL.Path, "/ws"), "/"))
+	if h == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("Unable to upgrade to WebSocket", "submodule", "http", "error", err)
@@ -43,6 +52,7 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 	}
 	conn := &connection{
 		Conn:     *ws,
+		hub:      h,
 		pushChan: make(chan interface{}, 256),
 	}
 	ctx, cancel := context.WithCancel(context.Background())
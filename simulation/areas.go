@@ -0,0 +1,170 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// An Area is a part of the layout that is controlled by a single signaller.
+//
+// An area is made of the routes starting at its signals and of its routes.
+// The controller of an area is the name of the client that operates it, or an
+// empty string if nobody controls the area. It is not saved in the
+// simulation file.
+type Area struct {
+	areaID     string
+	Name       string   `json:"name"`
+	SignalIDs  []string `json:"signals"`
+	RouteIDs   []string `json:"routes"`
+	controller string
+	mutex      sync.RWMutex
+	simulation *Simulation
+}
+
+// ID returns the unique identifier of this area
+func (a *Area) ID() string {
+	return a.areaID
+}
+
+// Controller returns the name of the client that controls this area, or an
+// empty string if the area is not controlled.
+func (a *Area) Controller() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.controller
+}
+
+// SetController hands this area over to the client with the given name.
+// An empty name releases the area.
+func (a *Area) SetController(name string) {
+	a.mutex.Lock()
+	if a.controller == name {
+		a.mutex.Unlock()
+		return
+	}
+	a.controller = name
+	a.mutex.Unlock()
+	a.simulation.sendEvent(&Event{Name: AreaControlChangedEvent, Object: a})
+}
+
+// ContainsSignal returns true if the given signal belongs to this area.
+func (a *Area) ContainsSignal(si *SignalItem) bool {
+	for _, id := range a.SignalIDs {
+		if id == si.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsRoute returns true if the given route belongs to this area, either
+// because it is one of its routes or because it starts at one of its signals.
+func (a *Area) ContainsRoute(r *Route) bool {
+	for _, id := range a.RouteIDs {
+		if id == r.ID() {
+			return true
+		}
+	}
+	return a.ContainsSignal(r.BeginSignal())
+}
+
+// ContainsTrackItem returns true if the given track item belongs to this area,
+// either because it is one of its signals or because one of its routes goes
+// through it.
+func (a *Area) ContainsTrackItem(ti TrackItem) bool {
+	if si, ok := ti.(*SignalItem); ok && a.ContainsSignal(si) {
+		return true
+	}
+	for _, r := range a.simulation.Routes {
+		if !a.ContainsRoute(r) {
+			continue
+		}
+		for _, pos := range r.Positions {
+			if pos.TrackItemID == ti.ID() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setSimulation sets the simulation of this area.
+func (a *Area) setSimulation(sim *Simulation) {
+	a.simulation = sim
+}
+
+// initialize checks the signals and routes of this area.
+func (a *Area) initialize(areaID string) error {
+	a.areaID = areaID
+	for _, id := range a.SignalIDs {
+		if _, ok := a.simulation.TrackItems[id].(*SignalItem); !ok {
+			return fmt.Errorf("unknown signal: %s", id)
+		}
+	}
+	for _, id := range a.RouteIDs {
+		if _, ok := a.simulation.Routes[id]; !ok {
+			return fmt.Errorf("unknown route: %s", id)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON method for the Area type
+func (a *Area) MarshalJSON() ([]byte, error) {
+	type auxArea struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		SignalIDs  []string `json:"signals"`
+		RouteIDs   []string `json:"routes"`
+		Controller string   `json:"controller,omitempty"`
+	}
+	aa := auxArea{
+		ID:         a.ID(),
+		Name:       a.Name,
+		SignalIDs:  a.SignalIDs,
+		RouteIDs:   a.RouteIDs,
+		Controller: a.Controller(),
+	}
+	return json.Marshal(aa)
+}
+
+// AreasOf returns the areas to which the given route belongs.
+func (sim *Simulation) AreasOf(r *Route) []*Area {
+	var res []*Area
+	for _, a := range sim.Areas {
+		if a.ContainsRoute(r) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// AreasOfTrackItem returns the areas to which the given track item belongs.
+func (sim *Simulation) AreasOfTrackItem(ti TrackItem) []*Area {
+	var res []*Area
+	for _, a := range sim.Areas {
+		if a.ContainsTrackItem(ti) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An ARSManager is an automatic route setting system that activates routes
+// in front of trains according to their service.
+//
+// Automatic route setting is enabled or disabled signal by signal, so that
+// humans can take over at any time.
+type ARSManager interface {
+	// Name returns a description of this ARSManager that is used for the UI.
+	Name() string
+	// SetRoutes is called at each time step of the simulation, before trains
+	// are moved, to activate the routes needed by the trains.
+	SetRoutes(*Simulation)
+	// IsEnabled returns true if routes are set automatically from the given signal.
+	IsEnabled(*SignalItem) bool
+	// SetEnabled enables or disables automatic route setting from the given signal.
+	SetEnabled(*SignalItem, bool)
+}
+
+// ARSEnabled returns true if routes are set automatically from this signal.
+func (si *SignalItem) ARSEnabled() bool {
+	am := si.simulation.currentARSManager()
+	if am == nil {
+		return false
+	}
+	return am.IsEnabled(si)
+}
+
+// SetARSEnabled enables or disables automatic route setting from this signal.
+func (si *SignalItem) SetARSEnabled(enabled bool) error {
+	am := si.simulation.currentARSManager()
+	if am == nil {
+		return fmt.Errorf("no automatic route setting manager registered")
+	}
+	if am.IsEnabled(si) == enabled {
+		return nil
+	}
+	am.SetEnabled(si, enabled)
+	si.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: si,
+	})
+	return nil
+}
+
+// RoutesFrom returns the routes starting at the given signal, sorted by ID.
+func (sim *Simulation) RoutesFrom(si *SignalItem) []*Route {
+	var res []*Route
+	for _, r := range sim.Routes {
+		if r.BeginSignalId == si.ID() {
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID() < res[j].ID()
+	})
+	return res
+}
@@ -28,12 +28,14 @@ const (
 	OptionsChangedEvent           EventName = "optionsChanged"
 	RouteActivatedEvent           EventName = "routeActivated"
 	RouteDeactivatedEvent         EventName = "routeDeactivated"
+	RouteCancellingEvent          EventName = "routeCancelling"
 	TrainStoppedAtStationEvent    EventName = "trainStoppedAtStation"
 	TrainDepartedFromStationEvent EventName = "trainDepartedFromStation"
 	TrainChangedEvent             EventName = "trainChanged"
 	SignalaspectChangedEvent      EventName = "signalAspectChanged"
 	TrackItemChangedEvent         EventName = "trackItemChanged"
 	MessageReceivedEvent          EventName = "messageReceived"
+	AreaControlChangedEvent       EventName = "areaControlChanged"
 )
 
 // A SimObject can be serialized in an event
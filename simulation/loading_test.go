@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -96,6 +97,18 @@ func TestSimulationLoading(t *testing.T) {
 			So(r4.InitialState, ShouldEqual, Deactivated)
 			So(r4.State(), ShouldEqual, Deactivated)
 		})
+		Convey("Areas should be correctly loaded", func() {
+			So(sim.Areas, ShouldHaveLength, 2)
+			So(sim.Areas, ShouldContainKey, "STN")
+			stn := sim.Areas["STN"]
+			So(stn.ID(), ShouldEqual, "STN")
+			So(stn.Name, ShouldEqual, "Station")
+			So(stn.Controller(), ShouldEqual, "")
+			So(stn.ContainsRoute(sim.Routes["1"]), ShouldBeTrue)
+			So(stn.ContainsRoute(sim.Routes["11"]), ShouldBeFalse)
+			So(sim.AreasOf(sim.Routes["11"]), ShouldResemble, []*Area{sim.Areas["RGT"]})
+			So(sim.AreasOf(sim.Routes["3"]), ShouldResemble, []*Area{stn})
+		})
 		Convey("TrackItems loading", func() {
 			Convey("TrackItems links should be ok", func() {
 				err := sim.checkTrackItemsLinks()
@@ -209,6 +222,20 @@ func TestSimulationLoading(t *testing.T) {
 			So(tt2.Elements()[0], ShouldEqual, tt)
 			So(tt2.Elements()[1], ShouldEqual, tt)
 		})
+		Convey("Composite train types should derive their dynamics from their elements", func() {
+			data := strings.Replace(string(loadSim("testdata/demo.json")), `"description": "Underground train",`,
+				`"description": "Underground train", "mass": 100, "power": 400, "maxTractiveEffort": 80, "davisA": 2, "davisB": 0.02, "davisC": 0.004,`, 1)
+			data = strings.Replace(data, `"description": "Underground double unit",`, `"description": "Underground double unit", "mass": 1,`, 1)
+			var dynSim Simulation
+			So(json.Unmarshal([]byte(data), &dynSim), ShouldBeNil)
+			tt2 := dynSim.TrainTypes["UT2"]
+			So(tt2.Mass, ShouldEqual, 200)
+			So(tt2.Power, ShouldEqual, 800)
+			So(tt2.MaxTractiveEffort, ShouldEqual, 160)
+			So(tt2.DavisA, ShouldEqual, 4)
+			So(tt2.DavisB, ShouldEqual, 0.04)
+			So(tt2.DavisC, ShouldEqual, 0.008)
+		})
 		Convey("Services should all be loaded", func() {
 			So(sim.Services, ShouldHaveLength, 3)
 			So(sim.Services, ShouldContainKey, "S001")
@@ -359,6 +386,12 @@ func TestSimulationLoading(t *testing.T) {
 				"inconsistent link at (90.000000, 0.000000) between 2 and 1",
 			})
 		})
+		Convey("Simulation with unknown signals in areas should fail loading", func() {
+			data := strings.Replace(string(loadSim("testdata/demo.json")), `"signals": []`, `"signals": ["99"]`, 1)
+			err := json.Unmarshal([]byte(data), &sim)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "error initializing area RGT: unknown signal: 99")
+		})
 		Convey("Simulation with wrong routes should fail loading", func() {
 			data, _ := ioutil.ReadFile("testdata/badroutes.json")
 			err := json.Unmarshal(data, &sim)
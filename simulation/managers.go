@@ -0,0 +1,326 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// A StatefulManager is a manager that holds a state of its own which must be
+// saved in the snapshots of the simulation, such as the failures of points.
+//
+// Line, points, signal and ARS managers may optionally implement this interface.
+type StatefulManager interface {
+	// SaveState returns the state of the manager for the given simulation.
+	SaveState(*Simulation) (json.RawMessage, error)
+	// RestoreState restores the state of the manager for the given simulation
+	// from data returned by SaveState.
+	RestoreState(*Simulation, json.RawMessage) error
+}
+
+// A ManagerFactory is a manager that holds a state of its own.
+//
+// The managers registered with the package level Register functions are
+// shared by all the simulations, unless they implement ManagerFactory. In
+// this case, each simulation uses the manager returned by NewInstance, which
+// must implement the same manager interface.
+type ManagerFactory interface {
+	NewInstance() interface{}
+}
+
+// managers holds the managers a simulation delegates to.
+//
+// Managers may be registered by other goroutines than the simulation loop,
+// so that they must be read with the accessors below.
+type managers struct {
+	managersMutex       sync.RWMutex
+	routesManagers      []RoutesManager
+	trainsManagers      map[string]TrainsManager
+	defaultTrainManager TrainsManager
+	lineItemManager     LineItemManager
+	pointsItemManager   PointsItemManager
+	signalItemManager   SignalItemManager
+	arsManager          ARSManager
+}
+
+// instance returns the manager to use for a new simulation in place of m.
+func instance(m interface{}) interface{} {
+	if mf, ok := m.(ManagerFactory); ok {
+		return mf.NewInstance()
+	}
+	return m
+}
+
+// instantiateFrom sets the managers of a new simulation, created from the
+// managers of m.
+func (res *managers) instantiateFrom(m *managers) {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	res.managersMutex.Lock()
+	defer res.managersMutex.Unlock()
+	res.routesManagers = make([]RoutesManager, len(m.routesManagers))
+	for i, rm := range m.routesManagers {
+		res.routesManagers[i] = instance(rm).(RoutesManager)
+	}
+	res.trainsManagers = nil
+	res.defaultTrainManager = nil
+	if m.trainsManagers != nil {
+		res.trainsManagers = make(map[string]TrainsManager)
+		for code, tm := range m.trainsManagers {
+			res.trainsManagers[code] = instance(tm).(TrainsManager)
+		}
+		res.defaultTrainManager = res.trainsManagers[m.defaultTrainManager.Code()]
+	}
+	res.lineItemManager, _ = instance(m.lineItemManager).(LineItemManager)
+	res.pointsItemManager, _ = instance(m.pointsItemManager).(PointsItemManager)
+	res.signalItemManager, _ = instance(m.signalItemManager).(SignalItemManager)
+	res.arsManager, _ = instance(m.arsManager).(ARSManager)
+}
+
+// routesManagerList returns the registered routes managers.
+func (m *managers) routesManagerList() []RoutesManager {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	return m.routesManagers
+}
+
+// trainsManagerOf returns the trains manager registered with the given code.
+func (m *managers) trainsManagerOf(code string) (TrainsManager, bool) {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	tm, ok := m.trainsManagers[code]
+	return tm, ok
+}
+
+// defaultTrainsManager returns the trains manager of the trains that have not
+// been set to a specific one.
+func (m *managers) defaultTrainsManager() TrainsManager {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	return m.defaultTrainManager
+}
+
+// currentLineItemManager returns the registered line manager.
+func (m *managers) currentLineItemManager() LineItemManager {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	return m.lineItemManager
+}
+
+// currentPointsItemManager returns the registered points manager.
+func (m *managers) currentPointsItemManager() PointsItemManager {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	return m.pointsItemManager
+}
+
+// currentSignalItemManager returns the registered signals manager.
+func (m *managers) currentSignalItemManager() SignalItemManager {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	return m.signalItemManager
+}
+
+// currentARSManager returns the registered ARS manager.
+func (m *managers) currentARSManager() ARSManager {
+	m.managersMutex.RLock()
+	defer m.managersMutex.RUnlock()
+	return m.arsManager
+}
+
+func (m *managers) registerRoutesManager(rm RoutesManager) {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	// The slice is always copied, so that routesManagerList results are never modified
+	n := len(m.routesManagers)
+	m.routesManagers = append(m.routesManagers[:n:n], rm)
+}
+
+func (m *managers) unregisterRoutesManager(rm RoutesManager) {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	for i, r := range m.routesManagers {
+		if r == rm {
+			m.routesManagers = append(m.routesManagers[:i:i], m.routesManagers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *managers) registerTrainsManager(tm TrainsManager) {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	if m.trainsManagers == nil {
+		m.trainsManagers = make(map[string]TrainsManager)
+		m.defaultTrainManager = tm
+	}
+	m.trainsManagers[tm.Code()] = tm
+}
+
+func (m *managers) unregisterTrainsManager(tm TrainsManager) {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	if m.trainsManagers[tm.Code()] == tm {
+		delete(m.trainsManagers, tm.Code())
+	}
+}
+
+func (m *managers) registerLineItemManager(lim LineItemManager) LineItemManager {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	previous := m.lineItemManager
+	m.lineItemManager = lim
+	return previous
+}
+
+func (m *managers) registerPointsItemManager(pim PointsItemManager) PointsItemManager {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	previous := m.pointsItemManager
+	m.pointsItemManager = pim
+	return previous
+}
+
+func (m *managers) registerSignalItemManager(sim SignalItemManager) SignalItemManager {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	previous := m.signalItemManager
+	m.signalItemManager = sim
+	return previous
+}
+
+func (m *managers) registerARSManager(am ARSManager) {
+	m.managersMutex.Lock()
+	defer m.managersMutex.Unlock()
+	m.arsManager = am
+}
+
+// RegisterRoutesManager registers the given route manager for the simulations
+// loaded afterwards.
+//
+// When several routes managers are registered, all of them are called in turn.
+// If all of them respond true, then the response is true. If one responds false,
+// the response is false.
+func RegisterRoutesManager(rm RoutesManager) {
+	registeredManagers.registerRoutesManager(rm)
+}
+
+// UnregisterRoutesManager removes the given route manager from the simulations
+// loaded afterwards.
+func UnregisterRoutesManager(rm RoutesManager) {
+	registeredManagers.unregisterRoutesManager(rm)
+}
+
+// RegisterTrainsManager registers the given trains manager for the simulations
+// loaded afterwards.
+//
+// There can be several trains managers registered, but each train will use only one.
+// If a train has not been explicitly set to a trains manager, it will use the default
+// one. Default trains manager is the first registered manager.
+func RegisterTrainsManager(tm TrainsManager) {
+	registeredManagers.registerTrainsManager(tm)
+}
+
+// UnregisterTrainsManager removes the given trains manager from the
+// simulations loaded afterwards.
+func UnregisterTrainsManager(tm TrainsManager) {
+	registeredManagers.unregisterTrainsManager(tm)
+}
+
+// RegisterLineItemManager registers the given line manager for the
+// simulations loaded afterwards.
+//
+// If a line manager was already registered, it is replaced by lim and returned.
+func RegisterLineItemManager(lim LineItemManager) LineItemManager {
+	return registeredManagers.registerLineItemManager(lim)
+}
+
+// RegisterPointsItemManager registers the given points manager for the
+// simulations loaded afterwards.
+//
+// If a points manager was already registered, it is replaced by pim and returned.
+func RegisterPointsItemManager(pim PointsItemManager) PointsItemManager {
+	return registeredManagers.registerPointsItemManager(pim)
+}
+
+// RegisterSignalItemManager registers the signal manager for the simulations
+// loaded afterwards.
+//
+// If a signals manager was already registered, it is replaced by sim and returned.
+func RegisterSignalItemManager(sim SignalItemManager) SignalItemManager {
+	return registeredManagers.registerSignalItemManager(sim)
+}
+
+// RegisterARSManager registers the automatic route setting manager for the
+// simulations loaded afterwards.
+//
+// If an ARS manager was already registered, it is replaced by am.
+func RegisterARSManager(am ARSManager) {
+	registeredManagers.registerARSManager(am)
+}
+
+// RegisterRoutesManager registers the given route manager in this simulation
+// only.
+func (sim *Simulation) RegisterRoutesManager(rm RoutesManager) {
+	sim.registerRoutesManager(rm)
+}
+
+// UnregisterRoutesManager removes the given route manager from this simulation.
+func (sim *Simulation) UnregisterRoutesManager(rm RoutesManager) {
+	sim.unregisterRoutesManager(rm)
+}
+
+// RegisterTrainsManager registers the given trains manager in this simulation
+// only.
+func (sim *Simulation) RegisterTrainsManager(tm TrainsManager) {
+	sim.registerTrainsManager(tm)
+}
+
+// UnregisterTrainsManager removes the given trains manager from this
+// simulation.
+//
+// Trains using this manager go back to the default trains manager.
+func (sim *Simulation) UnregisterTrainsManager(tm TrainsManager) {
+	sim.unregisterTrainsManager(tm)
+}
+
+// RegisterLineItemManager registers the given line manager in this simulation
+// only.
+//
+// The line manager of the simulation is replaced by lim and returned.
+func (sim *Simulation) RegisterLineItemManager(lim LineItemManager) LineItemManager {
+	return sim.registerLineItemManager(lim)
+}
+
+// RegisterPointsItemManager registers the given points manager in this
+// simulation only.
+//
+// The points manager of the simulation is replaced by pim and returned.
+func (sim *Simulation) RegisterPointsItemManager(pim PointsItemManager) PointsItemManager {
+	return sim.registerPointsItemManager(pim)
+}
+
+// RegisterSignalItemManager registers the given signals manager in this
+// simulation only.
+//
+// The signals manager of the simulation is replaced by sm and returned.
+func (sim *Simulation) RegisterSignalItemManager(sm SignalItemManager) SignalItemManager {
+	return sim.registerSignalItemManager(sm)
+}
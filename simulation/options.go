@@ -41,6 +41,15 @@ type Options struct {
 	WrongPlatformPenalty    int            `json:"wrongPlatformPenalty"`
 	WrongDestinationPenalty int            `json:"wrongDestinationPenalty"`
 	LatePenalty             int            `json:"latePenalty"`
+	Seed                    int64          `json:"seed"`
+	PointsTransitTime       DelayGenerator `json:"pointsTransitTime"`
+	PointsFailureChance     float64        `json:"pointsFailureChance"`
+	PointsRepairTime        DelayGenerator `json:"pointsRepairTime"`
+	TrackCircuitFailureRate float64        `json:"trackCircuitFailureRate"`
+	TrackCircuitRepairTime  DelayGenerator `json:"trackCircuitRepairTime"`
+	SignalFailureRate       float64        `json:"signalFailureRate"`
+	SignalRepairTime        DelayGenerator `json:"signalRepairTime"`
+	ApproachLockingTime     DelayGenerator `json:"approachLockingTime"`
 
 	simulation *Simulation
 }
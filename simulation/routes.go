@@ -21,6 +21,8 @@ package simulation
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"time"
 )
 
 // A RoutesManager checks if a route is activable or deactivable.
@@ -49,8 +51,35 @@ const (
 
 	// Destroying = The route is currently being destroyed by a train
 	Destroying RouteState = 3
+
+	// Cancelling = The route has been cancelled in front of an approaching
+	// train and is still locked until its approach locking is released
+	Cancelling RouteState = 4
 )
 
+// approachLockingMaxDistance is the maximum distance before the begin signal
+// of a route at which trains are looked for when the route is cancelled.
+const approachLockingMaxDistance float64 = 5000
+
+// overlapStopDistance is the maximum distance between the head of a stopped
+// train and the end signal of a route for the train to be considered stopped
+// at this signal.
+const overlapStopDistance float64 = 50
+
+// An Overlap is the safety margin beyond the end signal of a Route.
+//
+// The items of the overlap are locked when the route is activated, so that no
+// conflicting route can be set where a train might overrun the end signal.
+// The overlap is released ReleaseDelay seconds after a train has stopped at
+// the end signal, when a train proceeds on a route set from the end signal or
+// when the route is deactivated.
+type Overlap struct {
+	Items        []string                  `json:"items"`
+	Directions   map[string]PointDirection `json:"directions"`
+	ReleaseDelay int                       `json:"releaseDelay"`
+	Positions    []Position                `json:"-"`
+}
+
 // A Route is a path between two signals.
 //
 // If a route is Activated, the path is selected, and the signals at the beginning
@@ -64,10 +93,16 @@ type Route struct {
 	InitialState  RouteState                `json:"initialState"`
 	Directions    map[string]PointDirection `json:"directions"`
 	Persistent    bool                      `json:"persistent"`
+	Overlap       *Overlap                  `json:"overlap"`
 	Positions     []Position                `json:"-"`
 
-	simulation *Simulation
-	triggers   []func(*Route)
+	simulation         *Simulation
+	triggers           []func(*Route)
+	overlapLocked      bool
+	overlapReleaseTime time.Time
+	cancelling         bool
+	cancelTime         time.Time
+	cancelTrain        *Train
 }
 
 // ID returns the unique identifier of this route
@@ -93,6 +128,9 @@ func (r *Route) Equals(other *Route) bool {
 
 // State returns the current state of this route
 func (r *Route) State() RouteState {
+	if r.cancelling {
+		return Cancelling
+	}
 	if r.BeginSignal().nextActiveRoute == nil || !r.BeginSignal().nextActiveRoute.Equals(r) {
 		for _, p := range r.Positions {
 			if p.TrackItem().ActiveRoute() != nil && p.TrackItem().ActiveRoute().Equals(r) {
@@ -112,15 +150,144 @@ func (r *Route) IsActive() bool {
 	return r.State() == Activated || r.State() == Persistent
 }
 
+// pointsInPosition returns true if all the points of this route and of its
+// locked overlap report the direction required by the route.
+func (r *Route) pointsInPosition() bool {
+	for _, pos := range r.Positions {
+		pi, ok := pos.TrackItem().(*PointsItem)
+		if !ok {
+			continue
+		}
+		if r.simulation.currentPointsItemManager().Direction(pi) != r.Directions[pi.ID()] {
+			return false
+		}
+	}
+	if !r.overlapLocked {
+		return true
+	}
+	for _, pos := range r.Overlap.Positions {
+		pi, ok := pos.TrackItem().(*PointsItem)
+		if !ok {
+			continue
+		}
+		if r.simulation.currentPointsItemManager().Direction(pi) != r.Overlap.Directions[pi.ID()] {
+			return false
+		}
+	}
+	return true
+}
+
+// OverlapLocked returns true if the overlap of this route is locked
+func (r *Route) OverlapLocked() bool {
+	return r.overlapLocked
+}
+
+// lockOverlap locks the items of the overlap of this route, if any.
+func (r *Route) lockOverlap() {
+	if r.Overlap == nil {
+		return
+	}
+	for _, pos := range r.Overlap.Positions {
+		pos.TrackItem().lockOverlap(r)
+	}
+	r.overlapLocked = true
+	r.overlapReleaseTime = time.Time{}
+}
+
+// releaseOverlap releases the items of the overlap of this route.
+func (r *Route) releaseOverlap() {
+	if !r.overlapLocked {
+		return
+	}
+	for _, pos := range r.Overlap.Positions {
+		pos.TrackItem().releaseOverlap(r)
+	}
+	r.overlapLocked = false
+	r.overlapReleaseTime = time.Time{}
+}
+
+// updateOverlap releases the overlap of this route once its release delay has
+// elapsed after a train stopped at the end signal, or as soon as a train
+// proceeds beyond the end signal on the next route.
+func (r *Route) updateOverlap() {
+	if !r.overlapLocked {
+		return
+	}
+	if r.EndSignal().nextActiveRoute != nil && r.Overlap.Positions[0].TrackItem().TrainPresent() {
+		r.releaseOverlap()
+		return
+	}
+	now := r.simulation.Options.CurrentTime.Time
+	if r.overlapReleaseTime.IsZero() {
+		train, _ := r.EndSignal().approachingTrain(overlapStopDistance)
+		if train == nil || train.Speed > 0 {
+			return
+		}
+		r.overlapReleaseTime = now.Add(time.Duration(r.Overlap.ReleaseDelay) * time.Second)
+	}
+	if now.Before(r.overlapReleaseTime) {
+		return
+	}
+	r.releaseOverlap()
+}
+
+// CancelTime returns the time at which the approach locking of this route will
+// be released if it is being cancelled, or the zero time otherwise.
+func (r *Route) CancelTime() time.Time {
+	return r.cancelTime
+}
+
+// committedTrain returns the train committed to this route, that is the
+// train the head of which is within the sighting or braking distance of the
+// begin signal of this active route, or nil if there is none.
+func (r *Route) committedTrain() *Train {
+	if !r.IsActive() || r.simulation.Options.ApproachLockingTime.IsNull() {
+		return nil
+	}
+	train, distance := r.BeginSignal().approachingTrain(approachLockingMaxDistance)
+	if train == nil {
+		return nil
+	}
+	if distance <= r.simulation.Options.DefaultSignalVisibility {
+		return train
+	}
+	if distance <= math.Pow(train.Speed, 2)/(2*train.TrainType().StdBraking) {
+		return train
+	}
+	return nil
+}
+
+// CancelTrain returns the train which was committed to this route when it was
+// cancelled, or nil if the route is not being cancelled.
+func (r *Route) CancelTrain() *Train {
+	return r.cancelTrain
+}
+
+// updateCancellation releases this route if it is being cancelled and either
+// its approach locking time has elapsed or the train which was committed to
+// it when it was cancelled has stopped.
+func (r *Route) updateCancellation() {
+	if !r.cancelling {
+		return
+	}
+	if !r.simulation.Options.CurrentTime.Time.Before(r.cancelTime) {
+		r.release()
+		return
+	}
+	if r.cancelTrain.Speed == 0 {
+		r.release()
+	}
+}
+
 // addTrigger adds the given function to the list of function that will be
-// called when this Route is activated or deactivated.
+// called when this Route is activated, cancelled or deactivated.
 func (r *Route) addTrigger(trigger func(*Route)) {
 	r.triggers = append(r.triggers, trigger)
 }
 
 // Activate the given route. If the route cannot be Activated, an error is returned.
 func (r *Route) Activate(persistent bool) error {
-	for _, rm := range routesManagers {
+	for _, rm := range r.simulation.routesManagerList() {
 		if err := rm.CanActivate(r); err != nil {
 			return fmt.Errorf("%s vetoed route activation: %s", rm.Name(), err)
 		}
@@ -131,6 +298,10 @@ func (r *Route) Activate(persistent bool) error {
 		}
 		pos.TrackItem().setActiveRoute(r, pos.PreviousItem())
 	}
+	r.cancelling = false
+	r.cancelTime = time.Time{}
+	r.cancelTrain = nil
+	r.lockOverlap()
 	r.EndSignal().previousActiveRoute = r
 	r.BeginSignal().nextActiveRoute = r
 	r.Persistent = persistent
@@ -146,12 +317,48 @@ func (r *Route) Activate(persistent bool) error {
 }
 
 // Deactivate the given route. If the route cannot be Deactivated, an error is returned.
+//
+// If a train is approaching the begin signal of the route, the signal is set
+// at danger but the route is only released after the approach locking time
+// or when the train has stopped. Meanwhile, its state is Cancelling.
 func (r *Route) Deactivate() error {
-	for _, rm := range routesManagers {
-		if rm.CanDeactivate(r) != nil {
-			return fmt.Errorf("%s vetoed route deactivation", rm.Name())
+	for _, rm := range r.simulation.routesManagerList() {
+		if err := rm.CanDeactivate(r); err != nil {
+			return fmt.Errorf("%s vetoed route deactivation: %s", rm.Name(), err)
 		}
 	}
+	if train := r.committedTrain(); train != nil {
+		r.cancel(train)
+		return nil
+	}
+	r.release()
+	return nil
+}
+
+// cancel sets the begin signal of this route at danger and starts the
+// approach locking timer after which the route will be released, unless the
+// given committed train stops before.
+func (r *Route) cancel(train *Train) {
+	sim := r.simulation
+	r.cancelling = true
+	r.cancelTime = sim.Options.CurrentTime.Time.Add(sim.Options.ApproachLockingTime.YieldFrom(sim.Rand()))
+	r.cancelTrain = train
+	r.BeginSignal().resetNextActiveRoute(r)
+	for _, t := range r.triggers {
+		t(r)
+	}
+	sim.sendEvent(&Event{
+		Name:   RouteCancellingEvent,
+		Object: r,
+	})
+}
+
+// release the items of this route and its overlap and notify the
+// deactivation of the route.
+func (r *Route) release() {
+	r.cancelling = false
+	r.cancelTime = time.Time{}
+	r.cancelTrain = nil
 	r.BeginSignal().resetNextActiveRoute(r)
 	r.EndSignal().resetPreviousActiveRoute(nil)
 	for _, pos := range r.Positions {
@@ -160,6 +367,7 @@ func (r *Route) Deactivate() error {
 		}
 		pos.TrackItem().setActiveRoute(nil, nil)
 	}
+	r.releaseOverlap()
 	for _, t := range r.triggers {
 		t(r)
 	}
@@ -168,7 +376,6 @@ func (r *Route) Deactivate() error {
 		Object: r,
 	})
 	r.BeginSignal().updateSignalState()
-	return nil
 }
 
 // setSimulation sets the Simulation this Route is part of.
@@ -190,6 +397,13 @@ func (r *Route) initialize(routeNum string) error {
 	for !pos.IsOut() {
 		r.Positions = append(r.Positions, pos)
 		if pos.TrackItem().ID() == r.EndSignal().ID() {
+			if err := r.initializeOverlap(pos); err != nil {
+				return err
+			}
+			if r.simulation.snapshot != nil {
+				// State will be restored from the snapshot
+				return nil
+			}
 			// Initialize state to initial state
 			switch r.InitialState {
 			case Persistent:
@@ -199,28 +413,71 @@ func (r *Route) initialize(routeNum string) error {
 			}
 			return nil
 		}
-		dir := DirectionCurrent
-		if pi, ok := pos.TrackItem().(*PointsItem); ok {
-			dir, ok = r.Directions[pi.ID()]
-			if !ok {
-				switch pos.PreviousItemID {
-				case pi.ReverseTiId:
-					dir = DirectionReversed
-				case pi.PreviousTiID, pi.NextTiID:
-					dir = DirectionNormal
-				default:
-					return fmt.Errorf("route Error: unable to find direction for points %s", pi.ID())
-				}
-				r.Directions[pi.ID()] = dir
-			}
+		dir, err := pointsDirection(pos, r.Directions)
+		if err != nil {
+			return err
 		}
-
 		pos = pos.Next(dir)
 	}
 
 	return fmt.Errorf("route Error: unable to link signal %s to signal %s", r.BeginSignalId, r.EndSignalId)
 }
 
+// initializeOverlap populates the positions of the overlap of this route, if
+// any, starting from the given position of the end signal.
+func (r *Route) initializeOverlap(pos Position) error {
+	if r.Overlap == nil {
+		return nil
+	}
+	if r.Overlap.Directions == nil {
+		r.Overlap.Directions = make(map[string]PointDirection)
+	}
+	r.Overlap.Positions = nil
+	for _, id := range r.Overlap.Items {
+		dir, err := pointsDirection(pos, r.Overlap.Directions)
+		if err != nil {
+			return err
+		}
+		previousID := pos.TrackItemID
+		pos = pos.Next(dir)
+		if pos.IsOut() || pos.TrackItemID != id {
+			return fmt.Errorf("route Error: overlap item %s of route %s does not follow item %s", id, r.routeID, previousID)
+		}
+		r.Overlap.Positions = append(r.Overlap.Positions, pos)
+	}
+	if len(r.Overlap.Positions) == 0 {
+		return fmt.Errorf("route Error: overlap of route %s has no items", r.routeID)
+	}
+	return nil
+}
+
+// pointsDirection returns the direction in which to leave the item of the
+// given position, as found in directions if it is a PointsItem.
+//
+// If the points are not in directions, they are taken in the direction
+// required to come from the previous item of pos, which is then added to
+// directions.
+func pointsDirection(pos Position, directions map[string]PointDirection) (PointDirection, error) {
+	pi, ok := pos.TrackItem().(*PointsItem)
+	if !ok {
+		return DirectionCurrent, nil
+	}
+	dir, ok := directions[pi.ID()]
+	if ok {
+		return dir, nil
+	}
+	switch pos.PreviousItemID {
+	case pi.ReverseTiId:
+		dir = DirectionReversed
+	case pi.PreviousTiID, pi.NextTiID:
+		dir = DirectionNormal
+	default:
+		return DirectionCurrent, fmt.Errorf("route Error: unable to find direction for points %s", pi.ID())
+	}
+	directions[pi.ID()] = dir
+	return dir, nil
+}
+
 // UnmarshalJSON for the Route type
 func (r *Route) UnmarshalJSON(data []byte) error {
 	type auxRoute struct {
@@ -228,6 +485,7 @@ func (r *Route) UnmarshalJSON(data []byte) error {
 		EndSignalId   string                    `json:"endSignal"`
 		InitialState  RouteState                `json:"initialState"`
 		Directions    map[string]PointDirection `json:"directions"`
+		Overlap       *Overlap                  `json:"overlap"`
 	}
 	var rawRoute auxRoute
 	if err := json.Unmarshal(data, &rawRoute); err != nil {
@@ -240,6 +498,7 @@ func (r *Route) UnmarshalJSON(data []byte) error {
 	for tiID, dir := range rawRoute.Directions {
 		r.Directions[tiID] = dir
 	}
+	r.Overlap = rawRoute.Overlap
 	return nil
 }
 
@@ -251,7 +510,10 @@ func (r *Route) MarshalJSON() ([]byte, error) {
 		EndSignalId   string                    `json:"endSignal"`
 		InitialState  RouteState                `json:"initialState"`
 		Directions    map[string]PointDirection `json:"directions"`
+		Overlap       *Overlap                  `json:"overlap,omitempty"`
 		State         RouteState                `json:"state"`
+		OverlapLocked bool                      `json:"overlapLocked,omitempty"`
+		CancelTime    string                    `json:"cancelTime,omitempty"`
 	}
 	ar := auxRoute{
 		ID:            r.ID(),
@@ -259,7 +521,12 @@ func (r *Route) MarshalJSON() ([]byte, error) {
 		EndSignalId:   r.EndSignalId,
 		InitialState:  r.InitialState,
 		Directions:    r.Directions,
+		Overlap:       r.Overlap,
 		State:         r.State(),
+		OverlapLocked: r.overlapLocked,
+	}
+	if r.cancelling {
+		ar.CancelTime = r.cancelTime.Format("15:04:05")
 	}
 	d, err := json.Marshal(ar)
 	return d, err
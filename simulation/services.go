@@ -30,7 +30,8 @@ const (
 	actionSetService serviceActionCode = "SET_SERVICE"
 
 	// actionSplit the train at the given position. ActionParam is the element after
-	// which to split (integer).
+	// which to split (integer), optionally followed by a comma and the code of the
+	// service to assign to the rear portion (e.g. "1,S002").
 	actionSplit serviceActionCode = "SPLIT"
 
 	// actionJoin the train. ActionParam is 'ahead' if to join with the train in
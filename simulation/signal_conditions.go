@@ -20,9 +20,66 @@ package simulation
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	signalConditionTypes  = make(map[string]ConditionType)
+	conditionDescriptions = make(map[string]string)
+	conditionTypesLoaded  bool
+	conditionTypesMutex   sync.RWMutex
+)
+
+// ConditionTypeInfo describes a registered ConditionType
+type ConditionTypeInfo struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// RegisterConditionType makes the given ConditionType available to signal
+// libraries under its code, with the given description.
+//
+// Condition types must be registered before any simulation is loaded,
+// typically in the init function of a plugin. RegisterConditionType panics if
+// a ConditionType with the same code is already registered or if signal
+// states have already been loaded.
+func RegisterConditionType(ct ConditionType, description string) {
+	conditionTypesMutex.Lock()
+	defer conditionTypesMutex.Unlock()
+	if conditionTypesLoaded {
+		panic(fmt.Errorf("condition type %s registered after signal states were loaded", ct.Code()))
+	}
+	if ct.Code() == "" {
+		panic(fmt.Errorf("condition type has no code"))
+	}
+	if _, ok := signalConditionTypes[ct.Code()]; ok {
+		panic(fmt.Errorf("condition type %s registered twice", ct.Code()))
+	}
+	signalConditionTypes[ct.Code()] = ct
+	conditionDescriptions[ct.Code()] = description
+}
+
+// ConditionTypes returns the registered condition types, sorted by code.
+func ConditionTypes() []ConditionTypeInfo {
+	conditionTypesMutex.RLock()
+	defer conditionTypesMutex.RUnlock()
+	res := make([]ConditionTypeInfo, 0, len(signalConditionTypes))
+	for code := range signalConditionTypes {
+		res = append(res, ConditionTypeInfo{
+			Code:        code,
+			Description: conditionDescriptions[code],
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Code < res[j].Code
+	})
+	return res
+}
+
 // nextActiveRoute is true if a route starting from this Signal is active
 type NextActiveRoute struct{}
 
@@ -342,26 +399,131 @@ func (resa RouteExitSignalAspects) SetupTriggers(item *SignalItem, params []stri
 
 // ---------------------------------------------------------------------------------------------------------------
 
+// floatValue returns the i-th value of a condition as a float64. It returns
+// false if there is no such value or if it is not a number.
+func floatValue(values []string, i int) (float64, bool) {
+	if i >= len(values) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(values[i], 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// ---------------------------------------------------------------------------------------------------------------
+
+// TrainApproachingWithin is true if the head of a train is behind this signal, heading towards it, at a distance
+// of this signal less than or equal to the value given in meters.
+type TrainApproachingWithin struct{}
+
+// Code of the ConditionType, uniquely defines this ConditionType
+func (taw TrainApproachingWithin) Code() string {
+	return "TRAIN_APPROACHING_WITHIN"
+}
+
+// Solve returns if the condition is met for the given SignalItem and parameters
+func (taw TrainApproachingWithin) Solve(item *SignalItem, values []string, params []string) bool {
+	distance, ok := floatValue(values, 0)
+	if !ok {
+		return false
+	}
+	train, _ := item.approachingTrain(distance)
+	return train != nil
+}
+
+// SetupTriggers installs needed triggers for the given SignalItem, with the
+// given Condition.
+func (taw TrainApproachingWithin) SetupTriggers(item *SignalItem, params []string) {
+	item.UpdateStateEachStep()
+}
+
+// ---------------------------------------------------------------------------------------------------------------
+
+// TrainSpeedBelow is true if the nearest train approaching this signal within the distance given as second value
+// in meters has a speed below the first value in meters per second.
+// If no train is approaching this signal within this distance, the condition is false.
+type TrainSpeedBelow struct{}
+
+// Code of the ConditionType, uniquely defines this ConditionType
+func (tsb TrainSpeedBelow) Code() string {
+	return "TRAIN_SPEED_BELOW"
+}
+
+// Solve returns if the condition is met for the given SignalItem and parameters
+func (tsb TrainSpeedBelow) Solve(item *SignalItem, values []string, params []string) bool {
+	speed, ok := floatValue(values, 0)
+	if !ok {
+		return false
+	}
+	distance, ok := floatValue(values, 1)
+	if !ok {
+		return false
+	}
+	train, _ := item.approachingTrain(distance)
+	return train != nil && train.Speed < speed
+}
+
+// SetupTriggers installs needed triggers for the given SignalItem, with the
+// given Condition.
+func (tsb TrainSpeedBelow) SetupTriggers(item *SignalItem, params []string) {
+	item.UpdateStateEachStep()
+}
+
+// ---------------------------------------------------------------------------------------------------------------
+
+// TimeElapsedSinceOccupied is true if all the track items defined by custom property have been continuously
+// occupied by trains for at least the number of seconds given as value.
+type TimeElapsedSinceOccupied struct{}
+
+// Code of the ConditionType, uniquely defines this ConditionType
+func (teso TimeElapsedSinceOccupied) Code() string {
+	return "TIME_ELAPSED_SINCE_OCCUPIED"
+}
+
+// Solve returns if the condition is met for the given SignalItem and parameters
+func (teso TimeElapsedSinceOccupied) Solve(item *SignalItem, values []string, params []string) bool {
+	seconds, ok := floatValue(values, 0)
+	if !ok || len(params) == 0 {
+		return false
+	}
+	now := item.Simulation().Options.CurrentTime.Time
+	for _, id := range params {
+		since := item.Simulation().TrackItems[id].underlying().occupiedSince
+		if since.IsZero() || now.Sub(since) < time.Duration(seconds*float64(time.Second)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetupTriggers installs needed triggers for the given SignalItem, with the
+// given Condition.
+func (teso TimeElapsedSinceOccupied) SetupTriggers(item *SignalItem, params []string) {
+	for _, id := range params {
+		if _, ok := item.Simulation().TrackItems[id]; !ok {
+			panic(fmt.Errorf("TimeElapsedSinceOccupied: error in simulation definition.\n"+
+				"SignalItem %s reference unknown TrackItem %s", item.ID(), id))
+		}
+	}
+	item.UpdateStateEachStep()
+}
+
+// ---------------------------------------------------------------------------------------------------------------
+
 func init() {
-	signalConditionTypes = make(map[string]ConditionType)
-	nar := NextActiveRoute{}
-	signalConditionTypes[nar.Code()] = nar
-	par := PreviousActiveRoute{}
-	signalConditionTypes[par.Code()] = par
-	rsa := RouteSetAcross{}
-	signalConditionTypes[rsa.Code()] = rsa
-	tnponr := TrainNotPresentOnNextRoute{}
-	signalConditionTypes[tnponr.Code()] = tnponr
-	tnpbns := TrainNotPresentBeforeNextSignal{}
-	signalConditionTypes[tnpbns.Code()] = tnpbns
-	tnponi := TrainNotPresentOnItems{}
-	signalConditionTypes[tnponi.Code()] = tnponi
-	tpoi := TrainPresentOnItems{}
-	signalConditionTypes[tpoi.Code()] = tpoi
-	rs := RouteSet{}
-	signalConditionTypes[rs.Code()] = rs
-	nsa := NextSignalAspects{}
-	signalConditionTypes[nsa.Code()] = nsa
-	resa := RouteExitSignalAspects{}
-	signalConditionTypes[resa.Code()] = resa
+	RegisterConditionType(NextActiveRoute{}, "Met if a route is set starting from this signal.")
+	RegisterConditionType(PreviousActiveRoute{}, "Met if a route is set ending at this signal.")
+	RegisterConditionType(RouteSetAcross{}, "Met if a route is active across this signal, in the same direction but neither starting nor ending at this signal.")
+	RegisterConditionType(TrainNotPresentOnNextRoute{}, "Met if a route is active starting from this signal and no trains are present on this route.")
+	RegisterConditionType(TrainNotPresentBeforeNextSignal{}, "Met if no trains are found between this signal and the next signal on the line, ignoring signals showing the given aspects suffixed by '!'.")
+	RegisterConditionType(TrainNotPresentOnItems{}, "Met if none of the items defined in the signal's custom properties have a train on them.")
+	RegisterConditionType(TrainPresentOnItems{}, "Met if all of the items defined in the signal's custom properties have a train on them.")
+	RegisterConditionType(RouteSet{}, "Met if at least one of the routes defined in the signal's custom properties is active.")
+	RegisterConditionType(NextSignalAspects{}, "Met if the next signal shows one of the given aspects.")
+	RegisterConditionType(RouteExitSignalAspects{}, "Met if the exit signal of the route starting at this signal shows one of the given aspects.")
+	RegisterConditionType(TrainApproachingWithin{}, "Met if the head of a train approaching this signal is within the given distance in meters.")
+	RegisterConditionType(TrainSpeedBelow{}, "Met if the nearest train approaching this signal within the distance given as second value in meters is slower than the speed given as first value in meters per second.")
+	RegisterConditionType(TimeElapsedSinceOccupied{}, "Met if all of the items defined in the signal's custom properties have been occupied for at least the given number of seconds.")
 }
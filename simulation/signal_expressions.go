@@ -0,0 +1,328 @@
+// Copyright (C) 2008-2019 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A ConditionOperator is the operator of a node of a ConditionExpression
+type ConditionOperator int
+
+const (
+	// OperatorCondition nodes are leaves holding a single Condition
+	OperatorCondition ConditionOperator = iota
+	// OperatorAnd nodes are met if all their operands are met
+	OperatorAnd
+	// OperatorOr nodes are met if at least one of their operands is met
+	OperatorOr
+	// OperatorNot nodes are met if their single operand is not met
+	OperatorNot
+)
+
+// A ConditionExpression is a boolean expression of conditions defining when a
+// signal state is displayed.
+//
+// Expressions are written in signal libraries with the following syntax:
+//
+//	(ROUTES_SET[ROUTES_A] OR ROUTES_SET[ROUTES_B]) AND NOT TRAIN_PRESENT_ON_ITEMS
+//	NEXT_ROUTE_ACTIVE AND NEXT_SIGNAL_ASPECTS(UK_CLEAR, UK_CAUTION)
+//
+// Conditions are given by their code, optionally followed by the name of the
+// custom property of the signals holding their parameters between brackets
+// and by their values between parentheses. AND has precedence over OR.
+type ConditionExpression struct {
+	Operator  ConditionOperator
+	Condition Condition
+	Operands  []*ConditionExpression
+}
+
+// IsMet returns true if this expression is met for the given SignalItem
+// displaying the given aspect.
+func (ce *ConditionExpression) IsMet(item *SignalItem, aspectName string) bool {
+	switch ce.Operator {
+	case OperatorAnd:
+		for _, op := range ce.Operands {
+			if !op.IsMet(item, aspectName) {
+				return false
+			}
+		}
+		return true
+	case OperatorOr:
+		for _, op := range ce.Operands {
+			if op.IsMet(item, aspectName) {
+				return true
+			}
+		}
+		return false
+	case OperatorNot:
+		return !ce.Operands[0].IsMet(item, aspectName)
+	default:
+		return ce.Condition.IsMet(item, ce.Condition.params(item, aspectName))
+	}
+}
+
+// conditions returns all the conditions of this expression
+func (ce *ConditionExpression) conditions() []Condition {
+	if ce.Operator == OperatorCondition {
+		return []Condition{ce.Condition}
+	}
+	var res []Condition
+	for _, op := range ce.Operands {
+		res = append(res, op.conditions()...)
+	}
+	return res
+}
+
+// String returns this expression with the syntax of signal libraries
+func (ce *ConditionExpression) String() string {
+	switch ce.Operator {
+	case OperatorAnd, OperatorOr:
+		sep := " AND "
+		if ce.Operator == OperatorOr {
+			sep = " OR "
+		}
+		ops := make([]string, len(ce.Operands))
+		for i, op := range ce.Operands {
+			ops[i] = op.String()
+			if ce.Operator == OperatorAnd && op.Operator == OperatorOr {
+				ops[i] = fmt.Sprintf("(%s)", ops[i])
+			}
+		}
+		return strings.Join(ops, sep)
+	case OperatorNot:
+		op := ce.Operands[0]
+		if op.Operator == OperatorAnd || op.Operator == OperatorOr {
+			return fmt.Sprintf("NOT (%s)", op)
+		}
+		return fmt.Sprintf("NOT %s", op)
+	default:
+		res := ce.Condition.Type.Code()
+		if ce.Condition.Property != "" {
+			res += fmt.Sprintf("[%s]", ce.Condition.Property)
+		}
+		if len(ce.Condition.Values) > 0 {
+			res += fmt.Sprintf("(%s)", strings.Join(ce.Condition.Values, ", "))
+		}
+		return res
+	}
+}
+
+// expressionParser parses condition expressions of signal libraries
+type expressionParser struct {
+	tokens []string
+	pos    int
+	types  map[string]ConditionType
+}
+
+// parseConditionExpression parses the given expression, looking up condition
+// codes in the given condition types.
+func parseConditionExpression(expr string, types map[string]ConditionType) (*ConditionExpression, error) {
+	p := expressionParser{
+		tokens: tokenizeExpression(expr),
+		types:  types,
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty condition expression")
+	}
+	res, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition expression %q: %s", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid condition expression %q: unexpected %s", expr, p.tokens[p.pos])
+	}
+	return res, nil
+}
+
+// tokenizeExpression splits the given expression into delimiters and words
+func tokenizeExpression(expr string) []string {
+	var (
+		tokens []string
+		word   strings.Builder
+	)
+	endWord := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range expr {
+		switch {
+		case unicode.IsSpace(r):
+			endWord()
+		case strings.ContainsRune("()[],", r):
+			endWord()
+			tokens = append(tokens, string(r))
+		default:
+			word.WriteRune(r)
+		}
+	}
+	endWord()
+	return tokens
+}
+
+// peek returns the current token, or an empty string at the end of the
+// expression.
+func (p *expressionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves to the next one
+func (p *expressionParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+// expect moves to the next token, which must be the given one
+func (p *expressionParser) expect(token string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t != token {
+		return fmt.Errorf("expected %s, got %s", token, t)
+	}
+	return nil
+}
+
+// word moves to the next token, which must not be a delimiter, and returns
+// it.
+func (p *expressionParser) word() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(t, "()[],") {
+		return "", fmt.Errorf("unexpected %s", t)
+	}
+	return t, nil
+}
+
+// isKeyword returns true if the given token is the given keyword
+func isKeyword(token, keyword string) bool {
+	return strings.EqualFold(token, keyword)
+}
+
+// parseOr parses operands separated by OR
+func (p *expressionParser) parseOr() (*ConditionExpression, error) {
+	return p.parseOperands(OperatorOr, "OR", p.parseAnd)
+}
+
+// parseAnd parses operands separated by AND
+func (p *expressionParser) parseAnd() (*ConditionExpression, error) {
+	return p.parseOperands(OperatorAnd, "AND", p.parseUnary)
+}
+
+// parseOperands parses operands with the given parse function, separated by
+// the given keyword. It returns the single operand itself if there is no
+// keyword.
+func (p *expressionParser) parseOperands(op ConditionOperator, keyword string, parse func() (*ConditionExpression, error)) (*ConditionExpression, error) {
+	first, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	res := &ConditionExpression{Operator: op, Operands: []*ConditionExpression{first}}
+	for isKeyword(p.peek(), keyword) {
+		p.pos++
+		operand, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		res.Operands = append(res.Operands, operand)
+	}
+	if len(res.Operands) == 1 {
+		return first, nil
+	}
+	return res, nil
+}
+
+// parseUnary parses a negation, a parenthesized expression or a condition
+func (p *expressionParser) parseUnary() (*ConditionExpression, error) {
+	token := p.peek()
+	switch {
+	case isKeyword(token, "NOT"):
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ConditionExpression{Operator: OperatorNot, Operands: []*ConditionExpression{operand}}, nil
+	case token == "(":
+		p.pos++
+		res, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return res, nil
+	default:
+		return p.parseCondition()
+	}
+}
+
+// parseCondition parses a condition code with its optional property and
+// values.
+func (p *expressionParser) parseCondition() (*ConditionExpression, error) {
+	code, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+	ct, ok := p.types[code]
+	if !ok {
+		return nil, fmt.Errorf("unknown condition type: %s", code)
+	}
+	cond := Condition{Type: ct}
+	if p.peek() == "[" {
+		p.pos++
+		if cond.Property, err = p.word(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek() == "(" {
+		p.pos++
+		for p.peek() != ")" {
+			if len(cond.Values) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			value, err := p.word()
+			if err != nil {
+				return nil, err
+			}
+			cond.Values = append(cond.Values, value)
+		}
+		p.pos++
+	}
+	return &ConditionExpression{Operator: OperatorCondition, Condition: cond}, nil
+}
@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"sort"
 	"time"
 
@@ -34,14 +35,8 @@ const timeStep = 500 * time.Millisecond
 const Version = "0.7"
 
 var (
-	Logger               log.Logger
-	routesManagers       []RoutesManager
-	trainsManagers       map[string]TrainsManager
-	lineItemManager      LineItemManager
-	pointsItemManager    PointsItemManager
-	signalItemManager    SignalItemManager
-	defaultTrainManager  TrainsManager
-	signalConditionTypes map[string]ConditionType
+	Logger             log.Logger
+	registeredManagers managers
 )
 
 // InitializeLogger creates the Logger for the simulation module
@@ -56,6 +51,7 @@ type Simulation struct {
 	Places        map[string]*Place
 	Options       Options
 	Routes        map[string]*Route
+	Areas         map[string]*Area
 	TrainTypes    map[string]*TrainType
 	Services      map[string]*Service
 	Trains        []*Train
@@ -65,6 +61,13 @@ type Simulation struct {
 	clockTicker *time.Ticker
 	stopChan    chan bool
 	started     bool
+	snapshot    *snapshotData
+	random      *rand.Rand
+
+	trackItemIDs  []string
+	observedStops []*ObservedStop
+
+	managers
 }
 
 // UnmarshalJSON for the Simulation type
@@ -76,14 +79,17 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 		Options       Options
 		SignalLib     SignalLibrary         `json:"signalLibrary"`
 		Routes        map[string]*Route     `json:"routes"`
+		Areas         map[string]*Area      `json:"areas"`
 		TrainTypes    map[string]*TrainType `json:"trainTypes"`
 		Services      map[string]*Service   `json:"services"`
 		Trains        []*Train              `json:"trains"`
 		MessageLogger *MessageLogger        `json:"messageLogger"`
+		Snapshot      *snapshotData         `json:"snapshot"`
 	}
 
 	sim.EventChan = make(chan *Event)
 	sim.stopChan = make(chan bool)
+	sim.managers.instantiateFrom(&registeredManagers)
 
 	var rawSim auxSim
 	if err := json.Unmarshal(data, &rawSim); err != nil {
@@ -161,6 +167,15 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 		sim.Routes[num] = route
 	}
 
+	sim.Areas = make(map[string]*Area)
+	for id, area := range rawSim.Areas {
+		area.setSimulation(sim)
+		if err := area.initialize(id); err != nil {
+			return fmt.Errorf("error initializing area %s: %s", id, err)
+		}
+		sim.Areas[id] = area
+	}
+
 	sim.TrainTypes = rawSim.TrainTypes
 	for ttCode, tt := range sim.TrainTypes {
 		tt.setSimulation(sim)
@@ -177,7 +192,8 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 	for _, t := range sim.Trains {
 		t.setSimulation(sim)
 	}
-	sort.Slice(sim.Trains, func(i, j int) bool {
+	sim.snapshot = rawSim.Snapshot
+	sortTrains := func(i, j int) bool {
 		switch {
 		case len(sim.Trains[i].Service().Lines) == 0 && len(sim.Trains[j].Service().Lines) == 0:
 			return sim.Trains[i].ServiceCode < sim.Trains[j].ServiceCode
@@ -189,9 +205,15 @@ func (sim *Simulation) UnmarshalJSON(data []byte) error {
 			return sim.Trains[i].Service().Lines[0].ScheduledDepartureTime.Sub(
 				sim.Trains[j].Service().Lines[0].ScheduledDepartureTime) < 0
 		}
-	})
+	}
+	if sim.snapshot == nil {
+		// Train IDs are their index in Trains, so snapshots keep their order
+		sort.Slice(sim.Trains, sortTrains)
+	}
 	for i, t := range sim.Trains {
-		t.initialize(fmt.Sprintf("%d", i))
+		if err := t.initialize(fmt.Sprintf("%d", i)); err != nil {
+			return err
+		}
 	}
 
 	for _, ti := range sim.TrackItems {
@@ -222,6 +244,10 @@ func (sim Simulation) MarshalJSON() ([]byte, error) {
 	rtes, _ := json.Marshal(sim.Routes)
 	res.Write(rtes)
 	res.WriteString(`,
+	"areas": `)
+	ars, _ := json.Marshal(sim.Areas)
+	res.Write(ars)
+	res.WriteString(`,
 	"trainTypes": `)
 	tts, _ := json.Marshal(sim.TrainTypes)
 	res.Write(tts)
@@ -255,15 +281,41 @@ func (sim Simulation) MarshalJSON() ([]byte, error) {
 
 // Initialize initializes the simulation.
 // This method must be called before Start.
+//
+// If the simulation has been loaded from a snapshot, its internal state is
+// restored instead of setting routes to their initial state.
+//
+// Random delays are drawn from a source seeded with the Seed option, so that
+// a simulation with a non-zero seed always runs the same way when stepped.
 func (sim *Simulation) Initialize() error {
 	sim.MessageLogger.addMessage("Simulation initializing", softwareMsg)
 
+	seed := sim.Options.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	sim.random = rand.New(rand.NewSource(seed))
+	sim.trackItemIDs = make([]string, 0, len(sim.TrackItems))
+	for id := range sim.TrackItems {
+		sim.trackItemIDs = append(sim.trackItemIDs, id)
+	}
+	sort.Strings(sim.trackItemIDs)
+	for _, t := range sim.Trains {
+		t.yieldDelays()
+	}
+
 	for num, r := range sim.Routes {
 		if err := r.initialize(num); err != nil {
 			return fmt.Errorf("error initializing route %s: %s", r.routeID, err)
 		}
 	}
 
+	if sim.snapshot != nil {
+		if err := sim.restoreSnapshot(); err != nil {
+			return fmt.Errorf("error restoring snapshot: %s", err)
+		}
+	}
+
 	for _, ti := range sim.TrackItems {
 		si, ok := ti.(*SignalItem)
 		if !ok {
@@ -275,6 +327,14 @@ func (sim *Simulation) Initialize() error {
 	return nil
 }
 
+// Rand returns the random number generator of the simulation.
+//
+// It is seeded from the seed option at initialization, so that managers using
+// it for random events behave the same way in runs with the same seed.
+func (sim *Simulation) Rand() *rand.Rand {
+	return sim.random
+}
+
 // Start runs the main loop of the simulation by making the clock tick and process each object.
 func (sim *Simulation) Start() {
 	if sim.stopChan == nil || sim.EventChan == nil {
@@ -301,13 +361,38 @@ func (sim *Simulation) run() {
 			Logger.Info("Simulation paused")
 			return
 		case <-clockTicker.C:
-			sim.increaseTime(timeStep)
-			sim.sendEvent(&Event{Name: ClockEvent, Object: sim.Options.CurrentTime})
-			sim.updateTrains()
+			sim.tick()
 		}
 	}
 }
 
+// tick processes a single time step of the simulation.
+func (sim *Simulation) tick() {
+	sim.increaseTime(timeStep)
+	sim.sendEvent(&Event{Name: ClockEvent, Object: sim.Options.CurrentTime})
+	sim.updateTrackItems()
+	sim.updateRoutes()
+	if am := sim.currentARSManager(); am != nil {
+		am.SetRoutes(sim)
+	}
+	sim.updateTrains()
+}
+
+// Step advances the simulation by n time steps without using the clock ticker.
+//
+// Each step increases the simulation time by timeStep * TimeFactor and updates
+// the trains exactly as the main loop does, but as fast as possible. Step
+// cannot be called while the simulation is started.
+func (sim *Simulation) Step(n int) error {
+	if sim.started {
+		return fmt.Errorf("cannot step a started simulation")
+	}
+	for i := 0; i < n; i++ {
+		sim.tick()
+	}
+	return nil
+}
+
 // Pause holds the simulation by stopping the clock ticker. Call Start again to restart the simulation.
 func (sim *Simulation) Pause() {
 	sim.stopChan <- true
@@ -379,6 +464,41 @@ func (sim *Simulation) updateTrains() {
 	}
 }
 
+// updateTrackItems checks the state reported by the managers of points, line
+// items and signals, so that points movements and failures are taken into account.
+// It also records the occupation of the items and updates the signals whose
+// conditions depend on train movements or on time.
+//
+// Items are checked in a stable order so that random failures only depend on
+// the seed of the simulation.
+func (sim *Simulation) updateTrackItems() {
+	for _, id := range sim.trackItemIDs {
+		sim.TrackItems[id].underlying().updateOccupation()
+		switch ti := sim.TrackItems[id].(type) {
+		case *PointsItem:
+			ti.updateDirection()
+		case *LineItem:
+			ti.updateCircuit()
+		case *PlatformItem:
+			ti.updateCircuit()
+		case *InvisibleLinkItem:
+			ti.updateCircuit()
+		case *SignalItem:
+			ti.updateLamps()
+			ti.updateStepState()
+		}
+	}
+}
+
+// updateRoutes releases the overlaps of the routes that are not needed
+// anymore and the cancelled routes the approach locking of which is over.
+func (sim *Simulation) updateRoutes() {
+	for _, r := range sim.Routes {
+		r.updateOverlap()
+		r.updateCancellation()
+	}
+}
+
 // updateScore updates the score by adding penalty and notifiying clients
 func (sim *Simulation) updateScore(penalty int) {
 	sim.Options.CurrentScore += penalty
@@ -387,46 +507,3 @@ func (sim *Simulation) updateScore(penalty int) {
 		Object: sim.Options,
 	})
 }
-
-// RegisterRoutesManager registers the given route manager in the simulation.
-//
-// When several routes managers are registered, all of them are called in turn.
-// If all of them respond true, then the response is true. If one responds false,
-// the response is false.
-func RegisterRoutesManager(rm RoutesManager) {
-	routesManagers = append(routesManagers, rm)
-}
-
-// RegisterTrainsManager registers the given trains manager in the simulation.
-//
-// There can be several trains managers registered, but each train will use only one.
-// If a train has not been explicitly set to a trains manager, it will use the default
-// one. Default trains manager is the first registered manager.
-func RegisterTrainsManager(tm TrainsManager) {
-	if trainsManagers == nil {
-		trainsManagers = make(map[string]TrainsManager)
-		defaultTrainManager = tm
-	}
-	trainsManagers[tm.Name()] = tm
-}
-
-// RegisterLineItemManager registers the given line manager in the simulation.
-//
-// If a line manager was already registered, it is replaced by lim.
-func RegisterLineItemManager(lim LineItemManager) {
-	lineItemManager = lim
-}
-
-// RegisterPointsItemManager registers the given points manager in the simulation.
-//
-// If a points manager was already registered, it is replaced by pim.
-func RegisterPointsItemManager(pim PointsItemManager) {
-	pointsItemManager = pim
-}
-
-// RegisterSignalItemManager registers the signal manager in the simulation.
-//
-// If a signals manager was already registered, it is replaced by sim.
-func RegisterSignalItemManager(sim SignalItemManager) {
-	signalItemManager = sim
-}
@@ -5,11 +5,14 @@ package simulation_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	_ "github.com/ts2/ts2-sim-server/plugins/ars"
 	_ "github.com/ts2/ts2-sim-server/plugins/lines"
 	_ "github.com/ts2/ts2-sim-server/plugins/points"
 	_ "github.com/ts2/ts2-sim-server/plugins/routes"
@@ -97,3 +100,711 @@ func TestSimulationRun(t *testing.T) {
 		})
 	})
 }
+
+func TestSimulationStep(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	loadSeeded := func(seed int64) *simulation.Simulation {
+		var sim simulation.Simulation
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		err := json.Unmarshal(data, &sim)
+		So(err, ShouldBeNil)
+		go func() {
+			for {
+				select {
+				case <-sim.EventChan:
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		sim.Options.Seed = seed
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		return &sim
+	}
+	Convey("Testing deterministic simulation steps", t, func() {
+		sim1 := loadSeeded(42)
+		sim2 := loadSeeded(42)
+		Convey("Stepping should advance the clock", func() {
+			err := sim1.Step(4)
+			So(err, ShouldBeNil)
+			So(sim1.Options.CurrentTime.Time, ShouldEqual, simulation.ParseTime("06:00:10").Time)
+		})
+		Convey("Stepping a started simulation should fail", func() {
+			sim1.Start()
+			err := sim1.Step(1)
+			sim1.Pause()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "cannot step a started simulation")
+		})
+		Convey("Two simulations with the same seed should run identically", func() {
+			err := sim1.Step(400)
+			So(err, ShouldBeNil)
+			err = sim2.Step(400)
+			So(err, ShouldBeNil)
+			So(sim1.Options.CurrentTime.Time, ShouldEqual, sim2.Options.CurrentTime.Time)
+			So(sim1.Options.CurrentScore, ShouldEqual, sim2.Options.CurrentScore)
+			for i, tr := range sim1.Trains {
+				So(tr.Status, ShouldEqual, sim2.Trains[i].Status)
+				So(tr.TrainHead.TrackItemID, ShouldEqual, sim2.Trains[i].TrainHead.TrackItemID)
+				So(tr.TrainHead.PositionOnTI, ShouldEqual, sim2.Trains[i].TrainHead.PositionOnTI)
+				So(tr.Speed, ShouldEqual, sim2.Trains[i].Speed)
+			}
+		})
+		Convey("Stops at stations should be observed", func() {
+			err := sim1.Step(100)
+			So(err, ShouldBeNil)
+			So(sim1.ObservedStops(), ShouldHaveLength, 1)
+			st := sim1.ObservedStops()[0]
+			So(st.TrainID, ShouldEqual, "0")
+			So(st.ServiceCode, ShouldEqual, "S001")
+			So(st.PlaceCode, ShouldEqual, "STN")
+			So(st.PlannedTrackCode, ShouldEqual, "2")
+			So(st.TrackCode, ShouldEqual, "1")
+			So(st.WrongPlatform(), ShouldBeTrue)
+			So(st.ArrivalDelay(), ShouldEqual, st.ArrivalTime.Time.Sub(simulation.ParseTime("06:01:30").Time))
+			So(st.DepartureTime.IsZero(), ShouldBeFalse)
+			So(st.DepartureTime.Time.After(st.ArrivalTime.Time), ShouldBeTrue)
+			So(st.LineIndex, ShouldEqual, 1)
+		})
+		Convey("The timetable should be exported with the observed stops", func() {
+			err := sim1.Step(100)
+			So(err, ShouldBeNil)
+			st := sim1.ObservedStops()[0]
+			var buf strings.Builder
+			err = sim1.WriteTimetableCSV(&buf)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			So(lines, ShouldHaveLength, 8)
+			So(lines[0], ShouldEqual, "service,description,sequence,place,mustStop,plannedTrack,scheduledArrival,scheduledDeparture,train,track,arrival,departure,arrivalDelay")
+			So(lines[1], ShouldEqual, "S001,LEFT->STATION,1,LFT,false,,,06:00:30,,,,,")
+			So(lines[2], ShouldEqual, fmt.Sprintf("S001,LEFT->STATION,2,STN,true,2,06:01:30,06:02:00,0,1,%s,%s,%d",
+				st.ArrivalTime.Format("15:04:05"), st.DepartureTime.Format("15:04:05"), st.ArrivalDelay()/time.Second))
+			So(lines[3], ShouldEqual, "S002,STATION->LEFT,1,STN,true,2,,06:07:00,,,,,")
+		})
+		Convey("Automatic route setting should lead trains to their planned track", func() {
+			for _, ti := range sim1.TrackItems {
+				if si, ok := ti.(*simulation.SignalItem); ok {
+					So(si.SetARSEnabled(true), ShouldBeNil)
+				}
+			}
+			err := sim1.Step(100)
+			for _, ti := range sim1.TrackItems {
+				if si, ok := ti.(*simulation.SignalItem); ok {
+					So(si.SetARSEnabled(false), ShouldBeNil)
+				}
+			}
+			So(err, ShouldBeNil)
+			So(sim1.ObservedStops(), ShouldHaveLength, 1)
+			st := sim1.ObservedStops()[0]
+			So(st.ServiceCode, ShouldEqual, "S001")
+			So(st.TrackCode, ShouldEqual, "2")
+			So(st.WrongPlatform(), ShouldBeFalse)
+		})
+		Convey("Automatic route setting should not replace routes set by humans", func() {
+			So(sim1.Routes["1"].Deactivate(), ShouldBeNil)
+			So(sim1.Step(1), ShouldBeNil)
+			So(sim1.Routes["1"].Activate(false), ShouldBeNil)
+			for _, ti := range sim1.TrackItems {
+				if si, ok := ti.(*simulation.SignalItem); ok {
+					So(si.SetARSEnabled(true), ShouldBeNil)
+				}
+			}
+			err := sim1.Step(100)
+			So(err, ShouldBeNil)
+			So(sim1.Routes["2"].IsActive(), ShouldBeFalse)
+			So(sim1.ObservedStops(), ShouldHaveLength, 1)
+			st := sim1.ObservedStops()[0]
+			So(st.ServiceCode, ShouldEqual, "S001")
+			So(st.TrackCode, ShouldEqual, "1")
+			So(st.WrongPlatform(), ShouldBeTrue)
+		})
+	})
+}
+
+// loadTestSim returns the simulation of the given data, not initialized yet,
+// with a fixed seed and its events drained until endChan is closed.
+func loadTestSim(t *testing.T, data []byte, endChan chan struct{}) *simulation.Simulation {
+	var sim simulation.Simulation
+	if err := json.Unmarshal(data, &sim); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			select {
+			case <-sim.EventChan:
+			case <-endChan:
+				return
+			}
+		}
+	}()
+	sim.Options.Seed = 1
+	return &sim
+}
+
+// loadDemoSim returns the demo simulation, not initialized yet, with a fixed
+// seed and its events drained until endChan is closed.
+func loadDemoSim(t *testing.T, endChan chan struct{}) *simulation.Simulation {
+	data, err := ioutil.ReadFile("testdata/demo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadTestSim(t, data, endChan)
+}
+
+// reloadSnapshot returns a new initialized simulation loaded from a snapshot
+// of sim.
+func reloadSnapshot(t *testing.T, sim *simulation.Simulation, endChan chan struct{}) *simulation.Simulation {
+	data, err := sim.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim2 := loadTestSim(t, data, endChan)
+	if err := sim2.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return sim2
+}
+
+func TestPointsMovement(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing points transit time and failures", t, func() {
+		sim := loadDemoSim(t, endChan)
+		err := json.Unmarshal([]byte("[[5, 5, 100]]"), &sim.Options.PointsTransitTime)
+		So(err, ShouldBeNil)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		pi := sim.TrackItems["7"].(*simulation.PointsItem)
+		si := sim.TrackItems["5"].(*simulation.SignalItem)
+		So(pi.Direction(), ShouldEqual, simulation.DirectionNormal)
+		So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+		So(sim.Routes["2"].Activate(false), ShouldBeNil)
+		So(pi.Direction(), ShouldEqual, simulation.DirectionUnknown)
+		err = sim.Step(2)
+		So(err, ShouldBeNil)
+		So(pi.Direction(), ShouldEqual, simulation.DirectionReversed)
+		So(sim.Routes["2"].Deactivate(), ShouldBeNil)
+		Convey("Points should be unknown while moving and signals at danger", func() {
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionUnknown)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionNormal)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_CLEAR")
+		})
+		Convey("Failed points should be reported and repaired", func() {
+			sim.Options.PointsFailureChance = 1
+			err = json.Unmarshal([]byte("[[60, 60, 100]]"), &sim.Options.PointsRepairTime)
+			So(err, ShouldBeNil)
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionFailed)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			msgs := sim.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Points 7 have failed")
+			err = sim.Step(23)
+			So(err, ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionFailed)
+			So(sim.Trains[0].Speed, ShouldEqual, 0)
+			err = sim.Step(1)
+			So(err, ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionUnknown)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			msgs = sim.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Points 7 have been repaired")
+			err = sim.Step(1)
+			So(err, ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionUnknown)
+			So(sim.Trains[0].Speed, ShouldEqual, 0)
+			err = sim.Step(1)
+			So(err, ShouldBeNil)
+			So(pi.Direction(), ShouldEqual, simulation.DirectionNormal)
+			So(sim.Trains[0].Speed, ShouldBeGreaterThan, 0)
+		})
+		Convey("Failed points should be restored from snapshots", func() {
+			sim.Options.PointsFailureChance = 1
+			err = json.Unmarshal([]byte("[[60, 60, 100]]"), &sim.Options.PointsRepairTime)
+			So(err, ShouldBeNil)
+			So(sim.Routes["1"].Activate(false), ShouldBeNil)
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			sim2 := reloadSnapshot(t, sim, endChan)
+			pi2 := sim2.TrackItems["7"].(*simulation.PointsItem)
+			So(pi2.Direction(), ShouldEqual, simulation.DirectionFailed)
+			So(sim2.TrackItems["5"].(*simulation.SignalItem).ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			err = sim2.Step(24)
+			So(err, ShouldBeNil)
+			So(pi2.Direction(), ShouldEqual, simulation.DirectionUnknown)
+			msgs := sim2.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Points 7 have been repaired")
+		})
+	})
+}
+
+// loadOverlapSim returns the demo simulation data with overlaps on routes 1,
+// 3 and 11, and item 2 conflicting with item 12.
+func loadOverlapSim(overlap1 []string) []byte {
+	data, _ := ioutil.ReadFile("testdata/demo.json")
+	var raw map[string]interface{}
+	_ = json.Unmarshal(data, &raw)
+	routes := raw["routes"].(map[string]interface{})
+	routes["1"].(map[string]interface{})["overlap"] = map[string]interface{}{"items": overlap1, "releaseDelay": 30}
+	routes["3"].(map[string]interface{})["overlap"] = map[string]interface{}{"items": []string{"2"}, "releaseDelay": 30}
+	routes["11"].(map[string]interface{})["overlap"] = map[string]interface{}{"items": []string{"12"}, "releaseDelay": 30}
+	raw["trackItems"].(map[string]interface{})["2"].(map[string]interface{})["conflictTiId"] = "12"
+	raw["trackItems"].(map[string]interface{})["12"].(map[string]interface{})["conflictTiId"] = "2"
+	data, _ = json.Marshal(raw)
+	return data
+}
+
+func TestRouteOverlaps(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing route overlaps", t, func() {
+		Convey("Overlap items should follow the end signal", func() {
+			var sim simulation.Simulation
+			err := json.Unmarshal(loadOverlapSim([]string{"103"}), &sim)
+			So(err, ShouldBeNil)
+			go func() {
+				for {
+					select {
+					case <-sim.EventChan:
+					case <-endChan:
+						return
+					}
+				}
+			}()
+			err = sim.Initialize()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "error initializing route 1: route Error: overlap item 103 of route 1 does not follow item 101")
+		})
+		var sim simulation.Simulation
+		err := json.Unmarshal(loadOverlapSim([]string{"102", "103"}), &sim)
+		So(err, ShouldBeNil)
+		go func() {
+			for {
+				select {
+				case <-sim.EventChan:
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		sim.Options.Seed = 1
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		r1 := sim.Routes["1"]
+		Convey("Overlaps should be locked with their route", func() {
+			So(r1.Overlap.Positions, ShouldHaveLength, 2)
+			So(r1.OverlapLocked(), ShouldBeTrue)
+			So(sim.TrackItems["102"].OverlapRoute(), ShouldEqual, r1)
+			So(sim.TrackItems["103"].OverlapRoute(), ShouldEqual, r1)
+			So(sim.TrackItems["104"].OverlapRoute(), ShouldBeNil)
+		})
+		Convey("Routes starting at the end signal should be set over the overlap", func() {
+			So(sim.Routes["11"].Deactivate(), ShouldBeNil)
+			So(sim.Routes["11"].Activate(false), ShouldBeNil)
+			So(r1.OverlapLocked(), ShouldBeTrue)
+		})
+		Convey("Overlaps should be released with their route", func() {
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r1.OverlapLocked(), ShouldBeFalse)
+			So(sim.TrackItems["102"].OverlapRoute(), ShouldBeNil)
+		})
+		Convey("Routes conflicting with a locked overlap should not be set", func() {
+			So(r1.Deactivate(), ShouldBeNil)
+			err := sim.Routes["3"].Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Standard Manager vetoed route activation: overlap of route 11 is locked")
+			So(sim.Routes["11"].Deactivate(), ShouldBeNil)
+			So(sim.Routes["3"].Activate(false), ShouldBeNil)
+			So(sim.TrackItems["2"].OverlapRoute(), ShouldEqual, sim.Routes["3"])
+			err = sim.Routes["11"].Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Standard Manager vetoed route activation: overlap of route 3 is locked")
+		})
+		Convey("Overlaps should be released after the train has stopped at the end signal", func() {
+			So(sim.Routes["11"].Deactivate(), ShouldBeNil)
+			// The train stops at STATION just before signal 101 at 06:02:30
+			err := sim.Step(60)
+			So(err, ShouldBeNil)
+			So(sim.Trains[0].Speed, ShouldEqual, 0)
+			So(r1.OverlapLocked(), ShouldBeTrue)
+			Convey("Overlap locks should be restored from snapshots", func() {
+				data, err := sim.Snapshot()
+				So(err, ShouldBeNil)
+				var sim2 simulation.Simulation
+				err = json.Unmarshal(data, &sim2)
+				So(err, ShouldBeNil)
+				go func() {
+					for {
+						select {
+						case <-sim2.EventChan:
+						case <-endChan:
+							return
+						}
+					}
+				}()
+				err = sim2.Initialize()
+				So(err, ShouldBeNil)
+				So(sim2.Routes["1"].OverlapLocked(), ShouldBeTrue)
+				So(sim2.TrackItems["102"].OverlapRoute(), ShouldEqual, sim2.Routes["1"])
+			})
+			err = sim.Step(9)
+			So(err, ShouldBeNil)
+			So(r1.OverlapLocked(), ShouldBeTrue)
+			err = sim.Step(1)
+			So(err, ShouldBeNil)
+			So(r1.OverlapLocked(), ShouldBeFalse)
+			So(sim.TrackItems["102"].OverlapRoute(), ShouldBeNil)
+		})
+		Convey("Overlaps shared by several routes should stay locked until all are released", func() {
+			// Route 12 is set between the same signals as route 11 and
+			// shares its overlap.
+			var raw map[string]interface{}
+			err := json.Unmarshal(loadOverlapSim([]string{"102", "103"}), &raw)
+			So(err, ShouldBeNil)
+			raw["routes"].(map[string]interface{})["12"] = map[string]interface{}{
+				"__type__":     "Route",
+				"id":           "12",
+				"beginSignal":  "101",
+				"endSignal":    "11",
+				"directions":   map[string]interface{}{},
+				"initialState": 0,
+				"overlap":      map[string]interface{}{"items": []string{"12"}, "releaseDelay": 30},
+			}
+			data, err := json.Marshal(raw)
+			So(err, ShouldBeNil)
+			sharedSim := loadTestSim(t, data, endChan)
+			err = sharedSim.Initialize()
+			So(err, ShouldBeNil)
+			r11 := sharedSim.Routes["11"]
+			r12 := sharedSim.Routes["12"]
+			So(sharedSim.Routes["1"].Deactivate(), ShouldBeNil)
+			So(r12.Activate(false), ShouldBeNil)
+			So(sharedSim.TrackItems["12"].OverlapRoutes(), ShouldResemble, []*simulation.Route{r11, r12})
+			Convey("Shared overlap locks should be restored from snapshots", func() {
+				sim2 := reloadSnapshot(t, sharedSim, endChan)
+				So(sim2.TrackItems["12"].OverlapRoutes(), ShouldResemble, []*simulation.Route{sim2.Routes["11"], sim2.Routes["12"]})
+			})
+			So(r11.Deactivate(), ShouldBeNil)
+			So(r11.OverlapLocked(), ShouldBeFalse)
+			So(sharedSim.TrackItems["12"].OverlapRoute(), ShouldEqual, r12)
+			err = sharedSim.Routes["3"].Activate(false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Standard Manager vetoed route activation: overlap of route 12 is locked")
+			So(r12.Deactivate(), ShouldBeNil)
+			So(sharedSim.TrackItems["12"].OverlapRoute(), ShouldBeNil)
+			So(sharedSim.Routes["3"].Activate(false), ShouldBeNil)
+		})
+	})
+}
+
+func TestApproachLocking(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing approach locking", t, func() {
+		sim := loadDemoSim(t, endChan)
+		err := json.Unmarshal([]byte("60"), &sim.Options.ApproachLockingTime)
+		So(err, ShouldBeNil)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		r1 := sim.Routes["1"]
+		si := sim.TrackItems["5"].(*simulation.SignalItem)
+		Convey("Routes should be released at once when no train is approaching", func() {
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r1.State(), ShouldEqual, simulation.Deactivated)
+			So(sim.TrackItems["6"].ActiveRoute(), ShouldBeNil)
+		})
+		Convey("Routes should not be released at once in front of a train", func() {
+			// Train 0 is 70m before signal 5 at 06:00:50
+			err := sim.Step(20)
+			So(err, ShouldBeNil)
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r1.State(), ShouldEqual, simulation.Cancelling)
+			So(r1.IsActive(), ShouldBeFalse)
+			So(r1.CancelTime().Format("15:04:05"), ShouldEqual, "06:01:50")
+			So(r1.CancelTrain(), ShouldEqual, sim.Trains[0])
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			So(sim.TrackItems["6"].ActiveRoute(), ShouldEqual, r1)
+			rj, err := json.Marshal(r1)
+			So(err, ShouldBeNil)
+			So(string(rj), ShouldContainSubstring, `"state":4`)
+			So(string(rj), ShouldContainSubstring, `"cancelTime":"06:01:50"`)
+			err = r1.Deactivate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Standard Manager vetoed route deactivation: route 1 is already being cancelled")
+			Convey("Cancelling routes should be restored from snapshots", func() {
+				data, err := sim.Snapshot()
+				So(err, ShouldBeNil)
+				var sim2 simulation.Simulation
+				err = json.Unmarshal(data, &sim2)
+				So(err, ShouldBeNil)
+				go func() {
+					for {
+						select {
+						case <-sim2.EventChan:
+						case <-endChan:
+							return
+						}
+					}
+				}()
+				err = sim2.Initialize()
+				So(err, ShouldBeNil)
+				So(sim2.Routes["1"].State(), ShouldEqual, simulation.Cancelling)
+				So(sim2.Routes["1"].CancelTime().Format("15:04:05"), ShouldEqual, "06:01:50")
+				So(sim2.Routes["1"].CancelTrain(), ShouldEqual, sim2.Trains[0])
+			})
+			Convey("Cancelling routes should be released when the train has stopped", func() {
+				// Train 0 stops in front of signal 5 at 06:01:07
+				err := sim.Step(7)
+				So(err, ShouldBeNil)
+				So(sim.Trains[0].Speed, ShouldEqual, 0)
+				So(r1.State(), ShouldEqual, simulation.Cancelling)
+				err = sim.Step(1)
+				So(err, ShouldBeNil)
+				So(r1.State(), ShouldEqual, simulation.Deactivated)
+				So(r1.CancelTrain(), ShouldBeNil)
+				So(sim.TrackItems["6"].ActiveRoute(), ShouldBeNil)
+			})
+			Convey("Cancelling routes should be reactivated", func() {
+				So(r1.Activate(false), ShouldBeNil)
+				So(r1.State(), ShouldEqual, simulation.Activated)
+				So(r1.CancelTime().IsZero(), ShouldBeTrue)
+				So(si.ActiveAspect().Name, ShouldEqual, "UK_CLEAR")
+			})
+		})
+		Convey("Cancelling routes should be released after the approach locking time", func() {
+			err := json.Unmarshal([]byte("10"), &sim.Options.ApproachLockingTime)
+			So(err, ShouldBeNil)
+			err = sim.Step(20)
+			So(err, ShouldBeNil)
+			So(r1.Deactivate(), ShouldBeNil)
+			So(r1.State(), ShouldEqual, simulation.Cancelling)
+			err = sim.Step(3)
+			So(err, ShouldBeNil)
+			So(r1.State(), ShouldEqual, simulation.Cancelling)
+			err = sim.Step(1)
+			So(err, ShouldBeNil)
+			So(sim.Trains[0].Speed, ShouldBeGreaterThan, 0)
+			So(r1.State(), ShouldEqual, simulation.Deactivated)
+		})
+	})
+}
+
+func TestTrackCircuitFailure(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing track circuit failures", t, func() {
+		sim := loadDemoSim(t, endChan)
+		err := sim.Initialize()
+		So(err, ShouldBeNil)
+		si := sim.TrackItems["5"].(*simulation.SignalItem)
+		li := sim.TrackItems["6"].(*simulation.LineItem)
+		So(si.ActiveAspect().Name, ShouldEqual, "UK_CLEAR")
+		Convey("A failed track circuit should set the protecting signal at danger", func() {
+			li.SetFailed(true)
+			So(li.TrainPresent(), ShouldBeTrue)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			msgs := sim.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Track circuit 6 has failed")
+			li.SetFailed(false)
+			So(li.TrainPresent(), ShouldBeFalse)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_CLEAR")
+			msgs = sim.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Track circuit 6 has been repaired")
+		})
+		Convey("Track circuits should fail randomly and be repaired", func() {
+			sim.Options.TrackCircuitFailureRate = 3600
+			err = json.Unmarshal([]byte("[[30, 30, 100]]"), &sim.Options.TrackCircuitRepairTime)
+			So(err, ShouldBeNil)
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			So(li.IsFailed(), ShouldBeTrue)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			sim.Options.TrackCircuitFailureRate = 0
+			err = sim.Step(14)
+			So(err, ShouldBeNil)
+			So(li.IsFailed(), ShouldBeFalse)
+		})
+		Convey("Failed track circuits should be restored from snapshots", func() {
+			li.SetFailed(true)
+			sim2 := reloadSnapshot(t, sim, endChan)
+			li2 := sim2.TrackItems["6"].(*simulation.LineItem)
+			So(li2.IsFailed(), ShouldBeTrue)
+			So(li2.TrainPresent(), ShouldBeTrue)
+			So(sim2.TrackItems["5"].(*simulation.SignalItem).ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			li2.SetFailed(false)
+			So(li2.IsFailed(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestSignalFailure(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing signal lamp failures", t, func() {
+		sim := loadDemoSim(t, endChan)
+		err := sim.Initialize()
+		So(err, ShouldBeNil)
+		si := sim.TrackItems["5"].(*simulation.SignalItem)
+		So(si.ActiveAspect().Name, ShouldEqual, "UK_CLEAR")
+		Convey("A failed signal should show danger and stop trains", func() {
+			si.SetFailed(true)
+			So(si.IsFailed(), ShouldBeTrue)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			msgs := sim.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Signal 32 has failed")
+			err = sim.Step(30)
+			So(err, ShouldBeNil)
+			So(sim.Trains[0].Speed, ShouldEqual, 0)
+			So(sim.Trains[0].ProceedWithCaution(), ShouldBeNil)
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			So(sim.Trains[0].Speed, ShouldBeGreaterThan, 0)
+			si.SetFailed(false)
+			So(si.IsFailed(), ShouldBeFalse)
+			msgs = sim.MessageLogger.Messages
+			So(msgs[len(msgs)-1].MsgText, ShouldEqual, "Signal 32 has been repaired")
+		})
+		Convey("A failed signal should show the dark aspect if defined", func() {
+			data, _ := ioutil.ReadFile("testdata/demo.json")
+			darkData := strings.Replace(string(data), `"signalAspects": {`, `"darkAspect": "BUFFER", "signalAspects": {`, 1)
+			darkSim := loadTestSim(t, []byte(darkData), endChan)
+			err = darkSim.Initialize()
+			So(err, ShouldBeNil)
+			darkSignal := darkSim.TrackItems["5"].(*simulation.SignalItem)
+			darkSignal.SetFailed(true)
+			So(darkSignal.ActiveAspect().Name, ShouldEqual, "BUFFER")
+			darkSignal.SetFailed(false)
+			So(darkSignal.ActiveAspect().Name, ShouldEqual, "UK_CLEAR")
+		})
+		Convey("Signals should fail randomly and be repaired", func() {
+			sim.Options.SignalFailureRate = 3600
+			err = json.Unmarshal([]byte("[[30, 30, 100]]"), &sim.Options.SignalRepairTime)
+			So(err, ShouldBeNil)
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			So(si.IsFailed(), ShouldBeTrue)
+			So(si.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			sim.Options.SignalFailureRate = 0
+			err = sim.Step(14)
+			So(err, ShouldBeNil)
+			So(si.IsFailed(), ShouldBeFalse)
+		})
+		Convey("Failed signals should be restored from snapshots", func() {
+			sim.Options.SignalFailureRate = 3600
+			err = json.Unmarshal([]byte("[[30, 30, 100]]"), &sim.Options.SignalRepairTime)
+			So(err, ShouldBeNil)
+			err = sim.Step(2)
+			So(err, ShouldBeNil)
+			So(si.IsFailed(), ShouldBeTrue)
+			sim2 := reloadSnapshot(t, sim, endChan)
+			sim2.Options.SignalFailureRate = 0
+			si2 := sim2.TrackItems["5"].(*simulation.SignalItem)
+			So(si2.IsFailed(), ShouldBeTrue)
+			So(si2.ActiveAspect().Name, ShouldEqual, "UK_DANGER")
+			err = sim2.Step(14)
+			So(err, ShouldBeNil)
+			So(si2.IsFailed(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestTrainsManagers(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing per train trains managers", t, func() {
+		loadSim := func(data []byte) (*simulation.Simulation, error) {
+			var sim simulation.Simulation
+			if err := json.Unmarshal(data, &sim); err != nil {
+				return nil, err
+			}
+			go func() {
+				for {
+					select {
+					case <-sim.EventChan:
+					case <-endChan:
+						return
+					}
+				}
+			}()
+			sim.Options.Seed = 1
+			return &sim, sim.Initialize()
+		}
+		data, _ := ioutil.ReadFile("testdata/demo.json")
+		sim, err := loadSim(data)
+		So(err, ShouldBeNil)
+		So(sim.Trains[0].TrainsManager, ShouldEqual, "")
+		Convey("A cautious driver should accelerate slower", func() {
+			cautiousSim, err := loadSim(data)
+			So(err, ShouldBeNil)
+			So(cautiousSim.Trains[0].SetTrainsManager("cautious"), ShouldBeNil)
+			So(sim.Step(8), ShouldBeNil)
+			So(cautiousSim.Step(8), ShouldBeNil)
+			So(cautiousSim.Trains[0].Speed, ShouldBeGreaterThan, 0)
+			So(cautiousSim.Trains[0].Speed, ShouldBeLessThan, sim.Trains[0].Speed)
+			Convey("Trains managers should be saved by code in snapshots", func() {
+				snap, err := cautiousSim.Snapshot()
+				So(err, ShouldBeNil)
+				So(string(snap), ShouldContainSubstring, `"trainsManager": "cautious"`)
+				sim2 := reloadSnapshot(t, cautiousSim, endChan)
+				So(sim2.Trains[0].TrainsManager, ShouldEqual, "cautious")
+			})
+		})
+		Convey("A physics driver should depend on the train dynamics", func() {
+			physicsSim, err := loadSim(data)
+			So(err, ShouldBeNil)
+			So(physicsSim.Trains[0].SetTrainsManager("physics"), ShouldBeNil)
+			So(sim.Step(8), ShouldBeNil)
+			So(physicsSim.Step(8), ShouldBeNil)
+			// Without mass and power, the standard behaviour is used
+			So(physicsSim.Trains[0].Speed, ShouldEqual, sim.Trains[0].Speed)
+
+			heavySim, err := loadSim(data)
+			So(err, ShouldBeNil)
+			climbingSim, err := loadSim(data)
+			So(err, ShouldBeNil)
+			for _, s := range []*simulation.Simulation{heavySim, climbingSim} {
+				tt := s.TrainTypes["UT"]
+				tt.Mass = 400
+				tt.Power = 500
+				tt.MaxTractiveEffort = 100
+				tt.DavisA = 4
+				tt.DavisB = 0.05
+				tt.DavisC = 0.01
+				So(s.Trains[0].SetTrainsManager("physics"), ShouldBeNil)
+			}
+			for _, ti := range climbingSim.TrackItems {
+				if li, ok := ti.(*simulation.LineItem); ok {
+					li.Gradient = 20
+				}
+			}
+			So(heavySim.Step(8), ShouldBeNil)
+			So(climbingSim.Step(8), ShouldBeNil)
+			So(heavySim.Trains[0].Speed, ShouldBeGreaterThan, 0)
+			So(heavySim.Trains[0].Speed, ShouldBeLessThan, sim.Trains[0].Speed)
+			So(climbingSim.Trains[0].Speed, ShouldBeLessThan, heavySim.Trains[0].Speed)
+		})
+		Convey("Line gradients should depend on the direction", func() {
+			li := sim.TrackItems["6"].(*simulation.LineItem)
+			li.Gradient = 20
+			pos := simulation.NewPosition(sim, "6", li.PreviousItem().ID(), 0)
+			So(li.GradientAt(pos), ShouldEqual, 20)
+			So(li.GradientAt(pos.Reversed()), ShouldEqual, -20)
+		})
+		Convey("Unknown trains managers should be rejected", func() {
+			So(sim.Trains[0].SetTrainsManager("Reckless Driver"), ShouldNotBeNil)
+			// Managers are identified by their code, not by their name
+			So(sim.Trains[0].SetTrainsManager("Cautious Driver"), ShouldNotBeNil)
+			wrongData := strings.Replace(string(data), `"trainTypeCode"`, `"trainsManager": "Reckless Driver", "trainTypeCode"`, 1)
+			_, err := loadSim([]byte(wrongData))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown trains manager: Reckless Driver")
+		})
+	})
+}
@@ -0,0 +1,415 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// snapshotData holds the internal state of a running simulation that is not
+// part of the simulation file format.
+type snapshotData struct {
+	CurrentTime string                       `json:"currentTime"`
+	Trains      []trainSnapshot              `json:"trains"`
+	TrackItems  map[string]trackItemSnapshot `json:"trackItems"`
+	Routes      map[string]routeSnapshot     `json:"routes"`
+	Stops       []*ObservedStop              `json:"observedStops"`
+	Managers    map[string]json.RawMessage   `json:"managers,omitempty"`
+}
+
+// trainSnapshot holds the internal state of a Train.
+// Trains are stored in the same order as in Simulation.Trains.
+type trainSnapshot struct {
+	TrainsManager   string         `json:"trainsManager"`
+	EffInitialDelay time.Duration  `json:"effInitialDelay"`
+	MinStopTime     time.Duration  `json:"minStopTime"`
+	SignalActions   []SignalAction `json:"signalActions"`
+	ActionIndex     int            `json:"actionIndex"`
+	ActionTime      string         `json:"actionTime"`
+	LastSignal      string         `json:"lastSignal,omitempty"`
+	IgnoredSignal   string         `json:"ignoredSignal,omitempty"`
+}
+
+// trackItemSnapshot holds the internal state of a TrackItem.
+// Fields that do not apply to the item's type are left empty.
+type trackItemSnapshot struct {
+	ActiveRoute         string             `json:"activeRoute,omitempty"`
+	ARPreviousItem      string             `json:"activeRoutePreviousItem,omitempty"`
+	TrainEndsFW         map[string]float64 `json:"trainEndsFW,omitempty"`
+	TrainEndsBK         map[string]float64 `json:"trainEndsBK,omitempty"`
+	Train               string             `json:"train,omitempty"`
+	PreviousActiveRoute string             `json:"previousActiveRoute,omitempty"`
+	NextActiveRoute     string             `json:"nextActiveRoute,omitempty"`
+	ActiveAspect        string             `json:"activeAspect,omitempty"`
+	ARSEnabled          bool               `json:"arsEnabled,omitempty"`
+	Direction           *PointDirection    `json:"direction,omitempty"`
+	OccupiedSince       string             `json:"occupiedSince,omitempty"`
+}
+
+// routeSnapshot holds the internal state of a Route.
+type routeSnapshot struct {
+	Persistent         bool   `json:"persistent"`
+	OverlapLocked      bool   `json:"overlapLocked,omitempty"`
+	OverlapReleaseTime string `json:"overlapReleaseTime,omitempty"`
+	Cancelling         bool   `json:"cancelling,omitempty"`
+	CancelTime         string `json:"cancelTime,omitempty"`
+	CancelTrain        string `json:"cancelTrain,omitempty"`
+}
+
+// Snapshot returns the JSON representation of the simulation together with
+// its internal state, so that it can be restored exactly later on.
+//
+// A snapshot is a valid simulation file. When it is loaded, the internal
+// state is restored by Initialize instead of applying the initial state of
+// the simulation.
+func (sim *Simulation) Snapshot() ([]byte, error) {
+	data, err := json.Marshal(sim)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]json.RawMessage
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	sd, err := sim.snapshotData()
+	if err != nil {
+		return nil, err
+	}
+	res["snapshot"], err = json.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(res, "", "    ")
+}
+
+// HasSnapshot returns true if this simulation has been loaded from a snapshot
+// and its internal state has not been restored yet.
+func (sim *Simulation) HasSnapshot() bool {
+	return sim.snapshot != nil
+}
+
+// snapshotData returns the internal state of this simulation.
+func (sim *Simulation) snapshotData() (*snapshotData, error) {
+	sd := snapshotData{
+		CurrentTime: formatSnapshotTime(sim.Options.CurrentTime.Time),
+		Trains:      make([]trainSnapshot, len(sim.Trains)),
+		TrackItems:  make(map[string]trackItemSnapshot),
+		Routes:      make(map[string]routeSnapshot),
+		Stops:       sim.observedStops,
+	}
+	for i, t := range sim.Trains {
+		ts := trainSnapshot{
+			EffInitialDelay: t.effInitialDelay,
+			MinStopTime:     t.minStopTime,
+			SignalActions:   t.signalActions,
+			ActionIndex:     t.actionIndex,
+			ActionTime:      formatSnapshotTime(t.actionTime.Time),
+		}
+		if t.trainManager != nil {
+			ts.TrainsManager = t.trainManager.Code()
+		}
+		if t.lastSignal != nil {
+			ts.LastSignal = t.lastSignal.ID()
+		}
+		if t.ignoredSignal != nil {
+			ts.IgnoredSignal = t.ignoredSignal.ID()
+		}
+		sd.Trains[i] = ts
+	}
+	for id, ti := range sim.TrackItems {
+		ai := ti.underlying().asJSONStruct()
+		tis := trackItemSnapshot{
+			ActiveRoute:    ai.ActiveRoute,
+			ARPreviousItem: ai.ARPreviousItem,
+			TrainEndsFW:    ai.TrainEndsFW,
+			TrainEndsBK:    ai.TrainEndsBK,
+			OccupiedSince:  formatSnapshotTime(ti.underlying().occupiedSince),
+		}
+		switch item := ti.(type) {
+		case *SignalItem:
+			if item.train != nil {
+				tis.Train = item.train.ID()
+			}
+			if item.previousActiveRoute != nil {
+				tis.PreviousActiveRoute = item.previousActiveRoute.ID()
+			}
+			if item.nextActiveRoute != nil {
+				tis.NextActiveRoute = item.nextActiveRoute.ID()
+			}
+			if item.activeAspect != nil {
+				tis.ActiveAspect = item.activeAspect.Name
+			}
+			tis.ARSEnabled = item.ARSEnabled()
+		case *PointsItem:
+			dir := sim.currentPointsItemManager().Direction(item)
+			if dir == DirectionUnknown || dir == DirectionFailed {
+				// Moving or failed points are restored in the direction
+				// required by their active route or overlap, if any.
+				if item.activeRoute == nil && item.OverlapRoute() == nil {
+					break
+				}
+				if item.activeRoute != nil {
+					dir = item.activeRoute.Directions[item.ID()]
+				} else {
+					dir = item.OverlapRoute().Overlap.Directions[item.ID()]
+				}
+			}
+			tis.Direction = &dir
+		}
+		sd.TrackItems[id] = tis
+	}
+	for id, r := range sim.Routes {
+		rs := routeSnapshot{
+			Persistent:    r.Persistent,
+			OverlapLocked: r.overlapLocked,
+			Cancelling:    r.cancelling,
+			CancelTime:    formatSnapshotTime(r.cancelTime),
+		}
+		if !r.overlapReleaseTime.IsZero() {
+			rs.OverlapReleaseTime = formatSnapshotTime(r.overlapReleaseTime)
+		}
+		if r.cancelTrain != nil {
+			rs.CancelTrain = r.cancelTrain.ID()
+		}
+		sd.Routes[id] = rs
+	}
+	sd.Managers = make(map[string]json.RawMessage)
+	for name, m := range sim.statefulManagers() {
+		state, err := m.SaveState(sim)
+		if err != nil {
+			return nil, fmt.Errorf("unable to save state of %s: %s", name, err)
+		}
+		sd.Managers[name] = state
+	}
+	return &sd, nil
+}
+
+// statefulManagers returns the managers of this simulation which implement
+// StatefulManager, by kind of manager.
+func (sim *Simulation) statefulManagers() map[string]StatefulManager {
+	res := make(map[string]StatefulManager)
+	for name, m := range map[string]interface{}{
+		"lineItemManager":   sim.currentLineItemManager(),
+		"pointsItemManager": sim.currentPointsItemManager(),
+		"signalItemManager": sim.currentSignalItemManager(),
+		"arsManager":        sim.currentARSManager(),
+	} {
+		if sm, ok := m.(StatefulManager); ok {
+			res[name] = sm
+		}
+	}
+	return res
+}
+
+// restoreSnapshot restores the internal state of the simulation from the
+// snapshot it has been loaded from.
+func (sim *Simulation) restoreSnapshot() error {
+	sd := sim.snapshot
+	sim.snapshot = nil
+	sim.Options.CurrentTime.Time = ParseTime(sd.CurrentTime).Time
+	if len(sd.Trains) != len(sim.Trains) {
+		return fmt.Errorf("snapshot has %d trains, simulation has %d", len(sd.Trains), len(sim.Trains))
+	}
+	for i, ts := range sd.Trains {
+		t := sim.Trains[i]
+		if tm, ok := sim.trainsManagerOf(ts.TrainsManager); ok {
+			t.trainManager = tm
+			t.TrainsManager = ts.TrainsManager
+		}
+		t.effInitialDelay = ts.EffInitialDelay
+		t.minStopTime = ts.MinStopTime
+		t.signalActions = ts.SignalActions
+		t.actionIndex = ts.ActionIndex
+		t.actionTime = ParseTime(ts.ActionTime)
+		var err error
+		if t.lastSignal, err = sim.snapshotSignal(ts.LastSignal); err != nil {
+			return err
+		}
+		if t.ignoredSignal, err = sim.snapshotSignal(ts.IgnoredSignal); err != nil {
+			return err
+		}
+	}
+	for id, tis := range sd.TrackItems {
+		ti, ok := sim.TrackItems[id]
+		if !ok {
+			return fmt.Errorf("unknown track item in snapshot: %s", id)
+		}
+		ts := ti.underlying()
+		var err error
+		if ts.activeRoute, err = sim.snapshotRoute(tis.ActiveRoute); err != nil {
+			return err
+		}
+		if tis.ARPreviousItem != "" {
+			if ts.arPreviousItem, ok = sim.TrackItems[tis.ARPreviousItem]; !ok {
+				return fmt.Errorf("unknown track item in snapshot: %s", tis.ARPreviousItem)
+			}
+		}
+		for tID, pos := range tis.TrainEndsFW {
+			t, err := sim.snapshotTrain(tID)
+			if err != nil {
+				return err
+			}
+			ts.trainEndsFW[t] = pos
+		}
+		for tID, pos := range tis.TrainEndsBK {
+			t, err := sim.snapshotTrain(tID)
+			if err != nil {
+				return err
+			}
+			ts.trainEndsBK[t] = pos
+		}
+		if tis.OccupiedSince != "" {
+			ts.occupiedSince = ParseTime(tis.OccupiedSince).Time
+		}
+		switch item := ti.(type) {
+		case *SignalItem:
+			if tis.Train != "" {
+				if item.train, err = sim.snapshotTrain(tis.Train); err != nil {
+					return err
+				}
+			}
+			if item.previousActiveRoute, err = sim.snapshotRoute(tis.PreviousActiveRoute); err != nil {
+				return err
+			}
+			if item.nextActiveRoute, err = sim.snapshotRoute(tis.NextActiveRoute); err != nil {
+				return err
+			}
+			if aspect, ok := sim.SignalLib.Aspects[tis.ActiveAspect]; ok {
+				item.activeAspect = aspect
+			}
+			if am := sim.currentARSManager(); am != nil {
+				am.SetEnabled(item, tis.ARSEnabled)
+			}
+		case *PointsItem:
+			if tis.Direction != nil {
+				sim.currentPointsItemManager().SetDirection(item, *tis.Direction)
+			}
+		}
+	}
+	// Routes are restored in a fixed order so that items shared by several
+	// locked overlaps get the same owners in the same order each time.
+	routeIDs := make([]string, 0, len(sd.Routes))
+	for id := range sd.Routes {
+		routeIDs = append(routeIDs, id)
+	}
+	sort.Strings(routeIDs)
+	for _, id := range routeIDs {
+		rs := sd.Routes[id]
+		r, err := sim.snapshotRoute(id)
+		if err != nil {
+			return err
+		}
+		r.Persistent = rs.Persistent
+		if rs.OverlapLocked && r.Overlap != nil {
+			// Points directions have been restored with the track items
+			for _, pos := range r.Overlap.Positions {
+				ts := pos.TrackItem().underlying()
+				ts.overlapRoutes = append(ts.overlapRoutes, r)
+			}
+			r.overlapLocked = true
+		}
+		if rs.OverlapReleaseTime != "" {
+			r.overlapReleaseTime = ParseTime(rs.OverlapReleaseTime).Time
+		}
+		if rs.Cancelling {
+			// Items and signals have been restored with the track items
+			r.cancelling = true
+			r.cancelTime = ParseTime(rs.CancelTime).Time
+			if r.cancelTrain, err = sim.snapshotTrain(rs.CancelTrain); err != nil {
+				return err
+			}
+		}
+	}
+	sim.observedStops = sd.Stops
+	// Managers states are restored last since restoring points directions
+	// above may have changed them.
+	for name, m := range sim.statefulManagers() {
+		state, ok := sd.Managers[name]
+		if !ok {
+			continue
+		}
+		if err := m.RestoreState(sim, state); err != nil {
+			return fmt.Errorf("unable to restore state of %s: %s", name, err)
+		}
+	}
+	for _, ti := range sim.TrackItems {
+		switch item := ti.(type) {
+		case *LineItem:
+			item.reportedFailed = item.IsFailed()
+		case *PlatformItem:
+			item.reportedFailed = item.IsFailed()
+		case *InvisibleLinkItem:
+			item.reportedFailed = item.IsFailed()
+		case *PointsItem:
+			item.reportedDirection = item.Direction()
+		case *SignalItem:
+			item.reportedFailed = item.IsFailed()
+		}
+	}
+	return nil
+}
+
+// snapshotTrain returns the train with the given ID in a snapshot.
+func (sim *Simulation) snapshotTrain(id string) (*Train, error) {
+	idx, err := strconv.Atoi(id)
+	if err != nil || idx < 0 || idx >= len(sim.Trains) {
+		return nil, fmt.Errorf("unknown train in snapshot: %s", id)
+	}
+	return sim.Trains[idx], nil
+}
+
+// snapshotSignal returns the signal with the given ID in a snapshot,
+// or nil if id is empty.
+func (sim *Simulation) snapshotSignal(id string) (*SignalItem, error) {
+	if id == "" {
+		return nil, nil
+	}
+	si, ok := sim.TrackItems[id].(*SignalItem)
+	if !ok {
+		return nil, fmt.Errorf("unknown signal in snapshot: %s", id)
+	}
+	return si, nil
+}
+
+// snapshotRoute returns the route with the given ID in a snapshot,
+// or nil if id is empty.
+func (sim *Simulation) snapshotRoute(id string) (*Route, error) {
+	if id == "" {
+		return nil, nil
+	}
+	r, ok := sim.Routes[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown route in snapshot: %s", id)
+	}
+	return r, nil
+}
+
+// formatSnapshotTime returns t in the format of FormatTime with milliseconds,
+// or an empty string if t is zero. Milliseconds are lost in the standard Time
+// JSON format, and hours over 24 keep the day of times after midnight.
+func formatSnapshotTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return FormatTime(t) + t.Format(".000")
+}
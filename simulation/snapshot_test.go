@@ -0,0 +1,141 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package simulation
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSnapshot(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	drainEvents := func(sim *Simulation) {
+		go func() {
+			for {
+				select {
+				case <-sim.EventChan:
+				case <-endChan:
+					return
+				}
+			}
+		}()
+	}
+	Convey("Testing simulation snapshots", t, func() {
+		var sim Simulation
+		err := json.Unmarshal(loadSim("testdata/demo.json"), &sim)
+		So(err, ShouldBeNil)
+		drainEvents(&sim)
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		So(sim.HasSnapshot(), ShouldBeFalse)
+		So(sim.Routes["1"].Deactivate(), ShouldBeNil)
+		So(sim.Routes["2"].Activate(true), ShouldBeNil)
+		sim.Trains[0].AppearTime = ParseTime("05:00:00")
+		for i := 0; i < 40; i++ {
+			sim.increaseTime(timeStep)
+			sim.updateTrains()
+		}
+		tr := sim.Trains[0]
+		So(tr.IsActive(), ShouldBeTrue)
+		data, err := sim.Snapshot()
+		So(err, ShouldBeNil)
+
+		var sim2 Simulation
+		err = json.Unmarshal(data, &sim2)
+		So(err, ShouldBeNil)
+		So(sim2.HasSnapshot(), ShouldBeTrue)
+		drainEvents(&sim2)
+		err = sim2.Initialize()
+		So(err, ShouldBeNil)
+		So(sim2.HasSnapshot(), ShouldBeFalse)
+		Convey("Clock and routes should be restored", func() {
+			So(sim2.Options.CurrentTime.Time, ShouldEqual, ParseTime("06:01:40").Time)
+			So(sim2.Routes["1"].State(), ShouldEqual, Deactivated)
+			So(sim2.Routes["2"].State(), ShouldEqual, Persistent)
+			So(sim2.TrackItems["7"].(*PointsItem).Reversed(), ShouldBeTrue)
+			So(sim2.TrackItems["7"].ActiveRoute().ID(), ShouldEqual, "2")
+		})
+		Convey("Trains should be restored with their internal state", func() {
+			So(sim2.Trains, ShouldHaveLength, 2)
+			tr2 := sim2.Trains[0]
+			So(tr2.ID(), ShouldEqual, "0")
+			So(tr2.ServiceCode, ShouldEqual, tr.ServiceCode)
+			So(tr2.TrainHead.TrackItemID, ShouldEqual, tr.TrainHead.TrackItemID)
+			So(tr2.TrainHead.PositionOnTI, ShouldEqual, tr.TrainHead.PositionOnTI)
+			So(tr2.Speed, ShouldEqual, tr.Speed)
+			So(tr2.Status, ShouldEqual, tr.Status)
+			So(tr2.effInitialDelay, ShouldEqual, tr.effInitialDelay)
+			So(tr2.minStopTime, ShouldEqual, tr.minStopTime)
+			So(tr2.signalActions, ShouldResemble, tr.signalActions)
+			So(tr2.actionIndex, ShouldEqual, tr.actionIndex)
+			So(tr2.actionTime.Time, ShouldEqual, tr.actionTime.Time)
+			So(tr2.lastSignal.ID(), ShouldEqual, tr.lastSignal.ID())
+			So(tr2.trainManager.Name(), ShouldEqual, tr.trainManager.Name())
+			item := sim2.TrackItems[tr.TrainHead.TrackItemID].underlying()
+			So(item.trainEndsFW, ShouldContainKey, tr2)
+			So(item.trainEndsFW[tr2], ShouldEqual, tr.TrainHead.PositionOnTI)
+		})
+		Convey("Track items should be restored with their occupation time", func() {
+			sim.updateTrackItems()
+			sim.increaseTime(timeStep)
+			data, err := sim.Snapshot()
+			So(err, ShouldBeNil)
+			var sim3 Simulation
+			So(json.Unmarshal(data, &sim3), ShouldBeNil)
+			drainEvents(&sim3)
+			So(sim3.Initialize(), ShouldBeNil)
+			occupied := 0
+			for id, ti := range sim.TrackItems {
+				since := ti.underlying().occupiedSince
+				if !since.IsZero() {
+					occupied++
+				}
+				So(sim3.TrackItems[id].underlying().occupiedSince, ShouldEqual, since)
+			}
+			So(occupied, ShouldBeGreaterThan, 0)
+		})
+		Convey("Signals should be restored with their train and aspect", func() {
+			for id, ti := range sim.TrackItems {
+				si, ok := ti.(*SignalItem)
+				if !ok {
+					continue
+				}
+				si2 := sim2.TrackItems[id].(*SignalItem)
+				So(si2.ActiveAspect().Name, ShouldEqual, si.ActiveAspect().Name)
+				if si.train != nil {
+					So(si2.train, ShouldEqual, sim2.Trains[0])
+				} else {
+					So(si2.train, ShouldBeNil)
+				}
+			}
+		})
+		Convey("Times after midnight should be restored on the same day", func() {
+			sim.Options.CurrentTime.Time = ParseTime("06:00:00").Time.Add(19*time.Hour + 250*time.Millisecond)
+			So(formatSnapshotTime(sim.Options.CurrentTime.Time), ShouldEqual, "25:00:00.250")
+			data, err := sim.Snapshot()
+			So(err, ShouldBeNil)
+			var sim3 Simulation
+			So(json.Unmarshal(data, &sim3), ShouldBeNil)
+			drainEvents(&sim3)
+			So(sim3.Initialize(), ShouldBeNil)
+			So(sim3.Options.CurrentTime.Time, ShouldEqual, sim.Options.CurrentTime.Time)
+			So(sim3.Trains[0].actionTime.Time, ShouldEqual, tr.actionTime.Time)
+		})
+		Convey("Restored simulation should run as the original one", func() {
+			for i := 0; i < 5; i++ {
+				sim.increaseTime(timeStep)
+				sim.updateTrains()
+				sim2.increaseTime(timeStep)
+				sim2.updateTrains()
+			}
+			So(sim2.Trains[0].TrainHead.TrackItemID, ShouldEqual, tr.TrainHead.TrackItemID)
+			So(sim2.Trains[0].TrainHead.PositionOnTI, ShouldEqual, tr.TrainHead.PositionOnTI)
+			So(sim2.Trains[0].Speed, ShouldEqual, tr.Speed)
+		})
+	})
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import "time"
+
+// An ObservedStop holds the actual times of a train at a place where it was
+// scheduled to stop, together with the scheduled times of its service line.
+type ObservedStop struct {
+	TrainID                string `json:"trainId"`
+	ServiceCode            string `json:"serviceCode"`
+	PlaceCode              string `json:"placeCode"`
+	PlannedTrackCode       string `json:"plannedTrackCode"`
+	TrackCode              string `json:"trackCode"`
+	ScheduledArrivalTime   Time   `json:"scheduledArrivalTime"`
+	ScheduledDepartureTime Time   `json:"scheduledDepartureTime"`
+	ArrivalTime            Time   `json:"arrivalTime"`
+	DepartureTime          Time   `json:"departureTime"`
+	// LineIndex is the index of the service line of this stop
+	LineIndex int `json:"lineIndex"`
+}
+
+// ArrivalDelay returns the delay of the train at arrival.
+//
+// It is negative if the train arrived early and zero if no arrival time is scheduled.
+func (st *ObservedStop) ArrivalDelay() time.Duration {
+	if st.ScheduledArrivalTime.IsZero() {
+		return 0
+	}
+	return st.ArrivalTime.Time.Sub(st.ScheduledArrivalTime.Time)
+}
+
+// WrongPlatform returns true if the train did not stop at the planned track.
+func (st *ObservedStop) WrongPlatform() bool {
+	return st.PlannedTrackCode != "" && st.TrackCode != st.PlannedTrackCode
+}
+
+// ObservedStops returns the stops of all trains at their scheduled places so far,
+// in chronological order of arrival.
+func (sim *Simulation) ObservedStops() []*ObservedStop {
+	return sim.observedStops
+}
+
+// recordArrival adds an ObservedStop for this train that has just stopped
+// at the place of its current service line.
+func (t *Train) recordArrival() {
+	line := t.Service().Lines[t.NextPlaceIndex]
+	st := ObservedStop{
+		TrainID:          t.ID(),
+		ServiceCode:      t.ServiceCode,
+		PlaceCode:        line.PlaceCode,
+		PlannedTrackCode: line.TrackCode,
+		TrackCode:        t.TrainHead.TrackItem().TrackCode(),
+		LineIndex:        t.NextPlaceIndex,
+	}
+	st.ScheduledArrivalTime.Time = line.ScheduledArrivalTime.Time
+	st.ScheduledDepartureTime.Time = line.ScheduledDepartureTime.Time
+	st.ArrivalTime.Time = t.simulation.Options.CurrentTime.Time
+	t.simulation.observedStops = append(t.simulation.observedStops, &st)
+}
+
+// recordDeparture sets the departure time of the last ObservedStop of this train.
+func (t *Train) recordDeparture() {
+	stops := t.simulation.observedStops
+	for i := len(stops) - 1; i >= 0; i-- {
+		if stops[i].TrainID != t.ID() {
+			continue
+		}
+		if stops[i].DepartureTime.IsZero() {
+			stops[i].DepartureTime.Time = t.simulation.Options.CurrentTime.Time
+		}
+		return
+	}
+}
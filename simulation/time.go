@@ -22,6 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -84,8 +86,43 @@ func (dg DelayGenerator) MarshalJSON() ([]byte, error) {
 	return json.Marshal(data)
 }
 
-// Yield a delay from this DelayGenerator
+// randSource is a source of random numbers from which delays are yielded.
+//
+// It is implemented by *rand.Rand.
+type randSource interface {
+	Intn(n int) int
+	Float64() float64
+}
+
+// globalRand is a randSource using the global math/rand functions.
+type globalRand struct{}
+
+// Intn calls rand.Intn
+func (globalRand) Intn(n int) int {
+	return rand.Intn(n)
+}
+
+// Float64 calls rand.Float64
+func (globalRand) Float64() float64 {
+	return rand.Float64()
+}
+
+// Yield a delay from this DelayGenerator using the global random source
 func (dg DelayGenerator) Yield() time.Duration {
+	return dg.yield(globalRand{})
+}
+
+// YieldFrom returns a random delay from this DelayGenerator using the given
+// random number generator, such as the one returned by Simulation.Rand.
+func (dg DelayGenerator) YieldFrom(rnd *rand.Rand) time.Duration {
+	return dg.yield(rnd)
+}
+
+// yield a delay from this DelayGenerator using the given random source
+func (dg DelayGenerator) yield(rnd randSource) time.Duration {
+	if len(dg.data) == 0 {
+		return 0
+	}
 	probas := []int{0}
 	cumsum := 0
 	for _, p := range dg.data {
@@ -94,7 +131,7 @@ func (dg DelayGenerator) Yield() time.Duration {
 	}
 
 	// First determine our segment
-	r0 := rand.Intn(100)
+	r0 := rnd.Intn(100)
 	seg := 0
 	for i := 0; i < len(probas)-1; i++ {
 		if probas[i] <= r0 && r0 <= probas[i+1] {
@@ -108,7 +145,7 @@ func (dg DelayGenerator) Yield() time.Duration {
 	}
 
 	// Then pick up a number inside our segment
-	r1 := rand.Float64()
+	r1 := rnd.Float64()
 	return time.Duration(r1*float64(dg.data[seg].high-dg.data[seg].low)+float64(dg.data[seg].low)) * time.Second
 }
 
@@ -152,19 +189,48 @@ func (h *Time) UnmarshalJSON(data []byte) error {
 
 // MarshalJSON for the Time type
 func (h Time) MarshalJSON() ([]byte, error) {
-	return json.Marshal(h.Time.Format("15:04:05"))
+	if h.IsZero() {
+		return json.Marshal("")
+	}
+	return json.Marshal(FormatTime(h.Time))
 }
 
 // ParseTime returns a Time object from its string representation in format 15:04:05
+//
+// Hours may be over 24 for times after midnight, in which case the time of the
+// following days is returned.
 func ParseTime(data string) Time {
+	days := 0
+	if parts := strings.SplitN(data, ":", 2); len(parts) == 2 {
+		if hours, err := strconv.Atoi(parts[0]); err == nil && hours >= 24 {
+			days = hours / 24
+			data = fmt.Sprintf("%02d:%s", hours%24, parts[1])
+		}
+	}
 	t, err := time.Parse("15:04:05", data)
 	if err != nil {
 		return Time{}
 	}
 	// We add 24 hours to make a difference between 00:00:00 and an empty Time
 	return Time{
-		Time: t.Add(24 * time.Hour),
+		Time: t.Add(time.Duration(days+1) * 24 * time.Hour),
+	}
+}
+
+// FormatTime returns the given time of a Time object in format 15:04:05, or
+// an empty string if it is zero.
+//
+// Times after midnight are given with hours over 24, so that they can be
+// parsed back with ParseTime.
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	hours := int(t.Sub(ParseTime("00:00:00").Time) / time.Hour)
+	if hours < 0 {
+		return t.Format("15:04:05")
 	}
+	return fmt.Sprintf("%02d:%s", hours, t.Format("04:05"))
 }
 
 // Add returns the time h + duration .
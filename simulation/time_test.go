@@ -74,3 +74,23 @@ func TestDelayGenerator(t *testing.T) {
 		})
 	})
 }
+
+func TestTime(t *testing.T) {
+	Convey("Testing Time", t, func() {
+		Convey("Times after midnight should be parsed on the following days", func() {
+			So(ParseTime("24:05:00").Time.Sub(ParseTime("00:05:00").Time), ShouldEqual, 24*time.Hour)
+			So(ParseTime("49:00:00").Time.Sub(ParseTime("01:00:00").Time), ShouldEqual, 48*time.Hour)
+			So(ParseTime("24:60:00").IsZero(), ShouldBeTrue)
+		})
+		Convey("Times after midnight should be formatted with hours over 24", func() {
+			So(FormatTime(time.Time{}), ShouldEqual, "")
+			So(FormatTime(ParseTime("23:59:59").Time), ShouldEqual, "23:59:59")
+			So(FormatTime(ParseTime("23:59:59").Time.Add(2*time.Second)), ShouldEqual, "24:00:01")
+			So(FormatTime(ParseTime("49:00:00").Time), ShouldEqual, "49:00:00")
+			h := ParseTime("25:30:00")
+			data, err := json.Marshal(&h)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `"25:30:00"`)
+		})
+	})
+}
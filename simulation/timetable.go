@@ -0,0 +1,107 @@
+// Copyright (C) 2008-2018 by Nicolas Piganeau and the TS2 TEAM
+// (See AUTHORS file)
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the
+// Free Software Foundation, Inc.,
+// 59 Temple Place - Suite 330, Boston, MA  02111-1307, USA.
+
+package simulation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// timetableHeader is the header of the CSV timetable
+var timetableHeader = []string{
+	"service", "description", "sequence", "place", "mustStop", "plannedTrack",
+	"scheduledArrival", "scheduledDeparture", "train", "track", "arrival", "departure", "arrivalDelay",
+}
+
+// WriteTimetableCSV writes the timetable of the simulation as CSV to w.
+//
+// There is a row for each service line with its scheduled times, services
+// being sorted by code. If trains have stopped at the place of a line, the
+// row is repeated for each of them with the train ID, the actual track and
+// times, and the arrival delay in seconds.
+func (sim *Simulation) WriteTimetableCSV(w io.Writer) error {
+	observed := make(map[string]map[int][]*ObservedStop)
+	for _, st := range sim.observedStops {
+		if observed[st.ServiceCode] == nil {
+			observed[st.ServiceCode] = make(map[int][]*ObservedStop)
+		}
+		observed[st.ServiceCode][st.LineIndex] = append(observed[st.ServiceCode][st.LineIndex], st)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(timetableHeader); err != nil {
+		return err
+	}
+	for _, code := range sim.serviceCodes() {
+		svc := sim.Services[code]
+		for i, line := range svc.Lines {
+			row := []string{
+				code,
+				svc.Description,
+				strconv.Itoa(i + 1),
+				line.PlaceCode,
+				strconv.FormatBool(line.MustStop),
+				line.TrackCode,
+				FormatTime(line.ScheduledArrivalTime.Time),
+				FormatTime(line.ScheduledDepartureTime.Time),
+			}
+			stops := observed[code][i]
+			if len(stops) == 0 {
+				if err := cw.Write(append(row, "", "", "", "", "")); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, st := range stops {
+				delay := ""
+				if !st.ScheduledArrivalTime.IsZero() {
+					delay = strconv.Itoa(int(st.ArrivalDelay() / time.Second))
+				}
+				obsRow := append(row[:len(row):len(row)],
+					st.TrainID,
+					st.TrackCode,
+					FormatTime(st.ArrivalTime.Time),
+					FormatTime(st.DepartureTime.Time),
+					delay,
+				)
+				if err := cw.Write(obsRow); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("unable to write timetable: %s", err)
+	}
+	return nil
+}
+
+// serviceCodes returns the codes of the services of the simulation, sorted.
+func (sim *Simulation) serviceCodes() []string {
+	codes := make([]string, 0, len(sim.Services))
+	for code := range sim.Services {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
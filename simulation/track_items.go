@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // bigFloat is a large number used for the length of an EndItem. It must be bigger
@@ -59,6 +60,18 @@ type LineItemManager interface {
 	IsFailed(*LineItem) bool
 }
 
+// A LineItemFailureManager is a LineItemManager that simulates track circuit
+// failures and repairs.
+//
+// LineItemManager implementations may optionally implement this interface.
+type LineItemFailureManager interface {
+	// SetFailed sets or repairs a track circuit failure on the given LineItem.
+	SetFailed(*LineItem, bool)
+	// Update is called for each LineItem at each time step of the simulation,
+	// so that track circuits can fail or be repaired.
+	Update(*LineItem)
+}
+
 // An ItemsNotLinkedError is returned when two TrackItem instances that are assumed
 // to be linked are not.
 type ItemsNotLinkedError struct {
@@ -176,6 +189,21 @@ type TrackItem interface {
 	// ActiveRoutePreviousItem returns the previous item in the active route direction
 	ActiveRoutePreviousItem() TrackItem
 
+	// lockOverlap locks this item in the overlap of the given route.
+	lockOverlap(r *Route)
+
+	// releaseOverlap releases the lock of the overlap of the given route on
+	// this item. The item stays locked by the overlaps of other routes.
+	releaseOverlap(r *Route)
+
+	// OverlapRoute returns the first route the overlap of which is locked on
+	// this item, or nil if the item is not in a locked overlap.
+	OverlapRoute() *Route
+
+	// OverlapRoutes returns all the routes the overlap of which is locked on
+	// this item, in the order they were locked.
+	OverlapRoutes() []*Route
+
 	// trainHeadActions performs the actions to be done when a train head reaches this TrackItem
 	trainHeadActions(*Train)
 
@@ -239,6 +267,8 @@ type trackStruct struct {
 	trainEndsBK    map[*Train]float64
 	trainEndMutex  sync.RWMutex
 	triggers       []func(TrackItem)
+	occupiedSince  time.Time
+	overlapRoutes  []*Route
 }
 
 // routeID returns the unique routeID of this TrackItem, which is the index of this
@@ -384,6 +414,53 @@ func (t *trackStruct) ActiveRoutePreviousItem() TrackItem {
 	return t.arPreviousItem
 }
 
+// lockOverlap locks this item in the overlap of the given route.
+func (t *trackStruct) lockOverlap(r *Route) {
+	for _, ol := range t.overlapRoutes {
+		if ol == r {
+			return
+		}
+	}
+	t.overlapRoutes = append(t.overlapRoutes, r)
+	t.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: t.full(),
+	})
+}
+
+// releaseOverlap releases the lock of the overlap of the given route on this
+// item. The item stays locked by the overlaps of other routes.
+func (t *trackStruct) releaseOverlap(r *Route) {
+	for i, ol := range t.overlapRoutes {
+		if ol != r {
+			continue
+		}
+		t.overlapRoutes = append(t.overlapRoutes[:i:i], t.overlapRoutes[i+1:]...)
+		t.simulation.sendEvent(&Event{
+			Name:   TrackItemChangedEvent,
+			Object: t.full(),
+		})
+		return
+	}
+}
+
+// OverlapRoute returns the first route the overlap of which is locked on this
+// item, or nil if the item is not in a locked overlap.
+func (t *trackStruct) OverlapRoute() *Route {
+	if len(t.overlapRoutes) == 0 {
+		return nil
+	}
+	return t.overlapRoutes[0]
+}
+
+// OverlapRoutes returns all the routes the overlap of which is locked on this
+// item, in the order they were locked.
+func (t *trackStruct) OverlapRoutes() []*Route {
+	res := make([]*Route, len(t.overlapRoutes))
+	copy(res, t.overlapRoutes)
+	return res
+}
+
 // trainHeadActions performs the actions to be done when a train head reaches this TrackItem
 func (t *trackStruct) trainHeadActions(train *Train) {
 	for _, trigger := range t.triggers {
@@ -426,6 +503,18 @@ func (t *trackStruct) TrainPresent() bool {
 	return len(t.trainEndsFW)+len(t.trainEndsBK) > 0
 }
 
+// updateOccupation records the simulation time at which this item became
+// occupied by a train, or resets it if no train is present.
+func (t *trackStruct) updateOccupation() {
+	if !t.TrainPresent() {
+		t.occupiedSince = time.Time{}
+		return
+	}
+	if t.occupiedSince.IsZero() {
+		t.occupiedSince = t.simulation.Options.CurrentTime.Time
+	}
+}
+
 // resetActiveRoute resets route information on this item.
 func (t *trackStruct) resetActiveRoute() {
 	t.activeRoute = nil
@@ -514,6 +603,10 @@ func (t *trackStruct) asJSONStruct() jsonTrackStruct {
 	if t.arPreviousItem != nil {
 		arpiID = t.arPreviousItem.ID()
 	}
+	var olID string
+	if ol := t.OverlapRoute(); ol != nil {
+		olID = ol.ID()
+	}
 	t.trainEndMutex.RLock()
 	defer t.trainEndMutex.RUnlock()
 	tEndsFW := make(map[string]float64)
@@ -539,6 +632,7 @@ func (t *trackStruct) asJSONStruct() jsonTrackStruct {
 		PlaceCode:        t.PlaceCode,
 		ActiveRoute:      arID,
 		ARPreviousItem:   arpiID,
+		OverlapRoute:     olID,
 		TrainEndsFW:      tEndsFW,
 		TrainEndsBK:      tEndsBK,
 		TsTrackCode:      t.TsTrackCode,
@@ -563,6 +657,7 @@ type jsonTrackStruct struct {
 	PlaceCode        string                    `json:"placeCode"`
 	ActiveRoute      string                    `json:"activeRoute"`
 	ARPreviousItem   string                    `json:"activeRoutePreviousItem"`
+	OverlapRoute     string                    `json:"overlapRoute"`
 	TrainEndsFW      map[string]float64        `json:"trainEndsFW"`
 	TrainEndsBK      map[string]float64        `json:"trainEndsBK"`
 	TsTrackCode      string                    `json:"trackCode"`
@@ -583,10 +678,16 @@ var _ TrackItem = new(Place)
 
 // A LineItem is a resizable TrackItem that represent a simple railway line and
 // is used to connect two TrackItem's together.
+//
+// Gradient is given in per mille and is positive if the line climbs from its
+// origin, connected to the previous item, to its end.
 type LineItem struct {
 	trackStruct
-	Xf float64 `json:"xf"`
-	Yf float64 `json:"yf"`
+	Xf       float64 `json:"xf"`
+	Yf       float64 `json:"yf"`
+	Gradient float64 `json:"gradient,omitempty"`
+
+	reportedFailed bool
 }
 
 // Type returns the name of the type of this item
@@ -599,17 +700,119 @@ func (li *LineItem) End() Point {
 	return Point{li.Xf, li.Yf}
 }
 
+// GradientAt returns the gradient of this LineItem in per mille as seen by a
+// train at the given position. It is positive if the train is climbing.
+func (li *LineItem) GradientAt(pos Position) float64 {
+	if pos.PreviousItemID == li.PreviousTiID {
+		return li.Gradient
+	}
+	return -li.Gradient
+}
+
+// TrainPresent returns true if at least one train is present on this LineItem
+// or if its track circuit has failed.
+func (li *LineItem) TrainPresent() bool {
+	return li.trackStruct.TrainPresent() || li.IsFailed()
+}
+
+// IsFailed returns true if the track circuit of this LineItem has failed.
+func (li *LineItem) IsFailed() bool {
+	return li.simulation.currentLineItemManager().IsFailed(li)
+}
+
+// SetFailed sets or repairs a track circuit failure on this LineItem.
+//
+// It does nothing if the line manager does not implement
+// LineItemFailureManager.
+func (li *LineItem) SetFailed(failed bool) {
+	fm, ok := li.simulation.currentLineItemManager().(LineItemFailureManager)
+	if !ok {
+		return
+	}
+	fm.SetFailed(li, failed)
+	li.updateFailure()
+}
+
+// updateCircuit lets the line manager make the track circuit of this LineItem
+// fail or be repaired, and notifies the change if any.
+func (li *LineItem) updateCircuit() {
+	fm, ok := li.simulation.currentLineItemManager().(LineItemFailureManager)
+	if !ok {
+		return
+	}
+	fm.Update(li)
+	li.updateFailure()
+}
+
+// updateFailure checks whether the track circuit of this LineItem has failed
+// and, if it changed, notifies clients and updates the signals protecting
+// this item. Failures and repairs are logged.
+func (li *LineItem) updateFailure() {
+	failed := li.simulation.currentLineItemManager().IsFailed(li)
+	if failed == li.reportedFailed {
+		return
+	}
+	li.reportedFailed = failed
+	name := li.Name()
+	if name == "" {
+		name = li.ID()
+	}
+	if failed {
+		li.simulation.MessageLogger.addMessage(fmt.Sprintf("Track circuit %s has failed", name), simulationMsg)
+	} else {
+		li.simulation.MessageLogger.addMessage(fmt.Sprintf("Track circuit %s has been repaired", name), simulationMsg)
+	}
+	li.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: li.full(),
+	})
+	for _, trigger := range li.triggers {
+		trigger(li.full())
+	}
+	li.updateSignalsBehind()
+}
+
+// updateSignalsBehind updates the first signal behind this LineItem in each
+// direction, that is the signals protecting this item.
+//
+// On a looped track without signals, the search stops when it comes back to
+// this LineItem.
+func (li *LineItem) updateSignalsBehind() {
+	for _, prevID := range []string{li.PreviousTiID, li.NextTiID} {
+		pos := Position{
+			simulation:     li.simulation,
+			TrackItemID:    li.ID(),
+			PreviousItemID: prevID,
+		}
+		for pos.PreviousItem() != nil {
+			pos = pos.Previous()
+			ti := pos.TrackItem()
+			if ti.Type() == TypeEnd || ti.ID() == li.ID() {
+				break
+			}
+			if ti.Type() == TypeSignal && ti.IsOnPosition(pos) {
+				ti.(*SignalItem).updateSignalState()
+				break
+			}
+		}
+	}
+}
+
 // MarshalJSON method for LineItem
 func (li *LineItem) MarshalJSON() ([]byte, error) {
 	type auxLI struct {
 		jsonTrackStruct
-		Xf float64 `json:"xf"`
-		Yf float64 `json:"yf"`
+		Xf       float64 `json:"xf"`
+		Yf       float64 `json:"yf"`
+		Gradient float64 `json:"gradient,omitempty"`
+		Failed   bool    `json:"failed"`
 	}
 	aLI := auxLI{
 		jsonTrackStruct: li.asJSONStruct(),
 		Xf:              li.Xf,
 		Yf:              li.Yf,
+		Gradient:        li.Gradient,
+		Failed:          li.IsFailed(),
 	}
 	return json.Marshal(aLI)
 }
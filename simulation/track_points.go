@@ -18,7 +18,10 @@
 
 package simulation
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // A PointsItemManager simulates the physical points, in particular delay in points
 // position and breakdowns
@@ -82,6 +85,8 @@ type PointsItem struct {
 	Yr          float64 `json:"yr"`
 	ReverseTiId string  `json:"reverseTiId"`
 	PairedTiId  string  `json:"pairedTiId"`
+
+	reportedDirection PointDirection
 }
 
 // Type returns the name of the type of this item
@@ -142,10 +147,15 @@ func (pi *PointsItem) PairedItem() *PointsItem {
 // Reversed returns true if the points are in the reversed position, false
 // otherwise
 func (pi *PointsItem) Reversed() bool {
-	dir := pointsItemManager.Direction(pi)
+	dir := pi.simulation.currentPointsItemManager().Direction(pi)
 	return dir == DirectionReversed
 }
 
+// Direction returns the direction reported by the points manager for these points.
+func (pi *PointsItem) Direction() PointDirection {
+	return pi.simulation.currentPointsItemManager().Direction(pi)
+}
+
 // IsConnected returns true if this TrackItem is connected to the given
 // TrackItem, false otherwise
 func (pi *PointsItem) IsConnected(oti TrackItem) bool {
@@ -188,7 +198,7 @@ func (pi *PointsItem) FollowingItem(precedingItem TrackItem, dir PointDirection)
 // previous gives the direction.
 func (pi *PointsItem) setActiveRoute(r *Route, previous TrackItem) {
 	if r != nil {
-		pointsItemManager.SetDirection(pi, r.Directions[pi.ID()])
+		pi.simulation.currentPointsItemManager().SetDirection(pi, r.Directions[pi.ID()])
 	}
 	// Send event for pairedItem
 	if pi.PairedItem() != nil {
@@ -197,24 +207,66 @@ func (pi *PointsItem) setActiveRoute(r *Route, previous TrackItem) {
 			Object: pi.PairedItem(),
 		})
 	}
-	// TODO We should check here whether the points have failed or not
-	// and delay route activation.
+	// Signals of the route stay at danger until the points are in position,
+	// which is checked by updateDirection at each time step.
 	pi.trackStruct.setActiveRoute(r, previous)
 }
 
+// lockOverlap locks these points in the overlap of the given route. Points
+// are commanded to the direction required by the overlap.
+func (pi *PointsItem) lockOverlap(r *Route) {
+	pi.simulation.currentPointsItemManager().SetDirection(pi, r.Overlap.Directions[pi.ID()])
+	pi.trackStruct.lockOverlap(r)
+}
+
+// updateDirection checks the direction reported by the points manager and,
+// if it changed, notifies clients and updates the signals protecting the active
+// route of these points and the route of their overlap. Points failures and
+// repairs are logged.
+func (pi *PointsItem) updateDirection() {
+	dir := pi.simulation.currentPointsItemManager().Direction(pi)
+	if dir == pi.reportedDirection {
+		return
+	}
+	name := pi.Name()
+	if name == "" {
+		name = pi.ID()
+	}
+	switch {
+	case dir == DirectionFailed:
+		pi.simulation.MessageLogger.addMessage(fmt.Sprintf("Points %s have failed", name), simulationMsg)
+	case pi.reportedDirection == DirectionFailed:
+		pi.simulation.MessageLogger.addMessage(fmt.Sprintf("Points %s have been repaired", name), simulationMsg)
+	}
+	pi.reportedDirection = dir
+	pi.simulation.sendEvent(&Event{
+		Name:   TrackItemChangedEvent,
+		Object: pi,
+	})
+	if pi.activeRoute != nil {
+		pi.activeRoute.BeginSignal().updateSignalState()
+	}
+	for _, ol := range pi.overlapRoutes {
+		if ol.IsActive() {
+			ol.BeginSignal().updateSignalState()
+		}
+	}
+}
+
 // MarshalJSON method for PointsItem
 func (pi *PointsItem) MarshalJSON() ([]byte, error) {
 	type auxPI struct {
 		jsonTrackStruct
-		Xc          float64 `json:"xf"`
-		Yc          float64 `json:"yf"`
-		Xn          float64 `json:"xn"`
-		Yn          float64 `json:"yn"`
-		Xr          float64 `json:"xr"`
-		Yr          float64 `json:"yr"`
-		ReverseTiId string  `json:"reverseTiId"`
-		PairedTiId  string  `json:"pairedTiId"`
-		Reversed    bool    `json:"reversed"`
+		Xc          float64        `json:"xf"`
+		Yc          float64        `json:"yf"`
+		Xn          float64        `json:"xn"`
+		Yn          float64        `json:"yn"`
+		Xr          float64        `json:"xr"`
+		Yr          float64        `json:"yr"`
+		ReverseTiId string         `json:"reverseTiId"`
+		PairedTiId  string         `json:"pairedTiId"`
+		Reversed    bool           `json:"reversed"`
+		Direction   PointDirection `json:"direction"`
 	}
 	aPI := auxPI{
 		jsonTrackStruct: pi.asJSONStruct(),
@@ -227,6 +279,7 @@ func (pi *PointsItem) MarshalJSON() ([]byte, error) {
 		ReverseTiId:     pi.ReverseTiId,
 		PairedTiId:      pi.PairedTiId,
 		Reversed:        pi.Reversed(),
+		Direction:       pi.Direction(),
 	}
 	return json.Marshal(aPI)
 }
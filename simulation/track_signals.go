@@ -32,6 +32,20 @@ type SignalItemManager interface {
 	GetAspect(*SignalItem) *SignalAspect
 }
 
+// A SignalItemFailureManager is a SignalItemManager that simulates signal
+// lamp failures and repairs.
+//
+// SignalItemManager implementations may optionally implement this interface.
+type SignalItemFailureManager interface {
+	// IsFailed returns true if the lamps of the given signal have failed
+	IsFailed(*SignalItem) bool
+	// SetFailed sets or repairs a lamp failure on the given signal.
+	SetFailed(*SignalItem, bool)
+	// Update is called for each signal at each time step of the simulation,
+	// so that signal lamps can fail or be repaired.
+	Update(*SignalItem)
+}
+
 // signalLineStyle holds the possible representation shapes for the line at the
 // base of the signal.
 type signalLineStyle uint8
@@ -97,7 +111,7 @@ func (sa *SignalAction) UnmarshalJSON(data []byte) error {
 
 // MarshalJSON for the SignalAction Type
 func (sa *SignalAction) MarshalJSON() ([]byte, error) {
-	return json.Marshal([3]float64{float64(sa.Target), sa.Speed, float64(sa.Duration)})
+	return json.Marshal([3]float64{float64(sa.Target), sa.Speed, sa.Duration.Seconds()})
 }
 
 // SignalAspect class represents an aspect of a signal, that is a combination of
@@ -147,9 +161,14 @@ type ConditionType interface {
 }
 
 // A Condition on the current simulation context used for defining signal state.
+//
+// The parameters of the condition are taken for each signal from its custom
+// property named Property, or named after the code of the condition type if
+// Property is empty.
 type Condition struct {
-	Type   ConditionType
-	Values []string
+	Type     ConditionType
+	Values   []string
+	Property string
 }
 
 // IsMet returns true if this condition is met for the given SignalItem
@@ -157,35 +176,66 @@ func (c Condition) IsMet(item *SignalItem, params []string) bool {
 	return c.Type.Solve(item, c.Values, params)
 }
 
+// propertyName returns the name of the custom property holding the
+// parameters of this condition.
+func (c Condition) propertyName() string {
+	if c.Property != "" {
+		return c.Property
+	}
+	return c.Type.Code()
+}
+
+// params returns the parameters of this condition for the given signal
+// displaying the given aspect.
+func (c Condition) params(item *SignalItem, aspectName string) []string {
+	return item.CustomProperties[c.propertyName()][aspectName]
+}
+
 // A SignalState is an aspect of a signal with a set of conditions to display this
 // aspect.
+//
+// Conditions are either given as a map of conditions that must all be met, or
+// as an Expression, in which case Conditions is empty.
 type SignalState struct {
 	AspectName string
 	Aspect     *SignalAspect
 	Conditions map[string]Condition
+	Expression *ConditionExpression
 }
 
 // conditionsMet returns true if all conditions of this SignalState are met (or if
 // there is no conditions) on the given signalItem instance.
 func (s *SignalState) conditionsMet(signal *SignalItem) bool {
+	if s.Expression != nil {
+		return s.Expression.IsMet(signal, s.Aspect.Name)
+	}
 	for _, c := range s.Conditions {
-		var params []string
-		props, ok := signal.CustomProperties[c.Type.Code()]
-		if ok {
-			params = props[s.Aspect.Name]
-		}
-		if !c.IsMet(signal, params) {
+		if !c.IsMet(signal, c.params(signal, s.Aspect.Name)) {
 			return false
 		}
 	}
 	return true
 }
 
+// allConditions returns all the conditions of this SignalState, whether they
+// are given as a map or as an expression.
+func (s *SignalState) allConditions() []Condition {
+	if s.Expression != nil {
+		return s.Expression.conditions()
+	}
+	res := make([]Condition, 0, len(s.Conditions))
+	for _, c := range s.Conditions {
+		res = append(res, c)
+	}
+	return res
+}
+
 // UnmarshalJSON for the SignalState Type
 func (s *SignalState) UnmarshalJSON(data []byte) error {
 	var rawSignalState struct {
 		AspectName string              `json:"aspectName"`
 		Conditions map[string][]string `json:"conditions"`
+		Expression string              `json:"expression"`
 	}
 	if err := json.Unmarshal(data, &rawSignalState); err != nil {
 		return fmt.Errorf("unable to read signal state: %s (%s)", data, err)
@@ -194,6 +244,21 @@ func (s *SignalState) UnmarshalJSON(data []byte) error {
 	if s.Conditions == nil {
 		s.Conditions = make(map[string]Condition)
 	}
+	// No condition types can be registered once signal states are loaded
+	conditionTypesMutex.Lock()
+	defer conditionTypesMutex.Unlock()
+	conditionTypesLoaded = true
+	if rawSignalState.Expression != "" {
+		if len(rawSignalState.Conditions) > 0 {
+			return fmt.Errorf("signal state %s has both conditions and an expression", s.AspectName)
+		}
+		expr, err := parseConditionExpression(rawSignalState.Expression, signalConditionTypes)
+		if err != nil {
+			return err
+		}
+		s.Expression = expr
+		return nil
+	}
 	for k, v := range rawSignalState.Conditions {
 		ct, ok := signalConditionTypes[k]
 		if !ok {
@@ -209,6 +274,15 @@ func (s *SignalState) UnmarshalJSON(data []byte) error {
 
 // MarshalJSON for the SignalState type
 func (s *SignalState) MarshalJSON() ([]byte, error) {
+	if s.Expression != nil {
+		return json.Marshal(struct {
+			AspectName string `json:"aspectName"`
+			Expression string `json:"expression"`
+		}{
+			AspectName: s.Aspect.Name,
+			Expression: s.Expression.String(),
+		})
+	}
 	var rawSignalState struct {
 		AspectName string              `json:"aspectName"`
 		Conditions map[string][]string `json:"conditions"`
@@ -260,6 +334,8 @@ type SignalItem struct {
 	previousActiveRoute *Route
 	nextActiveRoute     *Route
 	activeAspect        *SignalAspect
+	reportedFailed      bool
+	updatedEachStep     bool
 }
 
 // initialize this signalItem
@@ -269,12 +345,12 @@ func (si *SignalItem) initialize() error {
 	}
 	si.activeAspect = si.SignalType().getDefaultAspect()
 	for _, st := range si.SignalType().States {
-		for ct := range st.Conditions {
+		for _, cond := range st.allConditions() {
 			var params []string
-			for _, vals := range si.CustomProperties[ct] {
+			for _, vals := range si.CustomProperties[cond.propertyName()] {
 				params = append(params, vals...)
 			}
-			signalConditionTypes[ct].SetupTriggers(si, params)
+			cond.Type.SetupTriggers(si, params)
 		}
 	}
 	return nil
@@ -432,12 +508,7 @@ func (si *SignalItem) updateSignalState(previous ...bool) {
 		return
 	}
 	oldAspect := si.activeAspect
-	switch signalItemManager {
-	case nil:
-		si.activeAspect = si.SignalType().GetAspect(si)
-	default:
-		si.activeAspect = signalItemManager.GetAspect(si)
-	}
+	si.activeAspect = si.computeAspect()
 	if !oldAspect.Equals(si.activeAspect) {
 		si.simulation.sendEvent(&Event{
 			Name:   SignalaspectChangedEvent,
@@ -455,6 +526,138 @@ func (si *SignalItem) updateSignalState(previous ...bool) {
 	})
 }
 
+// computeAspect returns the aspect that this signal should display.
+func (si *SignalItem) computeAspect() *SignalAspect {
+	sm := si.simulation.currentSignalItemManager()
+	switch {
+	case si.nextActiveRoute != nil && !si.nextActiveRoute.pointsInPosition():
+		// Points of the route are moving or have failed
+		return si.SignalType().getDefaultAspect()
+	case sm == nil:
+		return si.SignalType().GetAspect(si)
+	default:
+		return sm.GetAspect(si)
+	}
+}
+
+// UpdateStateEachStep makes this signal check its aspect at each step of the
+// simulation, instead of only when a train enters the items or a route is set.
+//
+// It must be called from the SetupTriggers method of condition types that
+// depend on train movements or on time.
+func (si *SignalItem) UpdateStateEachStep() {
+	si.updatedEachStep = true
+}
+
+// updateStepState updates the signal state if this signal is updated at each
+// step and its aspect has changed.
+func (si *SignalItem) updateStepState() {
+	if !si.updatedEachStep {
+		return
+	}
+	if si.computeAspect().Equals(si.activeAspect) {
+		return
+	}
+	si.updateSignalState()
+}
+
+// approachingTrain returns the train the head of which is the nearest behind
+// this signal, in the direction of this signal, together with the distance
+// between the train head and this signal.
+//
+// Only trains within maxDistance of this signal are taken into account. If
+// there is no such train, approachingTrain returns nil.
+func (si *SignalItem) approachingTrain(maxDistance float64) (*Train, float64) {
+	var distance float64
+	for pos := si.Position().Previous(); pos.TrackItem().Type() != TypeEnd && distance <= maxDistance; pos = pos.Previous() {
+		var (
+			nearest     *Train
+			minDistance = bigFloat
+		)
+		for _, t := range si.simulation.Trains {
+			if t.Status == Inactive || t.Status == Out || t.TrainHead.TrackItemID != pos.TrackItemID || t.TrainHead.PreviousItemID != pos.PreviousItemID {
+				continue
+			}
+			d := distance + pos.TrackItem().RealLength() - t.TrainHead.PositionOnTI
+			if d < minDistance {
+				nearest = t
+				minDistance = d
+			}
+		}
+		if nearest != nil {
+			if minDistance > maxDistance {
+				return nil, 0
+			}
+			return nearest, minDistance
+		}
+		distance += pos.TrackItem().RealLength()
+	}
+	return nil, 0
+}
+
+// IsFailed returns true if the lamps of this signal have failed.
+func (si *SignalItem) IsFailed() bool {
+	fm, ok := si.simulation.currentSignalItemManager().(SignalItemFailureManager)
+	if !ok {
+		return false
+	}
+	return fm.IsFailed(si)
+}
+
+// SetFailed sets or repairs a lamp failure on this signal.
+//
+// It does nothing if the signals manager does not implement
+// SignalItemFailureManager.
+func (si *SignalItem) SetFailed(failed bool) {
+	fm, ok := si.simulation.currentSignalItemManager().(SignalItemFailureManager)
+	if !ok {
+		return
+	}
+	fm.SetFailed(si, failed)
+	si.updateFailure()
+}
+
+// FailedAspect returns the aspect shown by this signal when its lamps have
+// failed, that is the dark aspect of the signal library if it is defined, or
+// the most restrictive aspect of the signal type otherwise.
+func (si *SignalItem) FailedAspect() *SignalAspect {
+	if si.simulation.SignalLib.darkAspect != nil {
+		return si.simulation.SignalLib.darkAspect
+	}
+	return si.SignalType().getDefaultAspect()
+}
+
+// updateLamps lets the signal manager make the lamps of this signal fail or
+// be repaired, and notifies the change if any.
+func (si *SignalItem) updateLamps() {
+	fm, ok := si.simulation.currentSignalItemManager().(SignalItemFailureManager)
+	if !ok {
+		return
+	}
+	fm.Update(si)
+	si.updateFailure()
+}
+
+// updateFailure checks whether the lamps of this signal have failed and, if
+// it changed, updates the signal aspect and warns the player.
+func (si *SignalItem) updateFailure() {
+	failed := si.IsFailed()
+	if failed == si.reportedFailed {
+		return
+	}
+	si.reportedFailed = failed
+	name := si.Name()
+	if name == "" {
+		name = si.ID()
+	}
+	if failed {
+		si.simulation.MessageLogger.addMessage(fmt.Sprintf("Signal %s has failed", name), playerWarningMsg)
+	} else {
+		si.simulation.MessageLogger.addMessage(fmt.Sprintf("Signal %s has been repaired", name), playerWarningMsg)
+	}
+	si.updateSignalState()
+}
+
 // resetNextActiveRoute information. If route is not nil, do
 // this only if the nextActiveRoute is equal to route.
 func (si *SignalItem) resetNextActiveRoute(r *Route) {
@@ -487,6 +690,8 @@ func (si *SignalItem) MarshalJSON() ([]byte, error) {
 		PreviousActiveRoute string  `json:"previousActiveRoute"`
 		NextActiveRoute     string  `json:"nextActiveRoute"`
 		ActiveAspect        string  `json:"activeAspect"`
+		ARSEnabled          bool    `json:"arsEnabled"`
+		Failed              bool    `json:"failed"`
 	}
 	var parID, narID string
 	if si.previousActiveRoute != nil {
@@ -509,6 +714,8 @@ func (si *SignalItem) MarshalJSON() ([]byte, error) {
 		PreviousActiveRoute: parID,
 		NextActiveRoute:     narID,
 		ActiveAspect:        si.activeAspect.Name,
+		ARSEnabled:          si.ARSEnabled(),
+		Failed:              si.IsFailed(),
 	}
 	d, err := json.Marshal(aSI)
 	return d, err
@@ -519,8 +726,11 @@ var _ TrackItem = new(SignalItem)
 // SignalLibrary holds the information about the signal types and signal aspects
 // available in the simulation.
 type SignalLibrary struct {
-	Aspects map[string]*SignalAspect `json:"signalAspects"`
-	Types   map[string]*SignalType   `json:"signalTypes"`
+	Aspects        map[string]*SignalAspect `json:"signalAspects"`
+	Types          map[string]*SignalType   `json:"signalTypes"`
+	DarkAspectName string                   `json:"darkAspect,omitempty"`
+
+	darkAspect *SignalAspect
 }
 
 // initialize this SignalLibrary
@@ -538,5 +748,12 @@ func (sl *SignalLibrary) initialize() error {
 			t.States[i].Aspect = asp
 		}
 	}
+	if sl.DarkAspectName != "" {
+		asp, ok := sl.Aspects[sl.DarkAspectName]
+		if !ok {
+			return fmt.Errorf("no aspect with code %s found", sl.DarkAspectName)
+		}
+		sl.darkAspect = asp
+	}
 	return nil
 }
@@ -62,3 +62,185 @@ func TestFollowingItem(t *testing.T) {
 		})
 	})
 }
+
+type testConditionType struct{}
+
+func (tct testConditionType) Code() string {
+	return "TEST_CONDITION"
+}
+
+func (tct testConditionType) Solve(item *SignalItem, values []string, params []string) bool {
+	return len(values) > 0 && values[0] == "true"
+}
+
+func (tct testConditionType) SetupTriggers(item *SignalItem, params []string) {}
+
+func init() {
+	RegisterConditionType(testConditionType{}, "Met if the first value is true.")
+}
+
+func TestConditionTypes(t *testing.T) {
+	Convey("Testing condition types registration", t, func() {
+		Convey("Registered condition types should be listed", func() {
+			cts := ConditionTypes()
+			So(cts, ShouldContain, ConditionTypeInfo{Code: "NEXT_ROUTE_ACTIVE", Description: "Met if a route is set starting from this signal."})
+			So(cts, ShouldContain, ConditionTypeInfo{Code: "TEST_CONDITION", Description: "Met if the first value is true."})
+		})
+		Convey("Registered condition types should be usable in signal states", func() {
+			var st SignalState
+			err := json.Unmarshal([]byte(`{"aspectName": "UK_CLEAR", "conditions": {"TEST_CONDITION": ["true"]}}`), &st)
+			So(err, ShouldBeNil)
+			So(st.Conditions["TEST_CONDITION"].IsMet(nil, nil), ShouldBeTrue)
+		})
+		Convey("Unknown condition types should not be loaded", func() {
+			var st SignalState
+			err := json.Unmarshal([]byte(`{"aspectName": "UK_CLEAR", "conditions": {"UNKNOWN": []}}`), &st)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown condition type: UNKNOWN")
+		})
+		Convey("Condition types should not be registered after signal states are loaded", func() {
+			var st SignalState
+			err := json.Unmarshal([]byte(`{"aspectName": "UK_CLEAR", "conditions": {}}`), &st)
+			So(err, ShouldBeNil)
+			So(func() { RegisterConditionType(RouteSet{}, "") }, ShouldPanic)
+		})
+	})
+}
+
+func TestConditionExpressions(t *testing.T) {
+	Convey("Testing condition expressions", t, func() {
+		parse := func(expr string) (*ConditionExpression, error) {
+			conditionTypesMutex.RLock()
+			defer conditionTypesMutex.RUnlock()
+			return parseConditionExpression(expr, signalConditionTypes)
+		}
+		Convey("Expressions should be parsed with AND taking precedence over OR", func() {
+			ce, err := parse("ROUTES_SET[ROUTES_A] or ROUTES_SET[ROUTES_B] and not TRAIN_PRESENT_ON_ITEMS")
+			So(err, ShouldBeNil)
+			So(ce.Operator, ShouldEqual, OperatorOr)
+			So(ce.Operands, ShouldHaveLength, 2)
+			So(ce.Operands[0].Condition.Property, ShouldEqual, "ROUTES_A")
+			So(ce.Operands[1].Operator, ShouldEqual, OperatorAnd)
+			So(ce.Operands[1].Operands[1].Operator, ShouldEqual, OperatorNot)
+			So(ce.String(), ShouldEqual, "ROUTES_SET[ROUTES_A] OR ROUTES_SET[ROUTES_B] AND NOT TRAIN_PRESENT_ON_ITEMS")
+			So(ce.conditions(), ShouldHaveLength, 3)
+		})
+		Convey("Expressions should be written back with the needed parentheses", func() {
+			ce, err := parse("(ROUTES_SET[ROUTES_A] OR ROUTES_SET[ROUTES_B]) AND NOT (NEXT_ROUTE_ACTIVE AND NEXT_SIGNAL_ASPECTS(UK_CLEAR,UK_CAUTION!))")
+			So(err, ShouldBeNil)
+			So(ce.String(), ShouldEqual, "(ROUTES_SET[ROUTES_A] OR ROUTES_SET[ROUTES_B]) AND NOT (NEXT_ROUTE_ACTIVE AND NEXT_SIGNAL_ASPECTS(UK_CLEAR, UK_CAUTION!))")
+			So(ce.Operands[1].Operands[0].Operands[1].Condition.Values, ShouldResemble, []string{"UK_CLEAR", "UK_CAUTION!"})
+		})
+		Convey("Invalid expressions should not be parsed", func() {
+			_, err := parse("")
+			So(err, ShouldNotBeNil)
+			_, err = parse("UNKNOWN OR NEXT_ROUTE_ACTIVE")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `invalid condition expression "UNKNOWN OR NEXT_ROUTE_ACTIVE": unknown condition type: UNKNOWN`)
+			_, err = parse("(NEXT_ROUTE_ACTIVE OR ROUTES_SET")
+			So(err, ShouldNotBeNil)
+			_, err = parse("NEXT_ROUTE_ACTIVE ROUTES_SET")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `invalid condition expression "NEXT_ROUTE_ACTIVE ROUTES_SET": unexpected ROUTES_SET`)
+			_, err = parse("NEXT_SIGNAL_ASPECTS(UK_CLEAR UK_CAUTION)")
+			So(err, ShouldNotBeNil)
+			_, err = parse("NEXT_ROUTE_ACTIVE AND")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Expressions should be evaluated", func() {
+			si := new(SignalItem)
+			ce, err := parse("TEST_CONDITION(false) OR NOT TEST_CONDITION(false) AND TEST_CONDITION(true)")
+			So(err, ShouldBeNil)
+			So(ce.IsMet(si, "UK_CLEAR"), ShouldBeTrue)
+			ce, err = parse("TEST_CONDITION(true) AND (TEST_CONDITION(false) OR NOT TEST_CONDITION(true))")
+			So(err, ShouldBeNil)
+			So(ce.IsMet(si, "UK_CLEAR"), ShouldBeFalse)
+		})
+		Convey("Parameters should be taken from the given custom property", func() {
+			si := new(SignalItem)
+			si.CustomProperties = map[string]CustomProperty{
+				"ROUTES_SET": {"UK_CLEAR": {"1"}},
+				"ROUTES_A":   {"UK_CLEAR": {"2", "3"}},
+			}
+			ce, err := parse("ROUTES_SET OR ROUTES_SET[ROUTES_A]")
+			So(err, ShouldBeNil)
+			So(ce.Operands[0].Condition.params(si, "UK_CLEAR"), ShouldResemble, []string{"1"})
+			So(ce.Operands[1].Condition.params(si, "UK_CLEAR"), ShouldResemble, []string{"2", "3"})
+		})
+		Convey("Signal states with expressions should round-trip in JSON", func() {
+			var st SignalState
+			err := json.Unmarshal([]byte(`{"aspectName": "UK_CLEAR", "expression": "ROUTES_SET[ROUTES_A] OR ROUTES_SET[ROUTES_B]"}`), &st)
+			So(err, ShouldBeNil)
+			So(st.Conditions, ShouldBeEmpty)
+			So(st.Expression, ShouldNotBeNil)
+			st.Aspect = &SignalAspect{Name: "UK_CLEAR"}
+			data, err := json.Marshal(&st)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"aspectName":"UK_CLEAR","expression":"ROUTES_SET[ROUTES_A] OR ROUTES_SET[ROUTES_B]"}`)
+		})
+		Convey("Signal states should not have both conditions and an expression", func() {
+			var st SignalState
+			err := json.Unmarshal([]byte(`{"aspectName": "UK_CLEAR", "conditions": {"NEXT_ROUTE_ACTIVE": []}, "expression": "ROUTES_SET"}`), &st)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "signal state UK_CLEAR has both conditions and an expression")
+		})
+	})
+}
+
+func TestApproachConditions(t *testing.T) {
+	Convey("Testing approach and timed conditions", t, func() {
+		var sim Simulation
+		err := json.Unmarshal(loadSim("testdata/demo.json"), &sim)
+		So(err, ShouldBeNil)
+		endChan := make(chan struct{})
+		defer close(endChan)
+		go func() {
+			for {
+				select {
+				case <-sim.EventChan:
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		err = sim.Step(2)
+		So(err, ShouldBeNil)
+		si5 := sim.TrackItems["5"].(*SignalItem)
+		Convey("The nearest approaching train should be found", func() {
+			train, distance := si5.approachingTrain(1000)
+			So(train, ShouldEqual, sim.Trains[0])
+			So(distance, ShouldBeBetween, 700, 800)
+			train, _ = si5.approachingTrain(500)
+			So(train, ShouldBeNil)
+			train, _ = sim.TrackItems["9"].(*SignalItem).approachingTrain(5000)
+			So(train, ShouldBeNil)
+		})
+		Convey("TRAIN_APPROACHING_WITHIN should check the distance of the train", func() {
+			So(TrainApproachingWithin{}.Solve(si5, []string{"1000"}, nil), ShouldBeTrue)
+			So(TrainApproachingWithin{}.Solve(si5, []string{"500"}, nil), ShouldBeFalse)
+			So(TrainApproachingWithin{}.Solve(si5, []string{"far"}, nil), ShouldBeFalse)
+		})
+		Convey("TRAIN_SPEED_BELOW should check the speed of the approaching train", func() {
+			So(TrainSpeedBelow{}.Solve(si5, []string{"10", "1000"}, nil), ShouldBeTrue)
+			So(TrainSpeedBelow{}.Solve(si5, []string{"1", "1000"}, nil), ShouldBeFalse)
+			So(TrainSpeedBelow{}.Solve(si5, []string{"10", "500"}, nil), ShouldBeFalse)
+			So(TrainSpeedBelow{}.Solve(si5, []string{"10"}, nil), ShouldBeFalse)
+		})
+		Convey("TIME_ELAPSED_SINCE_OCCUPIED should check the occupation time of the items", func() {
+			So(sim.TrackItems["2"].underlying().occupiedSince.IsZero(), ShouldBeFalse)
+			So(TimeElapsedSinceOccupied{}.Solve(si5, []string{"30"}, []string{"2"}), ShouldBeFalse)
+			err := sim.Step(12)
+			So(err, ShouldBeNil)
+			So(TimeElapsedSinceOccupied{}.Solve(si5, []string{"30"}, []string{"2"}), ShouldBeTrue)
+			So(TimeElapsedSinceOccupied{}.Solve(si5, []string{"30"}, []string{"2", "6"}), ShouldBeFalse)
+			So(TimeElapsedSinceOccupied{}.Solve(si5, []string{"30"}, nil), ShouldBeFalse)
+		})
+		Convey("Signals with approach conditions should be updated at each step", func() {
+			So(si5.updatedEachStep, ShouldBeFalse)
+			TrainApproachingWithin{}.SetupTriggers(si5, nil)
+			So(si5.updatedEachStep, ShouldBeTrue)
+		})
+	})
+}
@@ -18,18 +18,31 @@
 
 package simulation
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
 
 // TrainType defines a rolling stock type.
+//
+// Mass, Power, MaxTractiveEffort and the Davis coefficients are optional and
+// are only used by trains managers which compute the dynamics of the train.
 type TrainType struct {
-	code         string
-	Description  string   `json:"description"`
-	EmergBraking float64  `json:"emergBraking"`
-	Length       float64  `json:"length"`
-	MaxSpeed     float64  `json:"maxSpeed"`
-	StdAccel     float64  `json:"stdAccel"`
-	StdBraking   float64  `json:"stdBraking"`
-	ElementsStr  []string `json:"elements"`
+	code              string
+	Description       string   `json:"description"`
+	EmergBraking      float64  `json:"emergBraking"`
+	Length            float64  `json:"length"`
+	MaxSpeed          float64  `json:"maxSpeed"`
+	StdAccel          float64  `json:"stdAccel"`
+	StdBraking        float64  `json:"stdBraking"`
+	ElementsStr       []string `json:"elements"`
+	Mass              float64  `json:"mass,omitempty"`
+	Power             float64  `json:"power,omitempty"`
+	MaxTractiveEffort float64  `json:"maxTractiveEffort,omitempty"`
+	DavisA            float64  `json:"davisA,omitempty"`
+	DavisB            float64  `json:"davisB,omitempty"`
+	DavisC            float64  `json:"davisC,omitempty"`
 
 	simulation *Simulation
 }
@@ -45,8 +58,32 @@ func (tt *TrainType) setSimulation(sim *Simulation) {
 }
 
 // initialize this train type
+//
+// The dynamics of composite train types are derived from their elements.
 func (tt *TrainType) initialize(code string) {
 	tt.code = code
+	if len(tt.ElementsStr) > 0 {
+		tt.deriveDynamics()
+	}
+}
+
+// deriveDynamics sets the mass, power, maximum tractive effort and Davis
+// coefficients of this composite train type to the sums of those of its
+// elements.
+func (tt *TrainType) deriveDynamics() {
+	tt.Mass, tt.Power, tt.MaxTractiveEffort = 0, 0, 0
+	tt.DavisA, tt.DavisB, tt.DavisC = 0, 0, 0
+	for _, e := range tt.Elements() {
+		if e == nil {
+			continue
+		}
+		tt.Mass += e.Mass
+		tt.Power += e.Power
+		tt.MaxTractiveEffort += e.MaxTractiveEffort
+		tt.DavisA += e.DavisA
+		tt.DavisB += e.DavisB
+		tt.DavisC += e.DavisC
+	}
 }
 
 // Elements() returns the train types this TrainType is composed of.
@@ -58,27 +95,94 @@ func (tt *TrainType) Elements() []*TrainType {
 	return res
 }
 
+// elementaryTypes returns the elementary train types this TrainType is made of,
+// that is its Elements if it is a composition, or the TrainType itself otherwise.
+func (tt *TrainType) elementaryTypes() []*TrainType {
+	if len(tt.ElementsStr) == 0 {
+		return []*TrainType{tt}
+	}
+	return tt.Elements()
+}
+
+// trainTypeFor returns the TrainType made of the given elementary types.
+//
+// If no such TrainType exists in the simulation, a new composite TrainType is
+// created and added to the simulation's TrainTypes.
+func (sim *Simulation) trainTypeFor(elements []*TrainType) *TrainType {
+	if len(elements) == 1 {
+		return elements[0]
+	}
+	codes := make([]string, len(elements))
+	for i, e := range elements {
+		codes[i] = e.ID()
+	}
+	code := strings.Join(codes, "+")
+ttLoop:
+	for _, tt := range sim.TrainTypes {
+		if len(tt.ElementsStr) != len(codes) {
+			continue
+		}
+		for i, c := range tt.ElementsStr {
+			if c != codes[i] {
+				continue ttLoop
+			}
+		}
+		return tt
+	}
+	tt := TrainType{
+		Description:  code,
+		EmergBraking: math.Inf(1),
+		MaxSpeed:     math.Inf(1),
+		StdAccel:     math.Inf(1),
+		StdBraking:   math.Inf(1),
+		ElementsStr:  codes,
+	}
+	for _, e := range elements {
+		tt.Length += e.Length
+		tt.EmergBraking = math.Min(tt.EmergBraking, e.EmergBraking)
+		tt.MaxSpeed = math.Min(tt.MaxSpeed, e.MaxSpeed)
+		tt.StdAccel = math.Min(tt.StdAccel, e.StdAccel)
+		tt.StdBraking = math.Min(tt.StdBraking, e.StdBraking)
+	}
+	tt.setSimulation(sim)
+	tt.initialize(code)
+	sim.TrainTypes[code] = &tt
+	return &tt
+}
+
 // MarshalJSON for the TrainType type
 func (tt *TrainType) MarshalJSON() ([]byte, error) {
 	type auxTT struct {
-		ID           string   `json:"id"`
-		Description  string   `json:"description"`
-		EmergBraking float64  `json:"emergBraking"`
-		Length       float64  `json:"length"`
-		MaxSpeed     float64  `json:"maxSpeed"`
-		StdAccel     float64  `json:"stdAccel"`
-		StdBraking   float64  `json:"stdBraking"`
-		ElementsStr  []string `json:"elements"`
+		ID                string   `json:"id"`
+		Description       string   `json:"description"`
+		EmergBraking      float64  `json:"emergBraking"`
+		Length            float64  `json:"length"`
+		MaxSpeed          float64  `json:"maxSpeed"`
+		StdAccel          float64  `json:"stdAccel"`
+		StdBraking        float64  `json:"stdBraking"`
+		ElementsStr       []string `json:"elements"`
+		Mass              float64  `json:"mass,omitempty"`
+		Power             float64  `json:"power,omitempty"`
+		MaxTractiveEffort float64  `json:"maxTractiveEffort,omitempty"`
+		DavisA            float64  `json:"davisA,omitempty"`
+		DavisB            float64  `json:"davisB,omitempty"`
+		DavisC            float64  `json:"davisC,omitempty"`
 	}
 	att := auxTT{
-		ID:           tt.ID(),
-		Description:  tt.Description,
-		EmergBraking: tt.EmergBraking,
-		Length:       tt.Length,
-		MaxSpeed:     tt.MaxSpeed,
-		StdAccel:     tt.StdAccel,
-		StdBraking:   tt.StdBraking,
-		ElementsStr:  tt.ElementsStr,
+		ID:                tt.ID(),
+		Description:       tt.Description,
+		EmergBraking:      tt.EmergBraking,
+		Length:            tt.Length,
+		MaxSpeed:          tt.MaxSpeed,
+		StdAccel:          tt.StdAccel,
+		StdBraking:        tt.StdBraking,
+		ElementsStr:       tt.ElementsStr,
+		Mass:              tt.Mass,
+		Power:             tt.Power,
+		MaxTractiveEffort: tt.MaxTractiveEffort,
+		DavisA:            tt.DavisA,
+		DavisB:            tt.DavisB,
+		DavisC:            tt.DavisC,
 	}
 	return json.Marshal(att)
 }
@@ -23,6 +23,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -32,6 +34,9 @@ type TrainsManager interface {
 	Speed(*Train, time.Duration) float64
 	// Name of this manager used for UI messages
 	Name() string
+	// Code is the unique and stable identifier of this manager, with which
+	// trains are set to use it in simulation files and in the API.
+	Code() string
 }
 
 // The TrainStatus describe the current state of a train
@@ -64,6 +69,10 @@ const VeryHighSpeed = 999
 // minRunningSpeed is the minimum speed at which a train is considered running
 const minRunningSpeed float64 = 0.25
 
+// maxCouplingDistance is the maximum distance between two trains that can be joined.
+// Trains usually stop at a safety distance of the train in front of them.
+const maxCouplingDistance float64 = 200
+
 // Train is a stock of `TrainType` running on a track at a certain speed and to which
 // is assigned a `Service`.
 type Train struct {
@@ -78,6 +87,7 @@ type Train struct {
 	StoppedTime    time.Duration  `json:"stoppedTime"`
 	TrainTypeCode  string         `json:"trainTypeCode"`
 	TrainHead      Position       `json:"trainHead"`
+	TrainsManager  string         `json:"trainsManager"`
 
 	trainManager    TrainsManager
 	simulation      *Simulation
@@ -102,16 +112,44 @@ func (t *Train) setSimulation(sim *Simulation) {
 }
 
 // initialize attaches the Simulation to this Train and initializes it.
-func (t *Train) initialize(id string) {
+func (t *Train) initialize(id string) error {
 	t.trainID = id
-	t.effInitialDelay = t.InitialDelay.Yield()
-	if t.InitialDelay.IsNull() {
-		t.effInitialDelay = t.simulation.Options.DefaultDelayAtEntry.Yield()
+	if t.TrainsManager != "" {
+		tm, ok := t.simulation.trainsManagerOf(t.TrainsManager)
+		if !ok {
+			return fmt.Errorf("unknown trains manager: %s", t.TrainsManager)
+		}
+		t.trainManager = tm
 	}
-	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.Yield()
 	if t.trainManager == nil {
-		t.trainManager = defaultTrainManager
+		t.trainManager = t.simulation.defaultTrainsManager()
+	}
+	return nil
+}
+
+// SetTrainsManager makes this train use the driver behaviour of the trains
+// manager registered with the given code.
+func (t *Train) SetTrainsManager(code string) error {
+	tm, ok := t.simulation.trainsManagerOf(code)
+	if !ok {
+		return fmt.Errorf("unknown trains manager: %s", code)
+	}
+	t.trainManager = tm
+	t.TrainsManager = code
+	t.simulation.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+	return nil
+}
+
+// yieldDelays sets the random delays of this Train from the simulation random source.
+func (t *Train) yieldDelays() {
+	t.effInitialDelay = t.InitialDelay.yield(t.simulation.random)
+	if t.InitialDelay.IsNull() {
+		t.effInitialDelay = t.simulation.Options.DefaultDelayAtEntry.yield(t.simulation.random)
 	}
+	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.yield(t.simulation.random)
 }
 
 // Service returns a pointer to the Service assigned to this Train, or nil if no
@@ -213,6 +251,11 @@ func (t *Train) advance(timeElapsed time.Duration) {
 		return
 	}
 	t.updateSignalActions()
+	if _, ok := t.simulation.trainsManagerOf(t.trainManager.Code()); !ok {
+		// The trains manager of this train has been unregistered
+		t.trainManager = t.simulation.defaultTrainsManager()
+		t.TrainsManager = ""
+	}
 	t.Speed = t.trainManager.Speed(t, timeElapsed)
 	advanceLength := t.Speed * float64(timeElapsed) / float64(time.Second)
 	t.TrainHead = t.TrainHead.Add(advanceLength)
@@ -366,12 +409,17 @@ func (t *Train) updateSignalActions() {
 	}
 	if nsd < t.simulation.Options.DefaultSignalVisibility && (t.ignoredSignal == nil || !nextSignal.Equals(t.ignoredSignal)) {
 		// We can see the next signal aspect
-		if len(nextSignal.activeAspect.Actions) > 0 {
+		aspect := nextSignal.activeAspect
+		if nextSignal.IsFailed() {
+			// Drivers treat a failed signal as showing its most restrictive aspect
+			aspect = nextSignal.SignalType().getDefaultAspect()
+		}
+		if len(aspect.Actions) > 0 {
 			// It requires actions
 			// We check actions each time because the aspect of the signal
 			// might have changed
 			t.ignoredSignal = nil
-			t.signalActions = nextSignal.activeAspect.Actions
+			t.signalActions = aspect.Actions
 			if t.lastSignal == nil || !nsp.TrackItem().Equals(t.lastSignal) {
 				t.setActionIndex(0)
 			}
@@ -426,7 +474,7 @@ func (t *Train) checkPlace(ti TrackItem) {
 
 // jumpToNextServiceLine sets the next service line as the new active line.
 func (t *Train) jumpToNextServiceLine() {
-	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.Yield()
+	t.minStopTime = t.simulation.Options.DefaultMinimumStopTime.yield(t.simulation.random)
 	if t.NextPlaceIndex == len(t.Service().Lines)-1 {
 		// The service is ended
 		t.NextPlaceIndex = NoMorePlace
@@ -436,6 +484,24 @@ func (t *Train) jumpToNextServiceLine() {
 				_ = t.Reverse()
 			case actionSetService:
 				_ = t.AssignService(action.ActionParam)
+			case actionSplit:
+				params := strings.SplitN(action.ActionParam, ",", 2)
+				element, err := strconv.Atoi(strings.TrimSpace(params[0]))
+				if err != nil {
+					t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s: invalid split parameter %s", t.ServiceCode, action.ActionParam), softwareMsg)
+					continue
+				}
+				var serviceCode string
+				if len(params) > 1 {
+					serviceCode = strings.TrimSpace(params[1])
+				}
+				if _, err := t.Split(element, serviceCode); err != nil {
+					t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s: unable to split: %s", t.ServiceCode, err), simulationMsg)
+				}
+			case actionJoin:
+				if err := t.Join(action.ActionParam == "ahead"); err != nil {
+					t.simulation.MessageLogger.addMessage(fmt.Sprintf("Train %s: unable to join: %s", t.ServiceCode, err), simulationMsg)
+				}
 			}
 		}
 		return
@@ -490,6 +556,216 @@ func (t *Train) AssignService(srv string) error {
 	return nil
 }
 
+// Split divides this train in two after the given element of its TrainType.
+//
+// This train keeps the elements up to element (excluded) and its position,
+// while a new Train is created with the trailing elements, right behind this one.
+// The new train is assigned the service with the given code, or no service if
+// serviceCode is empty. The new Train is returned.
+func (t *Train) Split(element int, serviceCode string) (*Train, error) {
+	elements := t.TrainType().elementaryTypes()
+	if len(elements) < 2 {
+		return nil, fmt.Errorf("train type %s cannot be split", t.TrainTypeCode)
+	}
+	if element < 1 || element >= len(elements) {
+		return nil, fmt.Errorf("invalid element %d for train type %s", element, t.TrainTypeCode)
+	}
+	if !t.IsActive() {
+		return nil, errors.New("train is not on the scenery")
+	}
+	if t.Speed != 0 {
+		return nil, errors.New("train is not stopped")
+	}
+	if _, ok := t.simulation.Services[serviceCode]; serviceCode != "" && !ok {
+		return nil, fmt.Errorf("unknown service: %s", serviceCode)
+	}
+	sim := t.simulation
+	frontType := sim.trainTypeFor(elements[:element])
+	rearType := sim.trainTypeFor(elements[element:])
+	toNotify := t.removeFromTrackItems()
+	t.TrainTypeCode = frontType.ID()
+	newTrain := &Train{
+		trainID:       fmt.Sprintf("%d", len(sim.Trains)),
+		AppearTime:    Time{Time: sim.Options.CurrentTime.Time},
+		ServiceCode:   serviceCode,
+		Status:        Stopped,
+		TrainTypeCode: rearType.ID(),
+		TrainHead:     t.TrainHead.Add(-frontType.Length),
+		TrainsManager: t.TrainsManager,
+		trainManager:  t.trainManager,
+		simulation:    sim,
+		minStopTime:   sim.Options.DefaultMinimumStopTime.yield(sim.random),
+		signalActions: []SignalAction{{
+			Target: ASAP,
+			Speed:  VeryHighSpeed,
+		}},
+	}
+	if newTrain.Service() == nil {
+		newTrain.Status = Waiting
+	}
+	sim.Trains = append(sim.Trains, newTrain)
+	for ti := range t.addToTrackItems() {
+		toNotify[ti] = true
+	}
+	for ti := range newTrain.addToTrackItems() {
+		toNotify[ti] = true
+	}
+	for ti := range toNotify {
+		sim.sendEvent(&Event{
+			Name:   TrackItemChangedEvent,
+			Object: ti,
+		})
+	}
+	sim.MessageLogger.addMessage(fmt.Sprintf("Train %s has been split", t.ServiceCode), simulationMsg)
+	sim.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+	sim.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: newTrain,
+	})
+	return newTrain, nil
+}
+
+// Join couples this train with the train directly ahead of it if ahead is true,
+// or directly behind it otherwise.
+//
+// Both trains must be stopped at the same place. This train gets the composition
+// of both trains and the other one is taken out of the simulation.
+func (t *Train) Join(ahead bool) error {
+	if !t.IsActive() {
+		return errors.New("train is not on the scenery")
+	}
+	if t.Speed != 0 {
+		return errors.New("train is not stopped")
+	}
+	other := t.findTrainToJoin(ahead)
+	if other == nil {
+		return errors.New("no train to join with")
+	}
+	front, rear := t, other
+	if ahead {
+		front, rear = other, t
+	}
+	sim := t.simulation
+	elements := append(front.TrainType().elementaryTypes(), rear.TrainType().elementaryTypes()...)
+	toNotify := t.removeFromTrackItems()
+	for ti := range other.removeFromTrackItems() {
+		toNotify[ti] = true
+	}
+	if ahead {
+		if signalAhead := t.findNextSignal(); signalAhead != nil && signalAhead.train == t {
+			signalAhead.setTrain(nil)
+		}
+		t.TrainHead = other.TrainHead
+		t.signalActions = other.signalActions
+		t.actionIndex = other.actionIndex
+		t.actionTime = Time{Time: other.actionTime.Time}
+		t.lastSignal = other.lastSignal
+		t.ignoredSignal = other.ignoredSignal
+	}
+	t.TrainTypeCode = sim.trainTypeFor(elements).ID()
+	other.Status = Out
+	other.Speed = 0
+	if signalAhead := t.findNextSignal(); signalAhead != nil && (signalAhead.train == other || signalAhead.train == nil) {
+		signalAhead.setTrain(t)
+	}
+	for ti := range t.addToTrackItems() {
+		toNotify[ti] = true
+	}
+	for ti := range toNotify {
+		sim.sendEvent(&Event{
+			Name:   TrackItemChangedEvent,
+			Object: ti,
+		})
+	}
+	sim.MessageLogger.addMessage(fmt.Sprintf("Train %s has been joined with train %s", t.ServiceCode, other.ServiceCode), simulationMsg)
+	sim.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: t,
+	})
+	sim.sendEvent(&Event{
+		Name:   TrainChangedEvent,
+		Object: other,
+	})
+	return nil
+}
+
+// findTrainToJoin returns the stopped train that is directly ahead of this train
+// if ahead is true, or directly behind it otherwise, at the same place.
+//
+// It returns nil if there is no such train.
+func (t *Train) findTrainToJoin(ahead bool) *Train {
+	place := t.TrainHead.TrackItem().Place()
+	if place == nil {
+		return nil
+	}
+	for _, other := range t.simulation.Trains {
+		if other == t || !other.IsActive() || other.Speed != 0 {
+			continue
+		}
+		if other.TrainHead.TrackItem().Place() != place {
+			continue
+		}
+		var ok bool
+		if ahead {
+			_, ok = distanceAhead(t.TrainHead, other.TrainTail(), maxCouplingDistance)
+		} else {
+			_, ok = distanceAhead(t.TrainTail().Reversed(), other.TrainHead.Reversed(), maxCouplingDistance)
+		}
+		if ok {
+			return other
+		}
+	}
+	return nil
+}
+
+// distanceAhead returns the distance from the given position to the target position,
+// looking forward up to maxDistance.
+//
+// The second argument is false if target has not been found.
+func distanceAhead(from, target Position, maxDistance float64) (float64, bool) {
+	var distance float64
+	for cur := from; distance <= maxDistance; cur = cur.Next(DirectionCurrent) {
+		if cur.TrackItemID == target.TrackItemID && cur.PreviousItemID == target.PreviousItemID &&
+			target.PositionOnTI >= cur.PositionOnTI {
+			return distance + target.PositionOnTI - cur.PositionOnTI, true
+		}
+		if cur.TrackItem().Type() == TypeEnd {
+			break
+		}
+		distance += cur.TrackItem().RealLength() - cur.PositionOnTI
+	}
+	return 0, false
+}
+
+// removeFromTrackItems removes all the knowledge of this train from the track items
+// it occupies. It returns the set of track items that have been modified.
+func (t *Train) removeFromTrackItems() map[TrackItem]bool {
+	items := make(map[TrackItem]bool)
+	for _, ti := range t.trainTrackItems() {
+		ti.underlying().trainEndMutex.Lock()
+		delete(ti.underlying().trainEndsFW, t)
+		delete(ti.underlying().trainEndsBK, t)
+		ti.underlying().trainEndMutex.Unlock()
+		items[ti] = true
+	}
+	return items
+}
+
+// addToTrackItems updates the knowledge of the track items occupied by this train
+// from its current position. It returns the set of track items that have been modified.
+func (t *Train) addToTrackItems() map[TrackItem]bool {
+	items := make(map[TrackItem]bool)
+	for _, ti := range t.trainTrackItems() {
+		t.updateItemWithTrainHead(ti)
+		items[ti] = true
+	}
+	t.updateItemWithTrainTail(t.TrainTail().TrackItem())
+	return items
+}
+
 // ResetService restarts the service for the current train, as if nothing had happened.
 func (t *Train) ResetService() error {
 	t.NextPlaceIndex = 0
@@ -560,6 +836,7 @@ func (t *Train) updateStatus(timeElapsed time.Duration) {
 			Object: t,
 		})
 		t.logAndScoreTrainStoppedAtStation()
+		t.recordArrival()
 		return
 	}
 	if t.Status != Stopped {
@@ -576,6 +853,7 @@ func (t *Train) updateStatus(timeElapsed time.Duration) {
 		return
 	}
 	// Train departs
+	t.recordDeparture()
 	oldServiceCode := t.ServiceCode
 	t.jumpToNextServiceLine()
 	if oldServiceCode != t.ServiceCode {
@@ -2,3 +2,102 @@
 // See LICENSE file for full licensing details.
 
 package simulation
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTrainSplitAndJoin(t *testing.T) {
+	endChan := make(chan struct{})
+	defer close(endChan)
+	Convey("Testing train split and join", t, func() {
+		var sim Simulation
+		err := json.Unmarshal(loadSim("testdata/demo.json"), &sim)
+		So(err, ShouldBeNil)
+		go func() {
+			for {
+				select {
+				case <-sim.EventChan:
+				case <-endChan:
+					return
+				}
+			}
+		}()
+		err = sim.Initialize()
+		So(err, ShouldBeNil)
+		tr := sim.Trains[1]
+		So(tr.TrainTypeCode, ShouldEqual, "UT2")
+		tr.TrainHead = Position{simulation: &sim, TrackItemID: "10", PreviousItemID: "9", PositionOnTI: 300}
+		tr.Status = Stopped
+		tr.Speed = 0
+		tr.addToTrackItems()
+		Convey("Splitting a single unit train should fail", func() {
+			_, err := sim.Trains[0].Split(1, "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "train type UT cannot be split")
+		})
+		Convey("Splitting with a wrong element or service should fail", func() {
+			_, err := tr.Split(2, "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "invalid element 2 for train type UT2")
+			_, err = tr.Split(1, "S999")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown service: S999")
+		})
+		Convey("Splitting a running train should fail", func() {
+			tr.Speed = 5
+			_, err := tr.Split(1, "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "train is not stopped")
+		})
+		Convey("Splitting or joining a train which is not on the scenery should fail", func() {
+			for _, status := range []TrainStatus{Inactive, Out} {
+				tr.Status = status
+				_, err := tr.Split(1, "")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "train is not on the scenery")
+				err = tr.Join(true)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "train is not on the scenery")
+			}
+			So(sim.Trains, ShouldHaveLength, 2)
+		})
+		Convey("Splitting and joining back a train should work", func() {
+			nt, err := tr.Split(1, "S002")
+			So(err, ShouldBeNil)
+			So(sim.Trains, ShouldHaveLength, 3)
+			So(nt.ID(), ShouldEqual, "2")
+			So(nt.ServiceCode, ShouldEqual, "S002")
+			So(tr.TrainTypeCode, ShouldEqual, "UT")
+			So(nt.TrainTypeCode, ShouldEqual, "UT")
+			So(tr.TrainHead.PositionOnTI, ShouldEqual, 300)
+			So(nt.TrainHead.TrackItemID, ShouldEqual, "10")
+			So(nt.TrainHead.PositionOnTI, ShouldEqual, 230)
+			So(nt.TrainTail().PositionOnTI, ShouldEqual, 160)
+			So(sim.TrackItems["10"].underlying().trainEndsFW, ShouldContainKey, nt)
+			So(sim.TrackItems["10"].underlying().trainEndsFW[tr], ShouldEqual, 300)
+
+			So(tr.Join(true), ShouldNotBeNil)
+			err = nt.Join(true)
+			So(err, ShouldBeNil)
+			So(nt.TrainTypeCode, ShouldEqual, "UT2")
+			So(nt.TrainHead.PositionOnTI, ShouldEqual, 300)
+			So(tr.Status, ShouldEqual, Out)
+			So(sim.TrackItems["10"].underlying().trainEndsFW, ShouldNotContainKey, tr)
+			So(sim.TrackItems["10"].underlying().trainEndsFW[nt], ShouldEqual, 300)
+		})
+		Convey("Joining with the train behind should keep the head position", func() {
+			nt, err := tr.Split(1, "")
+			So(err, ShouldBeNil)
+			So(nt.Status, ShouldEqual, Waiting)
+			err = tr.Join(false)
+			So(err, ShouldBeNil)
+			So(tr.TrainTypeCode, ShouldEqual, "UT2")
+			So(tr.TrainHead.PositionOnTI, ShouldEqual, 300)
+			So(nt.Status, ShouldEqual, Out)
+		})
+	})
+}